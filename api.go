@@ -0,0 +1,407 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Neetless/iGoClient/igo"
+)
+
+// APISettings enable the local HTTP API.
+type APISettings struct {
+	// Listen is "127.0.0.1:<port>" or "unix:<path>". Empty disables the API.
+	// A TCP address which is not loopback is refused.
+	Listen string `json:"listen"`
+	// Token is required by every request. Empty means a random token
+	// written to api.token next to the settings file.
+	Token string `json:"token"`
+}
+
+// apiCallTimeout is how long a request waits for the frontend loop.
+const apiCallTimeout = 5 * time.Second
+
+// APIServer expose the session over HTTP.
+//
+//	GET  /rooms                       rooms and members
+//	GET  /rooms/<id>/messages?limit=n recent chat of the room
+//	POST /shout {"room": 1, "text": "hi"}
+//	POST /open  {"room": 1}
+//	POST /close {"room": 1}
+//	GET  /events                      Server-Sent Events of server events
+//
+// Requests need "Authorization: Bearer <token>". /events also accept "?token=<token>"
+// for EventSource which can't set headers. Other paths don't, so that the token
+// is not left in logs of proxies and browsers.
+// Session state is touched only in the frontend loop through Calls.
+type APIServer struct {
+	session *Session
+	token   string
+	calls   chan func()
+	srv     *http.Server
+
+	mu   sync.Mutex
+	subs map[chan []byte]bool
+}
+
+// apiRoom is a room in API responses.
+type apiRoom struct {
+	ID      int      `json:"id"`
+	Name    string   `json:"name"`
+	Owner   string   `json:"owner"`
+	Entered bool     `json:"entered"`
+	Members []string `json:"members"`
+}
+
+// apiRequest is a body of POST requests.
+type apiRequest struct {
+	Room int    `json:"room"`
+	Text string `json:"text"`
+}
+
+// NewAPIServer create APIServer. It does not listen. See StartAPI.
+func NewAPIServer(s *Session, token string) *APIServer {
+	return &APIServer{session: s, token: token, calls: make(chan func()), subs: make(map[chan []byte]bool)}
+}
+
+// StartAPI listen and serve the API when settings enable it.
+// It return nil APIServer when the API is disabled.
+func StartAPI(settings APISettings, s *Session) (*APIServer, error) {
+	if settings.Listen == "" {
+		return nil, nil
+	}
+	unix := strings.HasPrefix(settings.Listen, "unix:")
+	if !unix && !isLoopback(settings.Listen) {
+		return nil, fmt.Errorf("%s is not a loopback address", settings.Listen)
+	}
+	token := settings.Token
+	if token == "" {
+		var err error
+		if token, err = writeAPIToken(filepath.Join(filepath.Dir(settingsPath()), "api.token")); err != nil {
+			return nil, err
+		}
+	}
+
+	var ln net.Listener
+	var err error
+	if unix {
+		ln, err = listenUnix(strings.TrimPrefix(settings.Listen, "unix:"))
+	} else {
+		ln, err = net.Listen("tcp", settings.Listen)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a := NewAPIServer(s, token)
+	a.srv = &http.Server{Handler: a}
+	go func() {
+		if err := a.srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			apiLog.Error("API server stopped", "error", err)
+		}
+	}()
+	apiLog.Info("Start API", "listen", settings.Listen)
+	return a, nil
+}
+
+// listenUnix listen on a socket at path which only the user can connect to.
+// A stale socket is replaced but other files are not. The socket is made in a
+// private directory and moved to path after chmod so that it is never open to others.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// TempDir creates the directory with 0700.
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".api")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return unixListener{ln, path}, nil
+}
+
+// unixListener remove the socket moved by listenUnix on Close.
+type unixListener struct {
+	net.Listener
+	path string
+}
+
+func (l unixListener) Close() error {
+	err := l.Listener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// randomToken return a random hex token.
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// writeAPIToken create a random token and write it to path.
+func writeAPIToken(path string) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	return token, ioutil.WriteFile(path, []byte(token+"\n"), 0600)
+}
+
+// Calls return functions which the frontend loop must run.
+// A nil APIServer returns nil, a channel which never receives.
+func (a *APIServer) Calls() <-chan func() {
+	if a == nil {
+		return nil
+	}
+	return a.calls
+}
+
+// Close stop the server and end event streams.
+func (a *APIServer) Close() {
+	if a == nil || a.srv == nil {
+		return
+	}
+	a.srv.Close()
+}
+
+// Publish send the event to /events subscribers. Hidden events are skipped.
+func (a *APIServer) Publish(e Event) {
+	if a == nil || e.Hidden {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for sub := range a.subs {
+		select {
+		case sub <- b:
+		default:
+			// A slow subscriber loses events rather than blocking the frontend.
+		}
+	}
+}
+
+// call run fn in the frontend loop and wait for it.
+func (a *APIServer) call(r *http.Request, fn func()) error {
+	done := make(chan struct{})
+	select {
+	case a.calls <- func() { fn(); close(done) }:
+	case <-r.Context().Done():
+		return r.Context().Err()
+	case <-time.After(apiCallTimeout):
+		return errors.New("client is busy")
+	}
+	<-done
+	return nil
+}
+
+// authorized check the token in the request.
+func (a *APIServer) authorized(r *http.Request) bool {
+	var token string
+	if strings.Trim(r.URL.Path, "/") == "events" {
+		token = r.URL.Query().Get("token")
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+func (a *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		apiError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case path == "rooms" && r.Method == http.MethodGet:
+		a.rooms(w, r)
+	case strings.HasPrefix(path, "rooms/") && strings.HasSuffix(path, "/messages") && r.Method == http.MethodGet:
+		a.messages(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "rooms/"), "/messages"))
+	case (path == "shout" || path == "open" || path == "close") && r.Method == http.MethodPost:
+		a.send(w, r, path)
+	case path == "events" && r.Method == http.MethodGet:
+		a.events(w, r)
+	default:
+		apiError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (a *APIServer) rooms(w http.ResponseWriter, r *http.Request) {
+	rooms := []apiRoom{}
+	err := a.call(r, func() {
+		for _, ri := range *a.session.Rooms.rooms {
+			if ri.ID == 0 {
+				continue
+			}
+			room := apiRoom{ID: ri.ID, Name: ri.Name, Owner: ri.Owner, Entered: ri.Entered, Members: []string{}}
+			for _, m := range ri.Members {
+				if m != "" {
+					room.Members = append(room.Members, m)
+				}
+			}
+			rooms = append(rooms, room)
+		}
+	})
+	if err != nil {
+		apiError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	apiJSON(w, rooms)
+}
+
+func (a *APIServer) messages(w http.ResponseWriter, r *http.Request, room string) {
+	id, err := igo.ParseRoom(room)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			apiError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+	entries := []ChatEntry{}
+	err = a.call(r, func() {
+		for _, e := range a.session.Chats.RoomEntries(id) {
+			if e.Kind != KindDayChange {
+				entries = append(entries, e)
+			}
+		}
+	})
+	if err != nil {
+		apiError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	apiJSON(w, entries)
+}
+
+func (a *APIServer) send(w http.ResponseWriter, r *http.Request, action string) {
+	var req apiRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Room <= 0 {
+		apiError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	// A line break would let the rest of the text be sent as another command.
+	if err := igo.ValidText(req.Text); err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var line string
+	switch action {
+	case "shout":
+		if req.Text == "" {
+			apiError(w, http.StatusBadRequest, "empty text")
+			return
+		}
+		var err error
+		if line, err = igo.ShoutCommand(req.Room, req.Text); err != nil {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	case "open":
+		line = igo.OpenRoomCommand(req.Room)
+	case "close":
+		line = igo.CloseRoomCommand(req.Room)
+	}
+	var sendErr error
+	if err := a.call(r, func() { sendErr = a.session.Conn.Send(line) }); err != nil {
+		apiError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if sendErr != nil {
+		apiError(w, http.StatusBadGateway, sendErr.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *APIServer) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		apiError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	sub := make(chan []byte, 64)
+	a.mu.Lock()
+	a.subs[sub] = true
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.subs, sub)
+		a.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case b := <-sub:
+			fmt.Fprintf(w, "data: %s\n\n", b)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func apiJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Neetless/iGoClient/igo"
+)
+
+func newTestAPI(t *testing.T) (*Session, *APIServer, *httptest.Server, func()) {
+	s, _ := newTestSession(t)
+	a := NewAPIServer(s, "secret")
+	done := make(chan struct{})
+	// Play the frontend loop.
+	go func() {
+		for {
+			select {
+			case fn := <-a.Calls():
+				fn()
+			case <-done:
+				return
+			}
+		}
+	}()
+	srv := httptest.NewServer(a)
+	return s, a, srv, func() { srv.Close(); close(done) }
+}
+
+func apiDo(t *testing.T, method, url, body string) *http.Response {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestAPIAuth(t *testing.T) {
+	_, _, srv, stop := newTestAPI(t)
+	defer stop()
+	for _, url := range []string{srv.URL + "/rooms", srv.URL + "/rooms?token=wrong"} {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: unexpected status %d", url, resp.StatusCode)
+		}
+	}
+	resp, _ := http.Get(srv.URL + "/rooms?token=secret")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Query token should be refused except /events: %d", resp.StatusCode)
+	}
+	resp, err := http.Get(srv.URL + "/events?token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Query token should be accepted by /events: %d", resp.StatusCode)
+	}
+}
+
+func TestStartAPIRemote(t *testing.T) {
+	s, _ := newTestSession(t)
+	for _, addr := range []string{"0.0.0.0:0", ":0", "192.0.2.1:8800"} {
+		if a, err := StartAPI(APISettings{Listen: addr, Token: "secret"}, s); err == nil {
+			a.Close()
+			t.Errorf("%s should be refused", addr)
+		}
+	}
+	a, err := StartAPI(APISettings{Listen: "127.0.0.1:0", Token: "secret"}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+}
+
+func TestAPIRoomsAndMessages(t *testing.T) {
+	s, _, srv, stop := newTestAPI(t)
+	defer stop()
+	s.Events("ROOM_ADDED 3 carol 10 lobby\r\nOK OPEN_ROOM 3\r\nUSERS 3 bob\r\nMESSAGE 3 bob one\r\nMESSAGE 3 bob two\r\n")
+
+	var rooms []apiRoom
+	resp := apiDo(t, "GET", srv.URL+"/rooms", "")
+	json.NewDecoder(resp.Body).Decode(&rooms)
+	resp.Body.Close()
+	if len(rooms) != 1 || rooms[0].ID != 3 || !rooms[0].Entered || len(rooms[0].Members) != 1 {
+		t.Errorf("Unexpected rooms: %+v", rooms)
+	}
+
+	var entries []ChatEntry
+	resp = apiDo(t, "GET", srv.URL+"/rooms/3/messages?limit=1", "")
+	json.NewDecoder(resp.Body).Decode(&entries)
+	resp.Body.Close()
+	if len(entries) != 1 || entries[0].Body != "two" {
+		t.Errorf("Unexpected messages: %+v", entries)
+	}
+
+	resp = apiDo(t, "GET", srv.URL+"/rooms/x/messages", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Unexpected status for invalid room: %d", resp.StatusCode)
+	}
+}
+
+func TestAPISend(t *testing.T) {
+	s, _, srv, stop := newTestAPI(t)
+	defer stop()
+	written := s.Conn.conn.(recordConn).written
+
+	for _, c := range []struct {
+		path, body string
+		status     int
+	}{
+		{"/shout", `{"room": 2, "text": "hi"}`, http.StatusNoContent},
+		{"/open", `{"room": 5}`, http.StatusNoContent},
+		{"/close", `{"room": 5}`, http.StatusNoContent},
+		{"/shout", `{"room": 2}`, http.StatusBadRequest},
+		{"/open", `{}`, http.StatusBadRequest},
+		{"/open", `{`, http.StatusBadRequest},
+		{"/shout", `{"room": 2, "text": "hi\nREMOVE_ROOM 3"}`, http.StatusBadRequest},
+		{"/shout", `{"room": 2, "text": "hi\rLOGOUT"}`, http.StatusBadRequest},
+		{"/open", `{"room": 5, "text": "\r\n"}`, http.StatusBadRequest},
+	} {
+		resp := apiDo(t, "POST", srv.URL+c.path, c.body)
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("%s %s: unexpected status %d", c.path, c.body, resp.StatusCode)
+		}
+	}
+	if expected := "SHOUT 2 hi\r\nOPEN_ROOM 5\r\nCLOSE_ROOM 5\r\n"; written.String() != expected {
+		t.Errorf("Unexpected sent lines.\nexpected: %q\nresult: %q", expected, written.String())
+	}
+}
+
+func TestAPIEvents(t *testing.T) {
+	_, a, srv, stop := newTestAPI(t)
+	defer stop()
+	resp := apiDo(t, "GET", srv.URL+"/events", "")
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Unexpected content type: %s", ct)
+	}
+
+	// Wait the subscription.
+	for i := 0; i < 100; i++ {
+		a.mu.Lock()
+		n := len(a.subs)
+		a.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.Publish(Event{Event: igo.Event{Type: igo.EventMessage, Name: "spam"}, Hidden: true})
+	a.Publish(Event{Event: igo.ParseLine("MESSAGE 3 bob hi")})
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e igo.Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+		t.Fatalf("%q: %v", line, err)
+	}
+	if e.Type != igo.EventMessage || e.Name != "bob" || e.Text != "hi" {
+		t.Errorf("Unexpected event: %+v", e)
+	}
+}
+
+func TestAPIUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igoapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, restore := captureLog(LevelError, false)
+	defer restore()
+	s, _ := newTestSession(t)
+
+	path := filepath.Join(dir, "file")
+	ioutil.WriteFile(path, []byte("keep"), 0600)
+	if _, err := StartAPI(APISettings{Listen: "unix:" + path, Token: "secret"}, s); err == nil {
+		t.Error("A regular file should not be replaced")
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "keep" {
+		t.Errorf("The file should be kept: %q", b)
+	}
+
+	path = filepath.Join(dir, "api.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	a, err := StartAPI(APISettings{Listen: "unix:" + path, Token: "secret"}, s)
+	if err != nil {
+		t.Fatalf("A stale socket should be replaced: %v", err)
+	}
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+		t.Errorf("Unexpected socket: %v %v", info, err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 2 {
+		t.Errorf("The private directory should be removed: %d files", len(files))
+	}
+	a.Close()
+	// Serve closes the listener in its goroutine.
+	for i := 0; i < 100; i++ {
+		if _, err = os.Lstat(path); os.IsNotExist(err) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !os.IsNotExist(err) {
+		t.Errorf("The socket should be removed on close: %v", err)
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"text/template"
+	"time"
+
+	"github.com/Neetless/iGoClient/igo"
+)
+
+// Bot triggers.
+const (
+	TriggerMessage = "message"
+	TriggerEnter   = "enter"
+	TriggerLeave   = "leave"
+	TriggerTimer   = "timer"
+)
+
+// BotRules is a rules file of bot mode.
+//
+//	{
+//	  "rooms": [1],
+//	  "rate": {"count": 5, "per": "1m"},
+//	  "rules": [
+//	    {"on": "message", "match": "^!hello", "actions": [{"shout": "hi {{.Name}}"}]},
+//	    {"on": "timer", "room": 1, "every": "1h", "actions": [{"shout": "stretch!"}]}
+//	  ]
+//	}
+type BotRules struct {
+	// Rooms are opened after login.
+	Rooms []int `json:"rooms"`
+	// Log is a file written by log actions. Empty means the client log.
+	Log   string    `json:"log"`
+	Rate  BotRate   `json:"rate"`
+	Rules []BotRule `json:"rules"`
+}
+
+// BotRate limit shouts of the bot per room. Count 0 means no limit.
+type BotRate struct {
+	Count int    `json:"count"`
+	Per   string `json:"per"`
+}
+
+// BotRule run actions when the trigger happens.
+type BotRule struct {
+	// On is message, enter, leave or timer.
+	On string `json:"on"`
+	// Room limits the rule to a room. 0 means every room. Timers need a room.
+	Room int `json:"room"`
+	// Match is a regular expression on message text.
+	Match string `json:"match"`
+	// Every is an interval of timer like "10m".
+	Every   string      `json:"every"`
+	Actions []BotAction `json:"actions"`
+}
+
+// BotAction is an action of a rule. Shout and Log are templates of BotContext.
+type BotAction struct {
+	Shout string `json:"shout,omitempty"`
+	Open  int    `json:"open,omitempty"`
+	Close int    `json:"close,omitempty"`
+	Log   string `json:"log,omitempty"`
+}
+
+// BotContext is data given to templates of actions.
+type BotContext struct {
+	Time time.Time
+	Room int
+	Name string
+	Text string
+	// Match is submatches of the rule's regular expression.
+	Match []string
+}
+
+// botClient is a part of igo.Client used by Bot.
+type botClient interface {
+	Shout(room int, text string) error
+	OpenRoom(room int) error
+	CloseRoom(room int) error
+}
+
+type botRule struct {
+	BotRule
+	re     *regexp.Regexp
+	every  time.Duration
+	shouts []*template.Template
+	logs   []*template.Template
+}
+
+// Bot run rules on events.
+type Bot struct {
+	Self    string
+	rules   []botRule
+	limiter *RateLimiter
+	client  botClient
+	out     io.Writer
+}
+
+// LoadBotRules read a rules file.
+func LoadBotRules(path string) (BotRules, error) {
+	var r BotRules
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return r, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return r, nil
+}
+
+// NewBot compile rules. Log actions are written to out.
+func NewBot(self string, rules BotRules, client botClient, out io.Writer) (*Bot, error) {
+	b := &Bot{Self: self, client: client, out: out}
+	if rules.Rate.Count > 0 {
+		per, err := time.ParseDuration(rules.Rate.Per)
+		if err != nil || per <= 0 {
+			return nil, fmt.Errorf("invalid rate per %q", rules.Rate.Per)
+		}
+		b.limiter = NewRateLimiter(rules.Rate.Count, per)
+	}
+	for i, r := range rules.Rules {
+		br, err := compileBotRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %s", i+1, err.Error())
+		}
+		b.rules = append(b.rules, br)
+	}
+	return b, nil
+}
+
+func compileBotRule(r BotRule) (botRule, error) {
+	br := botRule{BotRule: r}
+	var err error
+	switch r.On {
+	case TriggerMessage, TriggerEnter, TriggerLeave:
+	case TriggerTimer:
+		if br.every, err = time.ParseDuration(r.Every); err != nil || br.every <= 0 {
+			return br, fmt.Errorf("invalid every %q", r.Every)
+		}
+		if r.Room == 0 {
+			return br, errors.New("timer needs a room")
+		}
+	default:
+		return br, fmt.Errorf("unknown trigger %q", r.On)
+	}
+	if r.Match != "" {
+		if br.re, err = regexp.Compile(r.Match); err != nil {
+			return br, err
+		}
+	}
+	if len(r.Actions) == 0 {
+		return br, errors.New("no action")
+	}
+	for _, a := range r.Actions {
+		shout, err := template.New("shout").Parse(a.Shout)
+		if err != nil {
+			return br, err
+		}
+		logLine, err := template.New("log").Parse(a.Log)
+		if err != nil {
+			return br, err
+		}
+		br.shouts = append(br.shouts, shout)
+		br.logs = append(br.logs, logLine)
+	}
+	return br, nil
+}
+
+// Handle run rules triggered by the event.
+func (b *Bot) Handle(e igo.Event) {
+	if e.Name == b.Self {
+		return
+	}
+	on := ""
+	switch e.Type {
+	case igo.EventMessage:
+		on = TriggerMessage
+	case igo.EventEnter:
+		on = TriggerEnter
+	case igo.EventLeave:
+		on = TriggerLeave
+	default:
+		return
+	}
+	for _, r := range b.rules {
+		if r.On != on || r.Room != 0 && r.Room != e.Room {
+			continue
+		}
+		ctx := BotContext{Time: e.Time, Room: e.Room, Name: e.Name, Text: e.Text}
+		if r.re != nil {
+			if ctx.Match = r.re.FindStringSubmatch(e.Text); ctx.Match == nil {
+				continue
+			}
+		}
+		b.run(r, ctx)
+	}
+}
+
+// Tick run timer rules which are due. last keeps the time each rule ran.
+func (b *Bot) Tick(now time.Time, last map[int]time.Time) {
+	for i, r := range b.rules {
+		if r.On != TriggerTimer {
+			continue
+		}
+		if t, ok := last[i]; !ok {
+			last[i] = now
+			continue
+		} else if now.Sub(t) < r.every {
+			continue
+		}
+		last[i] = now
+		b.run(r, BotContext{Time: now, Room: r.Room})
+	}
+}
+
+// run do actions of the rule.
+func (b *Bot) run(r botRule, ctx BotContext) {
+	for i, a := range r.Actions {
+		var err error
+		switch {
+		case a.Shout != "":
+			var text string
+			if text, err = execute(r.shouts[i], ctx); err != nil {
+				break
+			}
+			if b.limiter != nil && !b.limiter.Allow(ctx.Room, ctx.Time) {
+				botLog.Warn("Shout is rate limited", "room", ctx.Room)
+				continue
+			}
+			err = b.client.Shout(ctx.Room, text)
+		case a.Open != 0:
+			err = b.client.OpenRoom(a.Open)
+		case a.Close != 0:
+			err = b.client.CloseRoom(a.Close)
+		case a.Log != "":
+			var text string
+			if text, err = execute(r.logs[i], ctx); err == nil {
+				_, err = fmt.Fprintf(b.out, "%s %s\n", ctx.Time.Format(exportTimeFormat), text)
+			}
+		}
+		if err != nil {
+			botLog.Error("Action failed", "room", ctx.Room, "error", err)
+		}
+	}
+}
+
+func execute(t *template.Template, ctx BotContext) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, ctx); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// RateLimiter allow count events per duration in each room.
+type RateLimiter struct {
+	count int
+	per   time.Duration
+	sent  map[int][]time.Time
+}
+
+// NewRateLimiter create RateLimiter instance.
+func NewRateLimiter(count int, per time.Duration) *RateLimiter {
+	return &RateLimiter{count, per, make(map[int][]time.Time)}
+}
+
+// Allow record an event at now and return false when the room is over the limit.
+func (l *RateLimiter) Allow(room int, now time.Time) bool {
+	var recent []time.Time
+	for _, t := range l.sent[room] {
+		if now.Sub(t) < l.per {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= l.count {
+		l.sent[room] = recent
+		return false
+	}
+	l.sent[room] = append(recent, now)
+	return true
+}
+
+// runBot is bot mode which run rules on the server without termbox.
+func runBot(path string, server ServerSettings) int {
+	rules, err := LoadBotRules(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 2
+	}
+	out := botLog.Writer()
+	if rules.Log != "" {
+		f, err := os.OpenFile(rules.Log, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+
+	c := igo.NewClient()
+	codec, err := NewCodec(server.Encoding)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 2
+	}
+	c.Codec = codec
+	c.Sanitize = sanitizeEvent
+	bot, err := NewBot(server.User, rules, c, out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 2
+	}
+	if err := c.Connect(server.Host + ":" + server.Port); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	defer c.Close()
+	c.Login(server.profile())
+	for _, room := range rules.Rooms {
+		c.OpenRoom(room)
+	}
+
+	botLog.Info("Start bot")
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	last := make(map[int]time.Time)
+	for {
+		select {
+		case e, ok := <-c.Events():
+			if !ok {
+				return 0
+			}
+			if e.Type == igo.EventQuit {
+				botLog.Info("Disconnected", "reason", e.Text)
+				return 1
+			}
+			bot.Handle(e)
+		case now := <-tick.C:
+			bot.Tick(now, last)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Neetless/iGoClient/igo"
+)
+
+// botClientMock record actions of Bot.
+type botClientMock struct {
+	actions []string
+}
+
+func (m *botClientMock) Shout(room int, text string) error {
+	m.actions = append(m.actions, fmt.Sprintf("SHOUT %d %s", room, text))
+	return nil
+}
+func (m *botClientMock) OpenRoom(room int) error {
+	m.actions = append(m.actions, fmt.Sprintf("OPEN %d", room))
+	return nil
+}
+func (m *botClientMock) CloseRoom(room int) error {
+	m.actions = append(m.actions, fmt.Sprintf("CLOSE %d", room))
+	return nil
+}
+
+func TestBotHandle(t *testing.T) {
+	rules := BotRules{
+		Rate: BotRate{2, "1m"},
+		Rules: []BotRule{
+			{On: TriggerMessage, Match: `^!roll (\d+)`, Actions: []BotAction{{Shout: "{{.Name}} rolled {{index .Match 1}}"}}},
+			{On: TriggerEnter, Room: 2, Actions: []BotAction{{Shout: "welcome {{.Name}}"}, {Log: "{{.Name}} came"}}},
+			{On: TriggerLeave, Actions: []BotAction{{Close: 2}}},
+		},
+	}
+	m := &botClientMock{}
+	var out bytes.Buffer
+	b, err := NewBot("bot", rules, m, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2015, 9, 1, 12, 0, 0, 0, time.Local)
+	for _, e := range []igo.Event{
+		{Type: igo.EventMessage, Time: now, Room: 1, Name: "alice", Text: "!roll 6"},
+		{Type: igo.EventMessage, Time: now, Room: 1, Name: "alice", Text: "roll 6"},
+		{Type: igo.EventMessage, Time: now, Room: 1, Name: "bot", Text: "!roll 1"},
+		{Type: igo.EventEnter, Time: now, Room: 1, Name: "bob"},
+		{Type: igo.EventEnter, Time: now, Room: 2, Name: "bob"},
+		{Type: igo.EventMessage, Time: now, Room: 1, Name: "alice", Text: "!roll 2"},
+		// Rate limited
+		{Type: igo.EventMessage, Time: now, Room: 1, Name: "alice", Text: "!roll 3"},
+		{Type: igo.EventMessage, Time: now.Add(time.Minute), Room: 1, Name: "alice", Text: "!roll 4"},
+		{Type: igo.EventLeave, Time: now, Room: 2, Name: "bob"},
+	} {
+		b.Handle(e)
+	}
+	expected := []string{"SHOUT 1 alice rolled 6", "SHOUT 2 welcome bob", "SHOUT 1 alice rolled 2", "SHOUT 1 alice rolled 4", "CLOSE 2"}
+	if !reflect.DeepEqual(m.actions, expected) {
+		t.Errorf("Unexpected actions.\nexpected: %q\nresult: %q", expected, m.actions)
+	}
+	if out.String() != "2015-09-01 12:00:00 bob came\n" {
+		t.Errorf("Unexpected log: %q", out.String())
+	}
+}
+
+func TestBotTick(t *testing.T) {
+	rules := BotRules{Rules: []BotRule{{On: TriggerTimer, Room: 3, Every: "10m", Actions: []BotAction{{Shout: "ping"}}}}}
+	m := &botClientMock{}
+	b, err := NewBot("bot", rules, m, &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	last := make(map[int]time.Time)
+	for _, d := range []time.Duration{0, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 20 * time.Minute} {
+		b.Tick(now.Add(d), last)
+	}
+	if !reflect.DeepEqual(m.actions, []string{"SHOUT 3 ping", "SHOUT 3 ping"}) {
+		t.Errorf("Unexpected actions: %q", m.actions)
+	}
+}
+
+func TestNewBotErrors(t *testing.T) {
+	for _, r := range []BotRule{
+		{On: "join", Actions: []BotAction{{Shout: "x"}}},
+		{On: TriggerTimer, Every: "1m", Actions: []BotAction{{Shout: "x"}}},
+		{On: TriggerTimer, Room: 1, Every: "soon", Actions: []BotAction{{Shout: "x"}}},
+		{On: TriggerMessage, Match: "(", Actions: []BotAction{{Shout: "x"}}},
+		{On: TriggerMessage, Actions: []BotAction{{Shout: "{{.Name"}}},
+		{On: TriggerMessage},
+	} {
+		if _, err := NewBot("bot", BotRules{Rules: []BotRule{r}}, &botClientMock{}, nil); err == nil {
+			t.Errorf("NewBot should reject %+v", r)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// EntryKind identify what happened in a ChatEntry.
+type EntryKind string
+
+const (
+	// KindMessage is used for a chat message from a member.
+	KindMessage = "message"
+	// KindEnter is used when a member entered the room.
+	KindEnter = "enter"
+	// KindLeave is used when a member left the room.
+	KindLeave = "leave"
+	// KindDayChange is used for a separator inserted when the date rolls over.
+	KindDayChange = "daychange"
+)
+
+// DefaultTimestampFormat is used when settings has no timestamp format.
+const DefaultTimestampFormat = "15:04"
+
+// dayChangeFormat is a date format of the day change separator.
+const dayChangeFormat = "Mon, 02 Jan 2006"
+
+// ChatEntry is a line of a room's conversation.
+type ChatEntry struct {
+	Time   time.Time `json:"time"`
+	RoomID int       `json:"room"`
+	// Peer is the other user of a direct conversation. RoomID is 0 then.
+	Peer   string    `json:"peer,omitempty"`
+	Sender string    `json:"sender,omitempty"`
+	Body   string    `json:"body,omitempty"`
+	Kind   EntryKind `json:"kind"`
+	// Style is decided when the entry is appended. e.g. StyleMention.
+	Style string `json:"-"`
+	// Pending is set for our message until the server acknowledges it.
+	Pending bool `json:"-"`
+}
+
+// NewMessageEntry create ChatEntry for a message received now.
+func NewMessageEntry(roomID int, sender, body string) ChatEntry {
+	return ChatEntry{Time: time.Now(), RoomID: roomID, Sender: sender, Body: body, Kind: KindMessage}
+}
+
+// IsZero return true for an empty slot of EntryBox.
+func (e ChatEntry) IsZero() bool {
+	return e.Kind == ""
+}
+
+// Text return the entry as a plain line without timestamp.
+func (e ChatEntry) Text() string {
+	switch e.Kind {
+	case KindMessage:
+		return strings.TrimSpace(e.Sender + " " + e.Body)
+	case KindEnter:
+		return "-> " + e.Sender + " entered"
+	case KindLeave:
+		return "<- " + e.Sender + " left"
+	case KindDayChange:
+		return "--- " + e.Time.Format(dayChangeFormat) + " ---"
+	}
+	return ""
+}
+
+// Spans return the entry with styles. timeFormat empty means no timestamp.
+func (e ChatEntry) Spans(self, timeFormat string) []Span {
+	if e.IsZero() {
+		return []Span{{"", StyleDefault}}
+	}
+	if e.Kind == KindDayChange {
+		return []Span{{e.Text(), StyleNotice}}
+	}
+	var spans []Span
+	if timeFormat != "" {
+		spans = append(spans, Span{e.Time.Format(timeFormat) + " ", StyleTimestamp})
+	}
+	switch {
+	case e.Pending:
+		spans = append(spans, Span{e.Text() + " (sending)", StyleDim})
+	case e.Style == StyleDim:
+		spans = append(spans, Span{e.Text(), StyleDim})
+	case e.Kind != KindMessage:
+		spans = append(spans, Span{e.Text(), StyleNotice})
+	case e.Sender == self:
+		spans = append(spans, Span{e.Text(), StyleOwn})
+	default:
+		body := StyleDefault
+		if e.Style == StyleMention {
+			body = StyleMention
+		}
+		spans = append(spans, Span{e.Sender, StyleNick}, Span{" " + e.Body, body})
+	}
+	return spans
+}
+
+// sameDay return true when a and b are in the same local date.
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Local().Date()
+	by, bm, bd := b.Local().Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// EntryBox store maxLine number of ChatEntry like TextBox.
+type EntryBox struct {
+	maxLine        int
+	oldestPosition int
+	entries        []ChatEntry
+}
+
+// NewEntryBox create EntryBox instance.
+func NewEntryBox(maxLine int) *EntryBox {
+	return &EntryBox{maxLine, 0, make([]ChatEntry, maxLine)}
+}
+
+// Entry return nth newest entry.
+func (b *EntryBox) Entry(n int) ChatEntry {
+	position := (b.oldestPosition+b.maxLine-1)%b.maxLine - n
+	for position < 0 {
+		position = b.maxLine + position
+	}
+	return b.entries[position]
+}
+
+// Append append the entry and overwrite the oldest one.
+func (b *EntryBox) Append(e ChatEntry) {
+	b.entries[b.oldestPosition] = e
+	// Set position to oldest entry.
+	b.oldestPosition = (b.oldestPosition + 1) % b.maxLine
+}
+
+// Resolve clear Pending of the oldest pending entry which match and return it.
+// It return false when no entry match.
+func (b *EntryBox) Resolve(match func(e ChatEntry) bool) (ChatEntry, bool) {
+	for n := b.maxLine - 1; n >= 0; n-- {
+		position := (b.oldestPosition + b.maxLine - 1 - n) % b.maxLine
+		if e := b.entries[position]; e.Pending && match(e) {
+			b.entries[position].Pending = false
+			return b.entries[position], true
+		}
+	}
+	return ChatEntry{}, false
+}
+
+// Entries return stored entries from the oldest.
+func (b *EntryBox) Entries() []ChatEntry {
+	var entries []ChatEntry
+	for n := b.maxLine - 1; n >= 0; n-- {
+		if e := b.Entry(n); !e.IsZero() {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChatBoxDayChange(t *testing.T) {
+	roomList := NewRoomBox(20)
+	chatLogs := NewChatBox(20, roomList.rooms)
+	chatLogs.TimeFormat = "15:04"
+	chatLogs.SetCurrentRoom(1)
+
+	day1 := time.Date(2015, 9, 13, 23, 59, 0, 0, time.Local)
+	day2 := day1.Add(2 * time.Minute)
+	chatLogs.AppendEntry(ChatEntry{Time: day1, RoomID: 1, Sender: "alice", Body: "good night", Kind: KindMessage})
+	chatLogs.AppendEntry(ChatEntry{Time: day2, RoomID: 1, Sender: "bob", Kind: KindEnter})
+	chatLogs.AppendEntry(ChatEntry{Time: day2, RoomID: 1, Sender: "bob", Body: "morning", Kind: KindMessage})
+
+	expected := []string{
+		"00:01 bob morning",
+		"00:01 -> bob entered",
+		"--- Mon, 14 Sep 2015 ---",
+		"23:59 alice good night",
+		"",
+	}
+	for i, e := range expected {
+		if result := chatLogs.GetText(i); result != e {
+			t.Errorf("Unexpected line %d.\nexpected: %v\nresult: %v", i, e, result)
+		}
+	}
+	if chatLogs.UnreadTotal() != 0 {
+		t.Errorf("Current room should not have unread")
+	}
+}
+
+func TestChatBoxUnreadCountsMessagesOnly(t *testing.T) {
+	roomList := NewRoomBox(20)
+	chatLogs := NewChatBox(20, roomList.rooms)
+	chatLogs.AppendText(2, "alice hello")
+	chatLogs.AppendEntry(ChatEntry{Time: time.Now(), RoomID: 2, Sender: "bob", Kind: KindEnter})
+	dim := NewMessageEntry(2, "bot", "noise")
+	dim.Style = StyleDim
+	chatLogs.AppendEntry(dim)
+	if chatLogs.UnreadTotal() != 1 {
+		t.Errorf("Unexpected unread: %d", chatLogs.UnreadTotal())
+	}
+}
+
+func TestChatBoxGrowsLogs(t *testing.T) {
+	roomList := NewRoomBox(20)
+	chatLogs := NewChatBox(2, roomList.rooms)
+	chatLogs.AppendText(1, "alice one")
+	chatLogs.AppendEntry(ChatEntry{Time: time.Now(), Peer: "bob", Sender: "bob", Body: "hi", Kind: KindMessage})
+	chatLogs.AppendText(3, "carol three")
+	if entries := chatLogs.RoomEntries(3); len(entries) != 1 || entries[0].Body != "three" {
+		t.Errorf("A room over the initial logs should be kept: %+v", entries)
+	}
+	if entries := chatLogs.DirectEntries("bob"); len(entries) != 1 {
+		t.Errorf("The direct conversation should be kept: %+v", entries)
+	}
+	if chatLogs.UnreadTotal() != 3 {
+		t.Errorf("Unexpected unread: %d", chatLogs.UnreadTotal())
+	}
+}
+
+func TestEntryBoxOrder(t *testing.T) {
+	b := NewEntryBox(3)
+	for i := 0; i < 5; i++ {
+		b.Append(ChatEntry{RoomID: i, Kind: KindMessage})
+	}
+	for n, expected := range []int{4, 3, 2} {
+		if result := b.Entry(n).RoomID; result != expected {
+			t.Errorf("Unexpected entry %d.\nexpected: %v\nresult: %v", n, expected, result)
+		}
+	}
+}
@@ -2,17 +2,17 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"os"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
-	"unicode/utf8"
 
-	"github.com/nsf/termbox-go"
+	"github.com/Neetless/iGoClient/igo"
 )
 
 // Mode switches input and output style.
@@ -23,16 +23,59 @@ const (
 	RoomMode = "Room"
 	// ChatMode is used for identifing mode to controll chat.
 	ChatMode = "Chat"
-	// DirectMode is used for sending message directly.
+	// DirectMode is used for sending raw protocol lines. See /msg for direct messages.
 	DirectMode = "Direct"
 	// MemberMode is used for showing member
 	MemberMode = "Member"
+	// MentionMode is used for showing messages addressed to us.
+	MentionMode = "Mention"
+	// SearchMode is used for showing search results.
+	SearchMode = "Search"
+	// ScrollMode is used for showing a room history around a search result.
+	ScrollMode = "Scroll"
+	// PluginMode is used for showing a pane drawn by a plugin.
+	PluginMode = "Plugin"
+	// InspectorMode is used for showing protocol lines and sending raw lines.
+	InspectorMode = "Inspector"
 )
 
+// ConnState shows a state of the connection to the server.
+type ConnState string
+
+const (
+	// StateConnecting is used until TCP connection is established.
+	StateConnecting = "connecting"
+	// StateConnected is used while the connection is alive.
+	StateConnected = "connected"
+	// StateDisconnected is used after the server closed the connection.
+	StateDisconnected = "disconnected"
+)
+
+// ErrNotConnected is returned by Send when the session has no connection to the server.
+var ErrNotConnected = errors.New("not connected to the server")
+
 // ConnClient has a basic conversation functions for TCP connection.
 type ConnClient struct {
-	conn net.Conn
-	mode Mode
+	conn  net.Conn
+	mode  Mode
+	state ConnState
+	// Outbound can rewrite a message before Send. false drops the message.
+	Outbound func(msg string) (string, bool)
+	// Trace is called with every line written or received. Nil means no trace.
+	Trace func(line string, inbound bool)
+	// Codec convert lines to and from the wire encoding. Nil means UTF-8.
+	Codec *Codec
+
+	// queue and errs are set by StartQueue. Nil queue means Send writes at once.
+	queue chan string
+	errs  chan error
+
+	// mu guards pingSent, latency and queued which are shared with other goroutines.
+	// state is written under mu because Ping send from its goroutine.
+	mu       sync.Mutex
+	pingSent time.Time
+	latency  time.Duration
+	queued   int
 }
 
 // Ping send ping with certain interval.
@@ -41,17 +84,52 @@ func (c *ConnClient) Ping(done <-chan struct{}) {
 	for {
 		select {
 		case <-done:
-			log.Println("Ping got done")
+			connLog.Debug("Ping got done")
 			return
 		case <-waitSig:
-			c.Send("PING -1")
+			c.mu.Lock()
+			c.pingSent = time.Now()
+			c.mu.Unlock()
+			c.sendInternal("PING -1")
 			c.conn.SetReadDeadline(time.Now().Add(400 * time.Second))
 			c.conn.SetWriteDeadline(time.Now().Add(400 * time.Second))
 		}
 	}
 }
 
-// Receive get message from server
+// PingAcked record round trip time of the last PING.
+func (c *ConnClient) PingAcked() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.pingSent.IsZero() {
+		c.latency = time.Since(c.pingSent)
+	}
+}
+
+// setState change the state of the connection.
+func (c *ConnClient) setState(state ConnState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = state
+}
+
+// connected return true when lines can be written to the server.
+func (c *ConnClient) connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil && c.state != StateDisconnected
+}
+
+// Latency return round trip time of the last PING.
+func (c *ConnClient) Latency() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.latency
+}
+
+// Receive get message from server. The channel is closed when reading fails,
+// like when the server closed the connection or it timed out, or done is closed.
+// See Session.Disconnected.
 func (c *ConnClient) Receive(done <-chan struct{}) <-chan string {
 	out := make(chan string)
 	go func() {
@@ -60,283 +138,187 @@ func (c *ConnClient) Receive(done <-chan struct{}) <-chan string {
 			time.Sleep(5 * time.Millisecond)
 			select {
 			case <-done:
-				log.Println("Receive got done")
+				connLog.Debug("Receive got done")
 				return
 			default:
 				msg := make([]byte, 1024)
 				readlen, err := c.conn.Read(msg)
-				if err != nil {
-					if err.Error() == io.EOF.Error() {
-						out <- "quit"
+				if readlen > 0 {
+					text := string(msg[:readlen])
+					if c.Codec != nil {
+						var warning error
+						if text, warning = c.Codec.Decode(msg[:readlen]); warning != nil {
+							c.warn(warning)
+						}
+					}
+					select {
+					case out <- text:
+					case <-done:
 						return
 					}
 				}
-				log.Println("Server responce: " +
-					string(msg[:readlen]))
-				out <- string(msg[:readlen])
-				log.Println("Send responce channel")
+				if err == io.EOF {
+					connLog.Info("Server closed the connection")
+					return
+				} else if err != nil {
+					connLog.Warn("Cannot read from the server", "error", err)
+					return
+				}
 			}
 		}
 	}()
 	return out
 }
 
-// Send send message to server.
+// Send send message to server. It is queued after StartQueue.
 func (c *ConnClient) Send(msg string) error {
-	_, err := c.conn.Write([]byte(msg + "\r\n")[:])
+	_, err := c.send(msg, true)
 	return err
 }
 
-func main() {
-	file, err := os.OpenFile("./log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		log.Fatal("error opening file :", err.Error())
-	}
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-	log.SetOutput(file)
+// sendInternal send a protocol line the client sends by itself like PING or LOGIN.
+// It skip Outbound so that plugins can't drop or rewrite keepalives and credentials.
+func (c *ConnClient) sendInternal(msg string) error {
+	_, err := c.send(msg, false)
+	return err
+}
 
-	// Set termbox
-	if err := termbox.Init(); err != nil {
-		fmt.Println("ERROR: Cannot initialize termbox")
-		os.Exit(1)
+// send apply Outbound when hooks is set and write or queue the message.
+// Plugins clear hooks so that their own lines don't go through send hooks again.
+// It return the message actually sent and empty when Outbound drops it.
+// ErrNotConnected is returned before or after the connection.
+func (c *ConnClient) send(msg string, hooks bool) (string, error) {
+	if !c.connected() {
+		return "", ErrNotConnected
+	}
+	if hooks && c.Outbound != nil {
+		var ok bool
+		if msg, ok = c.Outbound(msg); !ok {
+			return "", nil
+		}
+	}
+	if err := igo.ValidText(msg); err != nil {
+		return "", err
+	}
+	if c.queue != nil {
+		return msg, c.enqueue(msg)
 	}
-	defer termbox.Close()
-	// Set screens
-	eb := &EditBox{}
-	ws := &WholeScreen{}
-	connMsg := NewTextBox(20)
-	roomList := NewRoomBox(20)
-	chatLogs := NewChatBox(20, roomList.rooms)
+	return msg, c.write(msg)
+}
 
-	ts := &TextScreen{}
-	ts.SetTextArea(connMsg)
-	ws.append(eb)
-	ws.append(ts)
+// write send a message without Outbound. A message with CR or LF is rejected.
+func (c *ConnClient) write(msg string) error {
+	if err := igo.ValidText(msg); err != nil {
+		return err
+	}
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	if c.Trace != nil {
+		c.Trace(msg, false)
+	}
+	connLog.Debug("Protocol line", "dir", "out", "line", msg)
+	b := []byte(msg + "\r\n")
+	if c.Codec != nil {
+		var err error
+		if b, err = c.Codec.Encode(msg + "\r\n"); err != nil {
+			return err
+		}
+	}
+	_, err := c.conn.Write(b)
+	return err
+}
 
-	// Draw initial screen
-	termbox.SetInputMode(termbox.InputEsc)
-	ws.drawAll()
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(os.Args[2:]))
+	}
+	lineMode := flag.Bool("line-mode", false, "read commands from stdin and print events to stdout")
+	format := flag.String("format", LineText, "output format of line mode: text or json")
+	botRules := flag.String("bot", "", "run as a bot with the rules file")
+	ircAddr := flag.String("irc", "", "run as an IRC gateway listening on the address like 127.0.0.1:6667. It must be loopback")
+	webAddr := flag.String("web", "", "serve the browser UI on the address like 127.0.0.1:8080")
+	webRemote := flag.Bool("web-remote", false, "allow -web on an address which is not loopback")
+	serverName := flag.String("server", "", "name of the server in settings used by -line-mode, -web, -bot and -irc. Default is the first")
+	logLevel := flag.String("log-level", "", "log level: debug, info, warn or error")
+	logFile := flag.String("log-file", "", "log file. - means stderr. Default is in XDG state directory")
+	flag.Parse()
+
+	settings, settingsErr := LoadSettings(settingsPath())
+	// Flags apply to this run only. settings are saved by /filters and /ignore.
+	logSettings := settings.Log
+	if *logLevel != "" {
+		logSettings.Level = *logLevel
+	}
+	if *logFile != "" {
+		logSettings.File = *logFile
+	}
+	logCloser, err := SetupLogging(logSettings)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: cannot set up logging: %s\n", err.Error())
+		os.Exit(1)
+	}
+	defer logCloser.Close()
+	if settingsErr != nil {
+		mainLog.Warn("Cannot load settings", "error", settingsErr)
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", settingsErr.Error())
+	}
 
-	log.Println("Start TCP setting")
-	// Set TCP connection
-	tcpAddr, err := net.ResolveTCPAddr("tcp", Host+":"+Port)
+	servers, err := settings.servers()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
-		return
+		os.Exit(2)
 	}
-
-	// Following are testing code for loacl environment
-	// clientAddr := new(net.TCPAddr)
-	// clientAddr.IP = net.ParseIP(ClientIP)
-	// clientAddr.Port, _ = strconv.Atoi(ClientPort)
-
-	log.Println("Start TCP dial")
-	//conn, err := net.DialTCP("tcp", clientAddr, tcpAddr)
-	conn, err := net.DialTCP("tcp", nil, tcpAddr)
+	server, err := selectServer(servers, *serverName)
 	if err != nil {
-		log.Printf("Error: %s\n", err.Error())
-		return
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(2)
+	}
+	if *botRules != "" {
+		os.Exit(runBot(*botRules, server))
+	}
+	if *ircAddr != "" {
+		os.Exit(runIRCGateway(*ircAddr, server))
 	}
 
-	done := make(chan struct{})
-	defer close(done)
-	defer conn.Close()
-
-	log.Println("Set TCP conn deadline")
-	conn.SetReadDeadline(time.Now().Add(400 * time.Second))
-	conn.SetWriteDeadline(time.Now().Add(400 * time.Second))
-
-	c := &ConnClient{conn: conn, mode: DirectMode}
-
-	log.Println("Start sending PING message")
-	go c.Ping(done)
-
-	log.Println("Start receiving message")
-	response := c.Receive(done)
-
-	log.Println("Start getting keyboard inputs")
-	keyInput := Input(done)
-
-	log.Println("Start login conversation")
-	loginConversation(c)
-
-	log.Println("Start main loop")
-	for {
-		select {
-		case k := <-keyInput:
-			switch k.Type {
-			case termbox.EventKey:
-				switch k.Key {
-				case termbox.KeyArrowRight, termbox.KeyCtrlF:
-					eb.MoveCursorOneRuneForward()
-				case termbox.KeyArrowLeft, termbox.KeyCtrlB:
-					eb.MoveCursorOneRuneBackward()
-				case termbox.KeyBackspace, termbox.KeyBackspace2:
-					eb.DeleteRuneBackward()
-				case termbox.KeyEnter:
-					bmsg := eb.GetAndDeleteText()
-					message := string(bmsg[:])
-
-					// Exit process
-					msgTokens := strings.Split(message, " ")
-					switch msgTokens[0] {
-					case "quit":
-						log.Println("Exit by quit signal from keyboard input")
-						c.Send("LOGOUT")
-						done <- struct{}{}
-						done <- struct{}{}
-						// Unlock channel
-						<-response
-						done <- struct{}{}
-						return
-					}
-
-					// Implement input msg process
-					var arrangedMsg string
-					switch c.mode {
-					case DirectMode:
-						arrangedMsg = message
-					case RoomMode:
-						// Get parameter like "OPEN 1".
-						switch msgTokens[0] {
-						case "open":
-							arrangedMsg = "OPEN_ROOM " + msgTokens[1]
-						case "close":
-							arrangedMsg = "CLOSE_ROOM " + msgTokens[1]
-						}
-					case ChatMode:
-						if msgTokens[0] == "room" {
-							roomID, _ := strconv.Atoi(msgTokens[1])
-							chatLogs.CurrentRoomID = roomID
-							// Skip send message
-							continue
-						}
-						// Send chat message
-						if chatLogs.CurrentRoomID != NotExist {
-							arrangedMsg = "SHOUT " +
-								fmt.Sprintf("%d ", chatLogs.CurrentRoomID) +
-								message
-						} else {
-							// Skip send message
-							continue
-						}
-					case MemberMode:
-						continue
-					}
-
-					// Server require new line character
-					c.Send(arrangedMsg)
-				case termbox.KeyEsc:
-					log.Println("Exit by KeyEsc signal")
-					done <- struct{}{}
-					return
-				case termbox.KeySpace:
-					r, _ := utf8.DecodeLastRune([]byte(" "))
-					eb.InsertRune(r)
-				case termbox.KeyF9:
-					switch c.mode {
-					case DirectMode:
-						c.mode = RoomMode
-						ts.SetTextArea(roomList)
-					case RoomMode:
-						c.mode = ChatMode
-						chatLogs.ShowRoomMember = false
-						ts.SetTextArea(chatLogs)
-					case ChatMode:
-						c.mode = MemberMode
-						chatLogs.ShowRoomMember = true
-					case MemberMode:
-						c.mode = DirectMode
-						ts.SetTextArea(connMsg)
-					}
-
-				case termbox.KeyF3:
-					ts.SetTextArea(connMsg)
-				default:
-					eb.InsertRune(k.Ch)
-				}
-			case termbox.EventError:
-				done <- struct{}{}
-				return
-			}
-		case responseMsg := <-response:
-			texts := strings.Split(responseMsg, "\r\n")
-			for _, s := range texts {
-				connMsg.AppendText("Server response: " + s)
-				tokens := strings.Split(s, " ")
-				switch tokens[0] {
-				case "quit":
-					log.Println("Exit by quit signal from server message")
-					done <- struct{}{}
-					return
-				case "MESSAGE":
-					var chat string
-					for _, str := range tokens[2:] {
-						chat += str + " "
-					}
-					id, _ := strconv.Atoi(tokens[1])
-					chatLogs.AppendText(id, chat)
-				case "OK":
-					switch tokens[1] {
-					case "PING":
-						c.conn.SetReadDeadline(time.Now().Add(400 * time.Second))
-						c.conn.SetWriteDeadline(time.Now().Add(400 * time.Second))
-					case "OPEN_ROOM":
-						id, _ := strconv.Atoi(tokens[2])
-						chatLogs.CurrentRoomID = id
-						roomList.EnterRoom(id)
-					case "ADD_ROOM":
-						id, _ := strconv.Atoi(tokens[2])
-						chatLogs.CurrentRoomID = id
-					case "CLOSE_ROOM":
-						id, _ := strconv.Atoi(tokens[2])
-						roomList.QuitRoom(id)
-					}
-				case "SVR_PING":
-					c.Send("OK SVR_PING")
-				case "ROOM_ADDED":
-					id, _ := strconv.Atoi(tokens[1])
-					ri := NewRoomInfo(id, tokens[4], tokens[2])
-					roomList.AppendRoom(ri)
-				case "ROOM_REMOVED":
-					id, _ := strconv.Atoi(tokens[1])
-					roomList.RemoveRoom(id)
-				case "ENTER":
-					id, _ := strconv.Atoi(tokens[1])
-					roomList.OtherEnterRoom(id, tokens[2])
-				case "LEAVE":
-					id, _ := strconv.Atoi(tokens[1])
-					roomList.OtherLeaveRoom(id, tokens[2])
-				case "USERS":
-					id, _ := strconv.Atoi(tokens[1])
-					users := strings.Split(tokens[2], ":")
-					for _, user := range users {
-						roomList.OtherEnterRoom(id, user)
-					}
-				}
+	s := NewSession(&settings, server)
 
-			}
-		default:
-			termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
-			ws.drawAll()
+	if *lineMode {
+		code := runLineMode(s, os.Stdin, os.Stdout, *format)
+		s.Close()
+		os.Exit(code)
+	}
+	if *webAddr != "" {
+		code := runWeb(s, *webAddr, *webRemote)
+		s.Close()
+		os.Exit(code)
+	}
+	sessions := []*Session{s}
+	for _, other := range servers {
+		if other.Name != server.Name {
+			sessions = append(sessions, NewSession(&settings, other))
 		}
 	}
+	defer func() {
+		for _, s := range sessions {
+			s.Close()
+		}
+	}()
+	runTUI(sessions)
 }
 
-func scan(done chan struct{}) <-chan string {
+// scan read lines from r. The channel is closed at the end of r.
+func scan(done <-chan struct{}, r io.Reader) <-chan string {
 	out := make(chan string)
 	go func() {
 		defer close(out)
-		for {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
 			select {
 			case <-done:
-				log.Println("scan got done")
+				mainLog.Debug("scan got done")
 				return
-			default:
-				reader := bufio.NewReader(os.Stdin)
-				input, _ := reader.ReadString('\n')
-				input += "\r\n"
-				out <- input
+			case out <- strings.TrimRight(scanner.Text(), "\r"):
 			}
 		}
 	}()
@@ -354,6 +336,15 @@ func errCheck(err error) {
 	}
 }
 
+// splitSender split a chat line into the sender's name and the text.
+func splitSender(chat string) (string, string) {
+	fields := strings.SplitN(chat, " ", 2)
+	if len(fields) < 2 {
+		return fields[0], ""
+	}
+	return fields[0], fields[1]
+}
+
 type userInfo struct {
 	user         string
 	id           int
@@ -361,12 +352,3 @@ type userInfo struct {
 	level        string
 	clientInfo   string
 }
-
-func loginConversation(c *ConnClient) {
-	// user is defined in global
-	c.Send("LOGIN " + User.user)
-	c.Send("SET_INTRO " + User.introduction)
-	c.Send("SET_LEVEL " + User.level)
-	c.Send("CLIENT_INFO " + User.clientInfo)
-	c.Send("SET_ID " + fmt.Sprintf("%d", User.id))
-}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -19,7 +20,7 @@ func (c ConnMock) Read(b []byte) (n int, err error) {
 	return len(b), nil
 }
 func (c ConnMock) Write(b []byte) (n int, err error) {
-	fmt.Fprintf(os.Stdout, string(b[:len(b)]))
+	fmt.Fprint(os.Stdout, string(b[:len(b)]))
 	return
 }
 func (c ConnMock) Close() error                       { return nil }
@@ -88,3 +89,25 @@ func TestDone(t *testing.T) {
 		}
 	}
 }
+
+// resetConn fail every read like a connection reset by the peer.
+type resetConn struct{ ConnMock }
+
+func (resetConn) Read(b []byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+func TestReceiveReadError(t *testing.T) {
+	c := &ConnClient{conn: resetConn{}}
+	done := make(chan struct{})
+	defer close(done)
+	receive := c.Receive(done)
+	select {
+	case chunk, ok := <-receive:
+		if ok {
+			t.Errorf("Nothing should be received: %q", chunk)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Receive should stop on a read error")
+	}
+}
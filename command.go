@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Command is a slash command typed in EditBox like "/ignore bot*".
+type Command struct {
+	Usage string
+	// Run get arguments after the command name and return lines to show.
+	Run func(args []string) ([]string, error)
+	// RunText get the text after the command name as typed. It is used instead of Run when set.
+	RunText func(text string) ([]string, error)
+}
+
+// CommandSet dispatch slash commands by its name.
+type CommandSet map[string]Command
+
+// NewCommandSet create CommandSet which has /help command.
+func NewCommandSet() CommandSet {
+	cs := CommandSet{}
+	cs.Register("help", "/help", func(args []string) ([]string, error) {
+		var lines []string
+		for _, name := range cs.names() {
+			lines = append(lines, cs[name].Usage)
+		}
+		return lines, nil
+	})
+	return cs
+}
+
+// Register add a command. Same name overwrite the old one.
+func (cs CommandSet) Register(name, usage string, run func(args []string) ([]string, error)) {
+	cs[name] = Command{Usage: usage, Run: run}
+}
+
+// RegisterText add a command which get the text after its name as typed.
+// It is for arguments whose spaces matter like a regular expression.
+func (cs CommandSet) RegisterText(name, usage string, run func(text string) ([]string, error)) {
+	cs[name] = Command{Usage: usage, RunText: run}
+}
+
+// Only return CommandSet which has only the named commands and its own /help.
+// Unknown names are skipped.
+func (cs CommandSet) Only(names ...string) CommandSet {
+	only := NewCommandSet()
+	for _, name := range names {
+		if c, ok := cs[name]; ok && name != "help" {
+			only[name] = c
+		}
+	}
+	return only
+}
+
+// IsCommand return true when the line should be handled by CommandSet.
+func IsCommand(line string) bool {
+	return strings.HasPrefix(line, "/") && len(strings.TrimSpace(line)) > 1
+}
+
+// Execute run the command written in line.
+func (cs CommandSet) Execute(line string) ([]string, error) {
+	fields := strings.Fields(strings.TrimPrefix(line, "/"))
+	if len(fields) == 0 {
+		return nil, errors.New("empty command")
+	}
+	cmd, ok := cs[fields[0]]
+	if !ok {
+		return nil, errors.New("unknown command /" + fields[0] + ". Type /help")
+	}
+	if cmd.RunText != nil {
+		return cmd.RunText(textAfter(strings.TrimPrefix(line, "/"), 1))
+	}
+	return cmd.Run(fields[1:])
+}
+
+// textAfter return text after n words as typed. Spaces before the rest are skipped.
+func textAfter(text string, n int) string {
+	for i := 0; i < n; i++ {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		j := strings.IndexFunc(text, unicode.IsSpace)
+		if j < 0 {
+			return ""
+		}
+		text = text[j:]
+	}
+	return strings.TrimLeftFunc(text, unicode.IsSpace)
+}
+
+func (cs CommandSet) names() []string {
+	var names []string
+	for name := range cs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// errUsage return an error which shows how to use the command.
+func errUsage(usage string) error {
+	return errors.New("usage: " + usage)
+}
+
+// showCommandResult append command output to tb and show the last line on sb.
+func showCommandResult(tb *TextBox, sb *StatusBar, lines []string, err error) {
+	for _, line := range lines {
+		tb.AppendStyledText(line, StyleNotice)
+	}
+	if err != nil {
+		tb.AppendStyledText(err.Error(), StyleError)
+		sb.SetMessage(err.Error())
+		return
+	}
+	if len(lines) > 0 {
+		sb.SetMessage(lines[len(lines)-1])
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestCommandSet(t *testing.T) {
+	cs := NewCommandSet()
+	var got []string
+	cs.Register("echo", "/echo <text>", func(args []string) ([]string, error) {
+		got = args
+		return args, nil
+	})
+
+	if !IsCommand("/echo a") || IsCommand("/") || IsCommand("hello /echo") {
+		t.Errorf("Unexpected IsCommand result")
+	}
+	if _, err := cs.Execute("/echo  a b"); err != nil || len(got) != 2 || got[1] != "b" {
+		t.Errorf("Unexpected args: %v %v", got, err)
+	}
+	if _, err := cs.Execute("/nothing"); err == nil {
+		t.Errorf("Unknown command should be an error")
+	}
+	lines, _ := cs.Execute("/help")
+	if len(lines) != 2 || lines[0] != "/echo <text>" {
+		t.Errorf("Unexpected help: %v", lines)
+	}
+}
+
+func TestCommandSetOnly(t *testing.T) {
+	cs := NewCommandSet()
+	cs.Register("echo", "/echo <text>", func(args []string) ([]string, error) { return args, nil })
+	cs.Register("export", "/export", func(args []string) ([]string, error) { return nil, nil })
+	only := cs.Only("echo", "missing")
+	if _, err := only.Execute("/export"); err == nil {
+		t.Errorf("/export should not be in the set")
+	}
+	if lines, _ := only.Execute("/help"); len(lines) != 2 || lines[0] != "/echo <text>" || lines[1] != "/help" {
+		t.Errorf("Unexpected help: %v", lines)
+	}
+}
+
+func TestCommandSetRegisterText(t *testing.T) {
+	cs := NewCommandSet()
+	cs.RegisterText("echo", "/echo <text>", func(text string) ([]string, error) { return []string{text}, nil })
+	if lines, _ := cs.Execute("/echo  a  b\tc "); len(lines) != 1 || lines[0] != "a  b\tc " {
+		t.Errorf("Text should be kept as typed: %q", lines)
+	}
+	if lines, _ := cs.Execute("/echo"); len(lines) != 1 || lines[0] != "" {
+		t.Errorf("Unexpected empty text: %q", lines)
+	}
+	for _, c := range []struct {
+		text string
+		n    int
+		rest string
+	}{
+		{"add 3 a  b", 2, "a  b"},
+		{"  add\t3   a b", 2, "a b"},
+		{"add 3", 2, ""},
+		{"add", 0, "add"},
+	} {
+		if rest := textAfter(c.text, c.n); rest != c.rest {
+			t.Errorf("textAfter(%q, %d): unexpected %q", c.text, c.n, rest)
+		}
+	}
+}
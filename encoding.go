@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+)
+
+const (
+	// EncodingUTF8 is the default wire encoding.
+	EncodingUTF8 = "utf-8"
+	// EncodingShiftJIS is used by some legacy servers.
+	EncodingShiftJIS = "shift_jis"
+	// EncodingEUCJP is used by some legacy servers.
+	EncodingEUCJP = "euc-jp"
+	// EncodingAuto detect one of the above from the first non-ASCII bytes received.
+	EncodingAuto = "auto"
+)
+
+// wireEncodings are encodings which Codec can use. UTF-8 is nil.
+var wireEncodings = map[string]encoding.Encoding{
+	EncodingUTF8:     nil,
+	EncodingShiftJIS: japanese.ShiftJIS,
+	EncodingEUCJP:    japanese.EUCJP,
+}
+
+// detectOrder is encodings tried by detection. EUC-JP is stricter than Shift_JIS.
+var detectOrder = []string{EncodingUTF8, EncodingEUCJP, EncodingShiftJIS}
+
+// Codec convert lines between UTF-8 strings and the wire encoding of a server.
+// Decode is called from Receive goroutine and Encode from the send queue.
+type Codec struct {
+	mu   sync.Mutex
+	name string
+	// auto is true until the encoding is detected.
+	auto bool
+	// rest is bytes of a character split at the end of the last chunk.
+	rest []byte
+	// warned is set after an invalid chunk was reported.
+	warned bool
+}
+
+// NewCodec create Codec of the encoding name. Empty name means UTF-8.
+func NewCodec(name string) (*Codec, error) {
+	name = strings.ToLower(name)
+	switch name {
+	case "", "utf8":
+		name = EncodingUTF8
+	case "sjis", "shift-jis", "cp932":
+		name = EncodingShiftJIS
+	case "eucjp", "euc_jp":
+		name = EncodingEUCJP
+	}
+	if name == EncodingAuto {
+		return &Codec{name: EncodingUTF8, auto: true}, nil
+	}
+	if _, ok := wireEncodings[name]; !ok {
+		return nil, fmt.Errorf("unknown encoding %q. Use utf-8, shift_jis, euc-jp or auto", name)
+	}
+	return &Codec{name: name}, nil
+}
+
+// Name return the encoding in use. It is utf-8 until auto detection decides.
+func (c *Codec) Name() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.name
+}
+
+// Encode convert a line to the wire encoding.
+func (c *Codec) Encode(s string) ([]byte, error) {
+	enc := wireEncodings[c.Name()]
+	if enc == nil {
+		return []byte(s), nil
+	}
+	b, err := enc.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode %q in %s", s, c.Name())
+	}
+	return b, nil
+}
+
+// Decode convert a chunk from the wire to a string. A character split at the end
+// is kept for the next chunk. A non-nil error is a warning about invalid bytes and
+// it is returned only once.
+func (c *Codec) Decode(chunk []byte) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := append(c.rest, chunk...)
+	c.rest = nil
+
+	var warning error
+	if c.auto {
+		if name, ok := detectEncoding(b); ok {
+			c.name = name
+			c.auto = false
+			connLog.Info("Detected encoding", "encoding", name)
+		}
+	}
+	n, valid := scanEncoding(c.name, b)
+	if !valid && !c.warned {
+		c.warned = true
+		warning = fmt.Errorf("received bytes are not valid %s", c.name)
+		if name, ok := detectEncoding(b); ok && name != c.name {
+			warning = fmt.Errorf("received bytes are not valid %s. They look like %s", c.name, name)
+		}
+	}
+	if valid {
+		c.rest = append([]byte(nil), b[n:]...)
+		b = b[:n]
+	}
+
+	enc := wireEncodings[c.name]
+	if enc == nil {
+		return string(b), warning
+	}
+	s, err := enc.NewDecoder().Bytes(b)
+	if err != nil {
+		return string(b), warning
+	}
+	return string(s), warning
+}
+
+// detectEncoding return the first encoding in which b is valid.
+// ASCII only bytes tell nothing.
+func detectEncoding(b []byte) (string, bool) {
+	ascii := true
+	for _, c := range b {
+		if c >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return "", false
+	}
+	for _, name := range detectOrder {
+		if _, valid := scanEncoding(name, b); valid {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// scanEncoding check b in the encoding. It return the length of complete characters
+// and false when an invalid byte is found. Bytes after n are an incomplete character.
+func scanEncoding(name string, b []byte) (int, bool) {
+	i := 0
+	for i < len(b) {
+		size := 0
+		switch name {
+		case EncodingShiftJIS:
+			size = sjisCharLen(b[i:])
+		case EncodingEUCJP:
+			size = eucjpCharLen(b[i:])
+		default:
+			if !utf8.FullRune(b[i:]) {
+				size = 0
+			} else if r, s := utf8.DecodeRune(b[i:]); r != utf8.RuneError || s > 1 {
+				size = s
+			} else {
+				size = -1
+			}
+		}
+		if size < 0 {
+			return i, false
+		}
+		if size == 0 {
+			return i, true
+		}
+		i += size
+	}
+	return i, true
+}
+
+// sjisCharLen return the length of the first Shift_JIS character.
+// It is 0 for an incomplete character and -1 for an invalid one.
+func sjisCharLen(b []byte) int {
+	c := b[0]
+	switch {
+	case c < 0x80 || 0xa1 <= c && c <= 0xdf:
+		return 1
+	case 0x81 <= c && c <= 0x9f || 0xe0 <= c && c <= 0xfc:
+		if len(b) < 2 {
+			return 0
+		}
+		if t := b[1]; 0x40 <= t && t <= 0x7e || 0x80 <= t && t <= 0xfc {
+			return 2
+		}
+	}
+	return -1
+}
+
+// eucjpCharLen return the length of the first EUC-JP character.
+// It is 0 for an incomplete character and -1 for an invalid one.
+func eucjpCharLen(b []byte) int {
+	c := b[0]
+	size := 2
+	switch {
+	case c < 0x80:
+		return 1
+	case c == 0x8e:
+		if len(b) < 2 {
+			return 0
+		}
+		if 0xa1 <= b[1] && b[1] <= 0xdf {
+			return 2
+		}
+		return -1
+	case c == 0x8f:
+		size = 3
+	case c < 0xa1 || c == 0xff:
+		return -1
+	}
+	for i := 1; i < size; i++ {
+		if i >= len(b) {
+			return 0
+		}
+		if b[i] < 0xa1 || b[i] == 0xff {
+			return -1
+		}
+	}
+	return size
+}
+
+// warn log a problem of the connection and report it with write errors.
+func (c *ConnClient) warn(err error) {
+	connLog.Warn("Connection problem", "error", err)
+	if c.errs == nil {
+		return
+	}
+	select {
+	case c.errs <- err:
+	default:
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Bytes of "MESSAGE 3 bob こんにちは" in legacy encodings.
+var (
+	sjisHello  = "MESSAGE 3 bob \x82\xb1\x82\xf1\x82\xc9\x82\xbf\x82\xcd\r\n"
+	eucjpHello = "MESSAGE 3 bob \xa4\xb3\xa4\xf3\xa4\xcb\xa4\xc1\xa4\xcf\r\n"
+	utf8Hello  = "MESSAGE 3 bob こんにちは\r\n"
+)
+
+func TestCodec(t *testing.T) {
+	c, err := NewCodec("Shift_JIS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A character split between chunks is kept.
+	first, err := c.Decode([]byte(sjisHello[:15]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := c.Decode([]byte(sjisHello[15:]))
+	if err != nil || first+rest != utf8Hello {
+		t.Errorf("Unexpected text: %q %q %v", first, rest, err)
+	}
+	if b, err := c.Encode(utf8Hello); err != nil || string(b) != sjisHello {
+		t.Errorf("Unexpected bytes: %q %v", b, err)
+	}
+	if _, err := c.Encode("🍣"); err == nil {
+		t.Error("A character not in Shift_JIS should fail")
+	}
+
+	if _, err := NewCodec("latin1"); err == nil {
+		t.Error("Unknown encoding should fail")
+	}
+}
+
+func TestCodecWarning(t *testing.T) {
+	c, _ := NewCodec("")
+	_, err := c.Decode([]byte(eucjpHello))
+	if err == nil || !strings.Contains(err.Error(), "look like euc-jp") {
+		t.Errorf("Unexpected warning: %v", err)
+	}
+	if _, err := c.Decode([]byte(eucjpHello)); err != nil {
+		t.Errorf("Warning should be given once: %v", err)
+	}
+}
+
+func TestCodecAuto(t *testing.T) {
+	for _, tt := range []struct {
+		chunk    string
+		expected string
+	}{
+		{utf8Hello, EncodingUTF8},
+		{sjisHello, EncodingShiftJIS},
+		{eucjpHello, EncodingEUCJP},
+	} {
+		c, _ := NewCodec(EncodingAuto)
+		if text, _ := c.Decode([]byte("PING -1\r\n")); text != "PING -1\r\n" || c.Name() != EncodingUTF8 {
+			t.Errorf("ASCII should not decide: %q %s", text, c.Name())
+		}
+		text, err := c.Decode([]byte(tt.chunk))
+		if err != nil || text != utf8Hello || c.Name() != tt.expected {
+			t.Errorf("Unexpected decode of %s: %q %s %v", tt.expected, text, c.Name(), err)
+		}
+	}
+}
+
+func TestSessionEncoding(t *testing.T) {
+	settings := DefaultSettings()
+	settings.History = false
+	s := NewSession(&settings, ServerSettings{User: "me", Encoding: "euc-jp"})
+	written := &bytes.Buffer{}
+	s.Conn.conn = recordConn{written: written}
+	s.Chats.SetCurrentRoom(3)
+	s.Shout("こんにちは")
+	if expected := "SHOUT 3 \xa4\xb3\xa4\xf3\xa4\xcb\xa4\xc1\xa4\xcf\r\n"; written.String() != expected {
+		t.Errorf("Unexpected bytes: %q", written.String())
+	}
+}
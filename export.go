@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats.
+const (
+	ExportJSON = "json"
+	ExportHTML = "html"
+	ExportText = "text"
+)
+
+// exportTimeFormat is a timestamp layout of exported text and HTML.
+const exportTimeFormat = "2006-01-02 15:04:05"
+
+// ExportOptions select what to export.
+type ExportOptions struct {
+	Room   int
+	Format string
+	// After and Before limit the time range. Zero means no limit.
+	After  time.Time
+	Before time.Time
+}
+
+// validate check the format and the time range.
+func (o ExportOptions) validate() error {
+	switch o.Format {
+	case ExportJSON, ExportHTML, ExportText:
+	default:
+		return fmt.Errorf("unknown export format %q", o.Format)
+	}
+	if !o.After.IsZero() && !o.Before.IsZero() && !o.After.Before(o.Before) {
+		return errors.New("after must be earlier than before")
+	}
+	return nil
+}
+
+// inRange return entries between After and Before without day separators.
+func (o ExportOptions) inRange(entries []ChatEntry) []ChatEntry {
+	var selected []ChatEntry
+	for _, e := range entries {
+		if e.Kind == KindDayChange {
+			continue
+		}
+		if !o.After.IsZero() && e.Time.Before(o.After) {
+			continue
+		}
+		if !o.Before.IsZero() && !e.Time.Before(o.Before) {
+			continue
+		}
+		selected = append(selected, e)
+	}
+	return selected
+}
+
+// Export write entries of the room in the format.
+func Export(w io.Writer, o ExportOptions, entries []ChatEntry) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	entries = o.inRange(entries)
+	switch o.Format {
+	case ExportJSON:
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ExportText:
+		for _, e := range entries {
+			if _, err := fmt.Fprintf(w, "%s %s\n", e.Time.Format(exportTimeFormat), e.Text()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ExportHTML:
+		return exportHTML.Execute(w, struct {
+			Room    int
+			Entries []ChatEntry
+		}{o.Room, entries})
+	}
+	return nil
+}
+
+// nickColor return a CSS color of the nick. It is stable like Theme.NickStyle.
+func nickColor(nick string) string {
+	r, g, b := hueToRGB(float64(nickHash(nick) % 360))
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
+
+var exportHTML = template.Must(template.New("export").Funcs(template.FuncMap{
+	"nickColor": func(nick string) template.CSS { return template.CSS(nickColor(nick)) },
+	"timestamp": func(t time.Time) string { return t.Format(exportTimeFormat) },
+	"isMessage": func(e ChatEntry) bool { return e.Kind == KindMessage },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Room {{.Room}}</title>
+<style>
+body { background: #1d1f21; color: #c5c8c6; font-family: monospace; }
+.time { color: #5f8787; }
+.nick { font-weight: bold; }
+.event { color: #81a2be; }
+</style>
+</head>
+<body>
+<h1>Room {{.Room}}</h1>
+{{range .Entries}}<div><span class="time">{{timestamp .Time}}</span> {{if isMessage .}}<span class="nick" style="color: {{nickColor .Sender}}">{{.Sender}}</span> {{.Body}}{{else}}<span class="event">{{.Text}}</span>{{end}}</div>
+{{end}}</body>
+</html>
+`))
+
+// parseExportArgs parse "<room> <format> <file> [after:<date>] [before:<date>] [source:live|history]".
+func parseExportArgs(args []string) (ExportOptions, string, string, error) {
+	var o ExportOptions
+	if len(args) < 3 {
+		return o, "", "", errors.New("too few arguments")
+	}
+	room, err := strconv.Atoi(args[0])
+	if err != nil {
+		return o, "", "", fmt.Errorf("invalid room %q", args[0])
+	}
+	o.Room, o.Format = room, args[1]
+	source := ""
+	for _, arg := range args[3:] {
+		i := strings.Index(arg, ":")
+		if i < 0 {
+			return o, "", "", fmt.Errorf("invalid option %q", arg)
+		}
+		key, value := arg[:i], arg[i+1:]
+		switch key {
+		case "after":
+			o.After, err = parseSearchDate(value)
+		case "before":
+			o.Before, err = parseSearchDate(value)
+		case "source":
+			if value != "live" && value != "history" {
+				err = errors.New("source must be live or history")
+			}
+			source = value
+		default:
+			err = errors.New("unknown option")
+		}
+		if err != nil {
+			return o, "", "", fmt.Errorf("invalid option %q: %s", arg, err)
+		}
+	}
+	if err := o.validate(); err != nil {
+		return o, "", "", err
+	}
+	return o, args[2], source, nil
+}
+
+// exportFile export entries to the file. The file is readable by others like
+// files made by shells with the usual umask.
+// It write a temporary file in the same directory and rename it,
+// so an existing file is kept when the options are invalid or writing fails.
+func exportFile(path string, o ExportOptions, entries []ChatEntry) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	err = Export(f, o, entries)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// TempFile creates the file with 0600.
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// RegisterExportCommands add /export.
+// Live ChatBox buffers are used when history is nil or "source:live" is given.
+func RegisterExportCommands(cs CommandSet, cb *ChatBox, h *History) {
+	usage := "/export <room> <json|html|text> <file> [after:<date>] [before:<date>] [source:live|history]"
+	cs.Register("export", usage, func(args []string) ([]string, error) {
+		o, path, source, err := parseExportArgs(args)
+		if err != nil {
+			return nil, fmt.Errorf("%s. %s", err, errUsage(usage))
+		}
+		var entries []ChatEntry
+		switch {
+		case source == "live" || source == "" && h == nil:
+			entries = cb.RoomEntries(o.Room)
+		default:
+			if h == nil {
+				return nil, errors.New("history is disabled")
+			}
+			if entries, err = h.Entries(o.Room); err != nil {
+				return nil, err
+			}
+		}
+		if err := exportFile(path, o, entries); err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("Exported room %d to %s", o.Room, path)}, nil
+	})
+}
+
+// runExport is "export" subcommand which export on-disk history without connecting.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	room := fs.Int("room", 0, "room ID to export")
+	format := fs.String("format", ExportText, "json, html or text")
+	after := fs.String("after", "", "export entries after the date (2006-01-02 or 2006-01-02T15:04)")
+	before := fs.String("before", "", "export entries before the date")
+	out := fs.String("o", "", "output file. Standard output by default")
+	dir := fs.String("history", "", "history directory")
+	server := fs.String("server", "", "name of the server whose history is exported. Default is the unnamed server")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	o := ExportOptions{Room: *room, Format: *format}
+	var err error
+	if *after != "" {
+		if o.After, err = parseSearchDate(*after); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -after: %s\n", *after)
+			return 2
+		}
+	}
+	if *before != "" {
+		if o.Before, err = parseSearchDate(*before); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -before: %s\n", *before)
+			return 2
+		}
+	}
+	if err := o.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 2
+	}
+	if *dir == "" {
+		settings, _ := LoadSettings(settingsPath())
+		*dir = settings.historyDir()
+	}
+	if *server == "." || *server == ".." || strings.ContainsAny(*server, `/\`) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -server: %s\n", *server)
+		return 2
+	}
+
+	// OpenHistory would create a directory for a mistyped name.
+	historyDir := ServerSettings{Name: *server}.historyDir(*dir)
+	if info, err := os.Stat(historyDir); err != nil || !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "Error: no history for server %q in %s\n", *server, historyDir)
+		return 1
+	}
+	h, err := OpenHistory(historyDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	defer h.Close()
+	entries, err := h.Entries(o.Room)
+	if err == nil {
+		if *out == "" {
+			err = Export(os.Stdout, o, entries)
+		} else {
+			err = exportFile(*out, o, entries)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
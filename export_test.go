@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exportTestEntries() []ChatEntry {
+	base := time.Date(2015, 9, 13, 10, 0, 0, 0, time.Local)
+	return []ChatEntry{
+		{Time: base, RoomID: 3, Sender: "alice", Body: "<b>hello</b>", Kind: KindMessage},
+		{Time: base.Add(time.Minute), RoomID: 3, Kind: KindDayChange},
+		{Time: base.Add(time.Hour), RoomID: 3, Sender: "bob", Kind: KindEnter},
+		{Time: base.Add(48 * time.Hour), RoomID: 3, Sender: "bob", Body: "late", Kind: KindMessage},
+	}
+}
+
+func TestExportText(t *testing.T) {
+	var buf bytes.Buffer
+	o := ExportOptions{Room: 3, Format: ExportText, Before: time.Date(2015, 9, 14, 0, 0, 0, 0, time.Local)}
+	if err := Export(&buf, o, exportTestEntries()); err != nil {
+		t.Fatal(err)
+	}
+	expected := "2015-09-13 10:00:00 alice <b>hello</b>\n2015-09-13 11:00:00 -> bob entered\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected text.\nexpected: %q\nresult: %q", expected, buf.String())
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	var buf bytes.Buffer
+	o := ExportOptions{Room: 3, Format: ExportJSON, After: time.Date(2015, 9, 14, 0, 0, 0, 0, time.Local)}
+	if err := Export(&buf, o, exportTestEntries()); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Unexpected lines: %v", lines)
+	}
+	var e ChatEntry
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Sender != "bob" || e.Body != "late" || e.Kind != KindMessage || e.RoomID != 3 {
+		t.Errorf("Unexpected entry: %+v", e)
+	}
+}
+
+func TestExportHTML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Export(&buf, ExportOptions{Room: 3, Format: ExportHTML}, exportTestEntries()); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+	if strings.Contains(html, "<b>hello</b>") || !strings.Contains(html, "&lt;b&gt;hello&lt;/b&gt;") {
+		t.Errorf("Message should be escaped: %s", html)
+	}
+	if !strings.Contains(html, "color: "+nickColor("alice")) {
+		t.Errorf("Nick should be colored: %s", html)
+	}
+	if err := Export(&buf, ExportOptions{Format: "pdf"}, nil); err == nil {
+		t.Errorf("Unknown format should be an error")
+	}
+}
+
+func TestParseExportArgs(t *testing.T) {
+	o, path, source, err := parseExportArgs([]string{"3", "html", "out.html", "after:2015-09-01", "source:live"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Room != 3 || o.Format != ExportHTML || path != "out.html" || source != "live" || o.After.IsZero() {
+		t.Errorf("Unexpected options: %+v %s %s", o, path, source)
+	}
+	if _, _, _, err := parseExportArgs([]string{"3", "html", "out.html", "from:x"}); err == nil {
+		t.Errorf("Unknown option should be an error")
+	}
+	if _, _, _, err := parseExportArgs([]string{"3", "pdf", "out.pdf"}); err == nil {
+		t.Errorf("Unknown format should be an error")
+	}
+	if _, _, _, err := parseExportArgs([]string{"3", "html", "out.html", "source:disk"}); err == nil {
+		t.Errorf("Unknown source should be an error")
+	}
+}
+
+func TestExportFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igoexport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.txt")
+	if err := ioutil.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := exportFile(path, ExportOptions{Format: "pdf"}, exportTestEntries()); err == nil {
+		t.Errorf("Unknown format should be an error")
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "old" {
+		t.Errorf("Existing file should be kept: %q", b)
+	}
+	if err := exportFile(path, ExportOptions{Format: ExportText}, exportTestEntries()[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "2015-09-13 10:00:00 alice <b>hello</b>\n" {
+		t.Errorf("Unexpected file: %q", b)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm() != 0644 {
+		t.Errorf("Unexpected mode: %v", info.Mode())
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Temporary file should not be left: %d files", len(files))
+	}
+}
+
+func TestExportCommandError(t *testing.T) {
+	cs := NewCommandSet()
+	rooms := []RoomInfo{}
+	RegisterExportCommands(cs, NewChatBox(20, &rooms), nil)
+	_, err := cs.Execute("/export 3 html out.html after:yesterday")
+	if err == nil || !strings.Contains(err.Error(), `invalid option "after:yesterday"`) || !strings.Contains(err.Error(), "usage: /export") {
+		t.Errorf("Parse error should be kept with the usage: %v", err)
+	}
+}
+
+func TestRunExportServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igoexport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	h, err := OpenHistory(filepath.Join(dir, "work"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Append(exportTestEntries()[0])
+	h.Close()
+
+	out := filepath.Join(dir, "out.txt")
+	if code := runExport([]string{"-history", dir, "-server", "work", "-room", "3", "-o", out}); code != 0 {
+		t.Fatalf("Unexpected exit code %d", code)
+	}
+	if b, _ := ioutil.ReadFile(out); string(b) != "2015-09-13 10:00:00 alice <b>hello</b>\n" {
+		t.Errorf("History of the server should be exported: %q", b)
+	}
+	if code := runExport([]string{"-history", dir, "-server", "../x", "-room", "3"}); code != 2 {
+		t.Errorf("Invalid server should fail: %d", code)
+	}
+	if code := runExport([]string{"-history", dir, "-server", "wrok", "-room", "3"}); code != 1 {
+		t.Errorf("Unknown server should fail: %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wrok")); !os.IsNotExist(err) {
+		t.Errorf("History of an unknown server should not be created: %v", err)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FilterResult tell how a filtered event should be shown.
+type FilterResult int
+
+const (
+	// FilterPass is used for events which are shown as usual.
+	FilterPass FilterResult = iota
+	// FilterDim is used for events which are shown dimmed.
+	FilterDim
+	// FilterHide is used for events which are not shown.
+	FilterHide
+)
+
+// FilterSettings is an ignore list and filter rules in the settings file.
+type FilterSettings struct {
+	// Ignore is a list of member names. "*" and "?" are wildcards.
+	Ignore []string `json:"ignore"`
+	// Rules hide messages whose text matches.
+	Rules []FilterRule `json:"rules"`
+	// Dim show filtered events dimmed instead of hiding them.
+	Dim bool `json:"dim"`
+}
+
+// FilterRule is a regular expression applied to messages of a room.
+type FilterRule struct {
+	// Room is a room ID. 0 means every room.
+	Room    int    `json:"room"`
+	Pattern string `json:"pattern"`
+}
+
+// Filter decide which events from the server are hidden or dimmed.
+type Filter struct {
+	settings FilterSettings
+	ignore   []*regexp.Regexp
+	// rules are compiled settings.Rules. A rule which doesn't compile is nil
+	// and matches nothing, but it stays in settings so that saving keeps it.
+	rules []*regexp.Regexp
+}
+
+// NewFilter create Filter from settings.
+// A rule which doesn't compile is kept and reported by the error. See Err.
+func NewFilter(fs FilterSettings) (*Filter, error) {
+	f := &Filter{}
+	for _, name := range fs.Ignore {
+		f.addIgnore(name)
+	}
+	for _, rule := range fs.Rules {
+		re, _ := regexp.Compile(rule.Pattern)
+		f.rules = append(f.rules, re)
+		f.settings.Rules = append(f.settings.Rules, rule)
+	}
+	f.settings.Dim = fs.Dim
+	return f, f.Err()
+}
+
+// Err return an error about the first rule which doesn't compile.
+func (f *Filter) Err() error {
+	for i, re := range f.rules {
+		if re == nil {
+			_, err := regexp.Compile(f.settings.Rules[i].Pattern)
+			return fmt.Errorf("filter #%d is invalid and ignored: %s. Fix it in the settings file or /filters del %d", i+1, err.Error(), i+1)
+		}
+	}
+	return nil
+}
+
+// Settings return current filter settings to save.
+func (f *Filter) Settings() FilterSettings {
+	return f.settings
+}
+
+// Check return how the event from sender in the room should be shown.
+// text is empty for ENTER and LEAVE.
+func (f *Filter) Check(room int, sender, text string) FilterResult {
+	matched := f.Ignored(sender)
+	for i, re := range f.rules {
+		if matched {
+			break
+		}
+		r := f.settings.Rules[i].Room
+		matched = re != nil && text != "" && (r == 0 || r == room) && re.MatchString(text)
+	}
+	if !matched {
+		return FilterPass
+	}
+	if f.settings.Dim {
+		return FilterDim
+	}
+	return FilterHide
+}
+
+// Ignored return true when the name is in the ignore list.
+func (f *Filter) Ignored(name string) bool {
+	for _, re := range f.ignore {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) addIgnore(name string) error {
+	for _, n := range f.settings.Ignore {
+		if n == name {
+			return fmt.Errorf("%s is already ignored", name)
+		}
+	}
+	f.ignore = append(f.ignore, wildcardRegexp(name))
+	f.settings.Ignore = append(f.settings.Ignore, name)
+	return nil
+}
+
+func (f *Filter) removeIgnore(name string) error {
+	for i, n := range f.settings.Ignore {
+		if n == name {
+			f.ignore = append(f.ignore[:i], f.ignore[i+1:]...)
+			f.settings.Ignore = append(f.settings.Ignore[:i], f.settings.Ignore[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not ignored", name)
+}
+
+func (f *Filter) addRule(rule FilterRule) error {
+	re, err := regexp.Compile(rule.Pattern)
+	if err != nil {
+		return err
+	}
+	f.rules = append(f.rules, re)
+	f.settings.Rules = append(f.settings.Rules, rule)
+	return nil
+}
+
+func (f *Filter) removeRule(i int) error {
+	if i < 0 || i >= len(f.rules) {
+		return fmt.Errorf("no filter #%d", i+1)
+	}
+	f.rules = append(f.rules[:i], f.rules[i+1:]...)
+	f.settings.Rules = append(f.settings.Rules[:i], f.settings.Rules[i+1:]...)
+	return nil
+}
+
+// wildcardRegexp convert a name with "*" and "?" to a regular expression.
+func wildcardRegexp(pattern string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.Replace(quoted, `\*`, `.*`, -1)
+	quoted = strings.Replace(quoted, `\?`, `.`, -1)
+	return regexp.MustCompile("^" + quoted + "$")
+}
+
+// RegisterFilterCommands add /ignore, /unignore and /filters.
+// save is called after the filter is changed.
+func RegisterFilterCommands(cs CommandSet, f *Filter, save func(FilterSettings) error) {
+	cs.Register("ignore", "/ignore <name>", func(args []string) ([]string, error) {
+		if len(args) != 1 {
+			return nil, errUsage("/ignore <name>")
+		}
+		if err := f.addIgnore(args[0]); err != nil {
+			return nil, err
+		}
+		return []string{"Ignoring " + args[0]}, save(f.Settings())
+	})
+	cs.Register("unignore", "/unignore <name>", func(args []string) ([]string, error) {
+		if len(args) != 1 {
+			return nil, errUsage("/unignore <name>")
+		}
+		if err := f.removeIgnore(args[0]); err != nil {
+			return nil, err
+		}
+		return []string{"Stop ignoring " + args[0]}, save(f.Settings())
+	})
+
+	usage := "/filters [add <room|*> <regexp> | del <#> | dim | hide]"
+	// The regexp is taken as typed so that its spaces are kept.
+	cs.RegisterText("filters", usage, func(text string) ([]string, error) {
+		args := strings.Fields(text)
+		if len(args) == 0 {
+			return f.describe(), nil
+		}
+		switch {
+		case args[0] == "add" && len(args) >= 3:
+			room := 0
+			if args[1] != "*" {
+				id, err := strconv.Atoi(args[1])
+				if err != nil {
+					return nil, errUsage(usage)
+				}
+				room = id
+			}
+			rule := FilterRule{room, textAfter(text, 2)}
+			if err := f.addRule(rule); err != nil {
+				return nil, err
+			}
+		case args[0] == "del" && len(args) == 2:
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return nil, errUsage(usage)
+			}
+			if err := f.removeRule(n - 1); err != nil {
+				return nil, err
+			}
+		case args[0] == "dim" && len(args) == 1:
+			f.settings.Dim = true
+		case args[0] == "hide" && len(args) == 1:
+			f.settings.Dim = false
+		default:
+			return nil, errUsage(usage)
+		}
+		return f.describe(), save(f.Settings())
+	})
+}
+
+// describe return lines which show the ignore list and rules.
+func (f *Filter) describe() []string {
+	mode := "hide"
+	if f.settings.Dim {
+		mode = "dim"
+	}
+	lines := []string{"Filtered events: " + mode,
+		"Ignore: " + strings.Join(f.settings.Ignore, ", ")}
+	for i, rule := range f.settings.Rules {
+		room := "*"
+		if rule.Room != 0 {
+			room = strconv.Itoa(rule.Room)
+		}
+		line := fmt.Sprintf("#%d room %s %s", i+1, room, rule.Pattern)
+		if f.rules[i] == nil {
+			line += " (invalid, ignored)"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
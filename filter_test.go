@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFilterCheck(t *testing.T) {
+	f, err := NewFilter(FilterSettings{
+		Ignore: []string{"bot*", "spa?"},
+		Rules:  []FilterRule{{0, `^!`}, {2, "(?i)lunch"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		room     int
+		sender   string
+		text     string
+		expected FilterResult
+	}{
+		{1, "botman", "", FilterHide},
+		{1, "bot", "hello", FilterHide},
+		{1, "robot", "hello", FilterPass},
+		{1, "spam", "hello", FilterHide},
+		{1, "spammer", "hello", FilterPass},
+		{1, "alice", "!roll", FilterHide},
+		{1, "alice", "Lunch?", FilterPass},
+		{2, "alice", "Lunch?", FilterHide},
+		{2, "alice", "", FilterPass},
+	}
+	for _, c := range cases {
+		if result := f.Check(c.room, c.sender, c.text); result != c.expected {
+			t.Errorf("Unexpected result for %v.\nexpected: %v\nresult: %v", c, c.expected, result)
+		}
+	}
+
+	f.settings.Dim = true
+	if f.Check(1, "botman", "") != FilterDim {
+		t.Errorf("Dim filter should dim ignored member")
+	}
+}
+
+func TestFilterCommands(t *testing.T) {
+	var saved FilterSettings
+	f, _ := NewFilter(FilterSettings{})
+	cs := NewCommandSet()
+	RegisterFilterCommands(cs, f, func(fs FilterSettings) error {
+		saved = fs
+		return nil
+	})
+
+	for _, line := range []string{"/ignore bob", "/filters add 3 foo  bar", "/filters add * ^x", "/filters dim"} {
+		if _, err := cs.Execute(line); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+	}
+	if len(saved.Ignore) != 1 || len(saved.Rules) != 2 || !saved.Dim ||
+		saved.Rules[0] != (FilterRule{3, "foo  bar"}) {
+		t.Errorf("Unexpected saved settings: %+v", saved)
+	}
+
+	if _, err := cs.Execute("/ignore bob"); err == nil {
+		t.Errorf("Ignoring twice should be an error")
+	}
+	cs.Execute("/filters del 1")
+	cs.Execute("/unignore bob")
+	if len(saved.Ignore) != 0 || len(saved.Rules) != 1 || saved.Rules[0].Pattern != "^x" {
+		t.Errorf("Unexpected saved settings: %+v", saved)
+	}
+	if _, err := cs.Execute("/filters add 1 ("); err == nil {
+		t.Errorf("Invalid regexp should be an error")
+	}
+
+	RegisterFilterCommands(cs, f, func(fs FilterSettings) error {
+		return errors.New("disk full")
+	})
+	if _, err := cs.Execute("/ignore carol"); err == nil {
+		t.Errorf("Save error should be returned")
+	}
+}
+
+func TestFilterInvalidRule(t *testing.T) {
+	f, err := NewFilter(FilterSettings{Rules: []FilterRule{{0, "("}, {0, "^x"}}})
+	if err == nil || !strings.Contains(err.Error(), "filter #1") {
+		t.Errorf("Invalid rule should be reported: %v", err)
+	}
+	if f.Check(1, "alice", "(") != FilterPass || f.Check(1, "alice", "xyz") != FilterHide {
+		t.Errorf("Invalid rule should match nothing and others should work")
+	}
+	if lines := f.describe(); lines[2] != "#1 room * ( (invalid, ignored)" {
+		t.Errorf("Invalid rule should be marked: %q", lines)
+	}
+
+	var saved FilterSettings
+	cs := NewCommandSet()
+	RegisterFilterCommands(cs, f, func(fs FilterSettings) error {
+		saved = fs
+		return nil
+	})
+	cs.Execute("/ignore bob")
+	if len(saved.Rules) != 2 || saved.Rules[0].Pattern != "(" {
+		t.Errorf("Invalid rule should be kept when saved: %+v", saved)
+	}
+	cs.Execute("/filters del 1")
+	if f.Err() != nil || len(saved.Rules) != 1 {
+		t.Errorf("Deleted invalid rule should be gone: %v %+v", f.Err(), saved)
+	}
+}
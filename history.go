@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// historyIndexFile is a name of the inverted index file in the history directory.
+// Each line is "<room> <line> <term> <term> ..." for a line of a room file.
+const historyIndexFile = "index.log"
+
+// historyPath return the default history directory.
+// It follows XDG base directory and falls back to ~/.local/share.
+func historyPath() string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".local", "share")
+	}
+	return filepath.Join(dir, "igoclient", "history")
+}
+
+// posting is a place of a term in the history.
+type posting struct {
+	Room int
+	Line int
+}
+
+// History keep chat entries on disk, one JSON lines file per room,
+// with an inverted index used by Search.
+type History struct {
+	dir string
+
+	mu      sync.Mutex
+	lines   map[int]int
+	index   map[string][]posting
+	indexed map[int]int
+	cache   map[int][]ChatEntry
+	idx     *os.File
+}
+
+// OpenHistory open history directory and bring its index up to date.
+func OpenHistory(dir string) (*History, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	h := &History{
+		dir:     dir,
+		lines:   make(map[int]int),
+		index:   make(map[string][]posting),
+		indexed: make(map[int]int),
+		cache:   make(map[int][]ChatEntry),
+	}
+	if err := h.readIndex(); err != nil {
+		return nil, err
+	}
+	idx, err := os.OpenFile(filepath.Join(dir, historyIndexFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	h.idx = idx
+
+	// Index lines written while the index was not updated.
+	for _, room := range h.Rooms() {
+		entries, err := h.entries(room)
+		if err != nil {
+			return nil, err
+		}
+		h.lines[room] = len(entries)
+		for line := h.indexed[room]; line < len(entries); line++ {
+			if err := h.addIndex(room, line, entries[line]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return h, nil
+}
+
+// Close close the index file.
+func (h *History) Close() error {
+	return h.idx.Close()
+}
+
+func (h *History) roomFile(room int) string {
+	return filepath.Join(h.dir, fmt.Sprintf("room-%d.jsonl", room))
+}
+
+// directFile return the file of the direct conversation. The peer is escaped to stay in dir.
+func (h *History) directFile(peer string) string {
+	return filepath.Join(h.dir, "direct-"+url.PathEscape(peer)+".jsonl")
+}
+
+func (h *History) readIndex() error {
+	f, err := os.Open(filepath.Join(h.dir, historyIndexFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		room, err1 := strconv.Atoi(fields[0])
+		line, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		for _, term := range fields[2:] {
+			h.index[term] = append(h.index[term], posting{room, line})
+		}
+		if line >= h.indexed[room] {
+			h.indexed[room] = line + 1
+		}
+	}
+	return scanner.Err()
+}
+
+func (h *History) addIndex(room, line int, e ChatEntry) error {
+	terms := uniqueTerms(e.Body)
+	for _, term := range terms {
+		h.index[term] = append(h.index[term], posting{room, line})
+	}
+	h.indexed[room] = line + 1
+	_, err := fmt.Fprintf(h.idx, "%d %d %s\n", room, line, strings.Join(terms, " "))
+	return err
+}
+
+// Append write the entry to its room file and index it.
+// Direct conversations are written to their own files and not indexed.
+// Day change separators are not stored.
+func (h *History) Append(e ChatEntry) error {
+	if e.Kind == KindDayChange {
+		return nil
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	path := h.roomFile(e.RoomID)
+	if e.Peer != "" {
+		path = h.directFile(e.Peer)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if e.Peer != "" {
+		return nil
+	}
+	line := h.lines[e.RoomID]
+	h.lines[e.RoomID] = line + 1
+	if cached, ok := h.cache[e.RoomID]; ok {
+		h.cache[e.RoomID] = append(cached, e)
+	}
+	return h.addIndex(e.RoomID, line, e)
+}
+
+// Rooms return IDs of rooms which have history.
+func (h *History) Rooms() []int {
+	files, _ := filepath.Glob(filepath.Join(h.dir, "room-*.jsonl"))
+	var rooms []int
+	for _, file := range files {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "room-"), ".jsonl")
+		if id, err := strconv.Atoi(name); err == nil {
+			rooms = append(rooms, id)
+		}
+	}
+	sort.Ints(rooms)
+	return rooms
+}
+
+// Entries return every stored entry of the room from the oldest.
+func (h *History) Entries(room int) ([]ChatEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.entries(room)
+}
+
+func (h *History) entries(room int) ([]ChatEntry, error) {
+	if cached, ok := h.cache[room]; ok {
+		return cached, nil
+	}
+	entries, err := readEntries(h.roomFile(room))
+	if err != nil {
+		return nil, err
+	}
+	h.cache[room] = entries
+	return entries, nil
+}
+
+// DirectEntries return every stored entry of the direct conversation from the oldest.
+func (h *History) DirectEntries(peer string) ([]ChatEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return readEntries(h.directFile(peer))
+}
+
+// readEntries read a JSON lines file. A missing file has no entry.
+func readEntries(path string) ([]ChatEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []ChatEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e ChatEntry
+		// Keep line numbers even if a line is broken.
+		json.Unmarshal(scanner.Bytes(), &e)
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// uniqueTerms return lower case words in the text without duplication.
+func uniqueTerms(text string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, term := range splitTerms(text) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// splitTerms split text into lower case words of letters and digits.
+func splitTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,339 @@
+package igo
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Default keepalive settings. The server drops a connection silent for a while.
+const (
+	DefaultPingInterval = 360 * time.Second
+	DefaultTimeout      = 400 * time.Second
+)
+
+// ErrNotConnected is returned when a command is sent before Connect.
+var ErrNotConnected = errors.New("not connected")
+
+// Room is a room known by Client.
+type Room struct {
+	ID    int
+	Name  string
+	Owner string
+	// Capacity is the max number of members. 0 means unknown.
+	Capacity int
+	Topic    string
+	// Members are names of members in the room sorted by name.
+	Members []string
+	// Entered is true while we are in the room.
+	Entered bool
+}
+
+// Codec convert text between UTF-8 and the wire encoding of a server.
+// Decode keeps bytes of a character split at the end of a chunk for the next one.
+// A Decode error is a warning about invalid bytes and the returned text is still used.
+type Codec interface {
+	Encode(s string) ([]byte, error)
+	Decode(chunk []byte) (string, error)
+}
+
+// Client is a connection to an iGo server.
+// Server events are delivered to Events after the room state is updated.
+type Client struct {
+	// PingInterval and Timeout are used from Connect.
+	PingInterval time.Duration
+	Timeout      time.Duration
+	// Codec is used for every line after Attach. Nil means UTF-8.
+	Codec Codec
+	// Sanitize is applied to every event before the room state is updated.
+	// Nil means events keep text from the server as it is.
+	Sanitize func(Event) Event
+
+	conn     net.Conn
+	events   chan Event
+	done     chan struct{}
+	writeMu  sync.Mutex
+	mu       sync.Mutex
+	rooms    map[int]*Room
+	members  map[int]map[string]bool
+	profiles map[string]Profile
+}
+
+// NewClient create Client with default keepalive settings.
+func NewClient() *Client {
+	return &Client{
+		PingInterval: DefaultPingInterval,
+		Timeout:      DefaultTimeout,
+		events:       make(chan Event, 64),
+		done:         make(chan struct{}),
+		rooms:        make(map[int]*Room),
+		members:      make(map[int]map[string]bool),
+		profiles:     make(map[string]Profile),
+	}
+}
+
+// Connect dial addr like "localhost:10000" and start receiving events.
+func (c *Client) Connect(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, c.Timeout)
+	if err != nil {
+		return err
+	}
+	c.Attach(conn)
+	return nil
+}
+
+// Attach start using an established connection. It is useful for tests and proxies.
+func (c *Client) Attach(conn net.Conn) {
+	c.conn = conn
+	c.extendDeadline()
+	go c.receive()
+	go c.ping()
+}
+
+// Events return the channel of server events. It must be drained by the caller.
+// The last event is EventQuit and the channel is closed after it.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Send send a raw protocol line. A line with CR or LF is rejected by ErrMultiLine.
+func (c *Client) Send(line string) error {
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	if err := ValidText(line); err != nil {
+		return err
+	}
+	b := []byte(line + "\r\n")
+	if c.Codec != nil {
+		var err error
+		if b, err = c.Codec.Encode(line + "\r\n"); err != nil {
+			return err
+		}
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// Login send the profile. It should be called just after Connect.
+func (c *Client) Login(p Profile) error {
+	for _, cmd := range LoginCommands(p) {
+		if err := c.Send(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenRoom enter the room.
+func (c *Client) OpenRoom(room int) error {
+	return c.Send(OpenRoomCommand(room))
+}
+
+// CloseRoom quit the room.
+func (c *Client) CloseRoom(room int) error {
+	return c.Send(CloseRoomCommand(room))
+}
+
+// AddRoom create a room. The new room comes as EventRoomAdded.
+func (c *Client) AddRoom(name string, capacity int, topic string) error {
+	line, err := AddRoomCommand(name, capacity, topic)
+	if err != nil {
+		return err
+	}
+	return c.Send(line)
+}
+
+// RemoveRoom remove a room we own.
+func (c *Client) RemoveRoom(room int) error {
+	return c.Send(RemoveRoomCommand(room))
+}
+
+// Shout send text to the room.
+func (c *Client) Shout(room int, text string) error {
+	line, err := ShoutCommand(room, text)
+	if err != nil {
+		return err
+	}
+	return c.Send(line)
+}
+
+// Direct send text only to the user.
+func (c *Client) Direct(user, text string) error {
+	line, err := DirectCommand(user, text)
+	if err != nil {
+		return err
+	}
+	return c.Send(line)
+}
+
+// SetIntroduction change our introduction.
+func (c *Client) SetIntroduction(text string) error {
+	return c.Send(IntroductionCommand(text))
+}
+
+// SetLevel change our level.
+func (c *Client) SetLevel(level string) error {
+	return c.Send(LevelCommand(level))
+}
+
+// Whois ask the profile of the user. It comes as EventProfile and then Profile returns it.
+func (c *Client) Whois(user string) error {
+	return c.Send(ProfileCommand(user))
+}
+
+// Logout tell the server we are leaving. The server closes the connection.
+func (c *Client) Logout() error {
+	return c.Send("LOGOUT")
+}
+
+// Close close the connection. Events get EventQuit.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	return c.conn.Close()
+}
+
+// Rooms return known rooms sorted by ID.
+func (c *Client) Rooms() []Room {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var rooms []Room
+	for id := range c.rooms {
+		rooms = append(rooms, c.room(id))
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+	return rooms
+}
+
+// Room return the room of the ID.
+func (c *Client) Room(id int) (Room, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.rooms[id]; !ok {
+		return Room{}, false
+	}
+	return c.room(id), true
+}
+
+// Profile return the profile of the user received by Whois.
+func (c *Client) Profile(user string) (Profile, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.profiles[user]
+	return p, ok
+}
+
+// room copy the room with its members. c.mu must be held.
+func (c *Client) room(id int) Room {
+	r := *c.rooms[id]
+	r.Members = nil
+	for name := range c.members[id] {
+		r.Members = append(r.Members, name)
+	}
+	sort.Strings(r.Members)
+	return r
+}
+
+// apply update the room state by the event and answer the server if needed.
+func (c *Client) apply(e Event) {
+	if e.Type == EventServerPing {
+		c.Send("OK SVR_PING")
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch e.Type {
+	case EventRoomAdded:
+		c.rooms[e.Room] = &Room{ID: e.Room, Name: e.Name, Owner: e.Owner, Capacity: e.Capacity, Topic: e.Text}
+	case EventProfile:
+		c.profiles[e.Name] = *e.Profile
+	case EventRoomRemoved:
+		delete(c.rooms, e.Room)
+		delete(c.members, e.Room)
+	case EventEnter:
+		c.addMembers(e.Room, e.Name)
+	case EventUsers:
+		c.addMembers(e.Room, e.Users...)
+	case EventLeave:
+		delete(c.members[e.Room], e.Name)
+	case EventOK:
+		if r, ok := c.rooms[e.Room]; ok {
+			switch e.Command {
+			case "OPEN_ROOM":
+				r.Entered = true
+			case "CLOSE_ROOM":
+				r.Entered = false
+			}
+		}
+	}
+}
+
+// addMembers add names to the room. c.mu must be held.
+func (c *Client) addMembers(room int, names ...string) {
+	if c.members[room] == nil {
+		c.members[room] = make(map[string]bool)
+	}
+	for _, name := range names {
+		c.members[room][name] = true
+	}
+}
+
+// receive read lines until the connection is closed.
+func (c *Client) receive() {
+	defer close(c.events)
+	defer close(c.done)
+	var splitter LineSplitter
+	buf := make([]byte, 1024)
+	for {
+		n, err := c.conn.Read(buf)
+		if n > 0 {
+			c.extendDeadline()
+		}
+		chunk := string(buf[:n])
+		if c.Codec != nil && n > 0 {
+			chunk, _ = c.Codec.Decode(buf[:n])
+		}
+		for _, line := range splitter.Split(chunk) {
+			e := ParseLine(line)
+			if c.Sanitize != nil {
+				e = c.Sanitize(e)
+			}
+			c.apply(e)
+			c.events <- e
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			c.events <- QuitEvent(err)
+			return
+		}
+	}
+}
+
+// ping send PING periodically to keep the connection.
+func (c *Client) ping() {
+	tick := time.NewTicker(c.PingInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-tick.C:
+			c.Send("PING -1")
+			c.extendDeadline()
+		}
+	}
+}
+
+// extendDeadline extend read and write deadline by Timeout.
+func (c *Client) extendDeadline() {
+	c.conn.SetDeadline(time.Now().Add(c.Timeout))
+}
@@ -0,0 +1,165 @@
+package igo
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer accept a client and record lines it sent.
+type fakeServer struct {
+	ln    net.Listener
+	conn  chan net.Conn
+	lines chan string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{ln, make(chan net.Conn, 1), make(chan string, 100)}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		s.conn <- conn
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			s.lines <- strings.TrimRight(scanner.Text(), "\r")
+		}
+		close(s.lines)
+	}()
+	return s
+}
+
+// expect read n lines sent by the client.
+func (s *fakeServer) expect(t *testing.T, expected ...string) {
+	t.Helper()
+	for _, want := range expected {
+		select {
+		case line := <-s.lines:
+			if line != want {
+				t.Errorf("Unexpected line.\nexpected: %q\nresult: %q", want, line)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting %q", want)
+		}
+	}
+}
+
+// next wait the next event of type.
+func next(t *testing.T, c *Client, typ EventType) Event {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e := <-c.Events():
+			if e.Type == typ {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("Timeout waiting %s", typ)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.ln.Close()
+
+	c := NewClient()
+	if err := c.Shout(1, "hi"); err != ErrNotConnected {
+		t.Errorf("Unexpected error before Connect: %v", err)
+	}
+	if err := c.Connect(s.ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	conn := <-s.conn
+
+	c.Login(Profile{User: "bot", ID: 7, Introduction: "hello", Level: "1", ClientInfo: "igo"})
+	s.expect(t, "LOGIN bot", "SET_INTRO hello", "SET_LEVEL 1", "CLIENT_INFO igo", "SET_ID 7")
+
+	conn.Write([]byte("ROOM_ADDED 3 carol 10 lobby\r\nUSERS 3 alice:bob\r\nSVR_PI"))
+	conn.Write([]byte("NG\r\n"))
+	s.expect(t, "OK SVR_PING")
+
+	c.OpenRoom(3)
+	s.expect(t, "OPEN_ROOM 3")
+	conn.Write([]byte("OK OPEN_ROOM 3\r\nLEAVE 3 bob\r\nMESSAGE 3 alice hi bot\r\n"))
+	if e := next(t, c, EventMessage); e.Room != 3 || e.Name != "alice" || e.Text != "hi bot" {
+		t.Errorf("Unexpected message: %+v", e)
+	}
+	expected := []Room{{ID: 3, Name: "lobby", Owner: "carol", Capacity: 10, Members: []string{"alice"}, Entered: true}}
+	if rooms := c.Rooms(); !reflect.DeepEqual(rooms, expected) {
+		t.Errorf("Unexpected rooms.\nexpected: %+v\nresult: %+v", expected, rooms)
+	}
+
+	if err := c.Shout(3, "x\r\nREMOVE_ROOM 3"); err != ErrMultiLine {
+		t.Errorf("Multi-line shout should be rejected: %v", err)
+	}
+	if err := c.SetIntroduction("x\nLOGOUT"); err != ErrMultiLine {
+		t.Errorf("Multi-line line should be rejected: %v", err)
+	}
+	c.Shout(3, "hello alice")
+	c.CloseRoom(3)
+	c.Logout()
+	s.expect(t, "SHOUT 3 hello alice", "CLOSE_ROOM 3", "LOGOUT")
+
+	conn.Close()
+	next(t, c, EventQuit)
+	if _, ok := <-c.Events(); ok {
+		t.Errorf("Events should be closed after EventQuit")
+	}
+}
+
+// upperCodec send upper case and receive lower case to test Codec.
+type upperCodec struct{}
+
+func (upperCodec) Encode(s string) ([]byte, error) { return []byte(strings.ToUpper(s)), nil }
+func (upperCodec) Decode(b []byte) (string, error) { return strings.ToLower(string(b)), nil }
+
+func TestClientCodec(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.ln.Close()
+	c := NewClient()
+	c.Codec = upperCodec{}
+	if err := c.Connect(s.ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn := <-s.conn
+	c.Shout(3, "hello")
+	s.expect(t, "SHOUT 3 HELLO")
+	conn.Write([]byte("MESSAGE 3 ALICE HI\r\n"))
+	if e := next(t, c, EventUnknown); e.Raw != "message 3 alice hi" {
+		t.Errorf("Received chunk should be decoded: %+v", e)
+	}
+}
+
+func TestClientSanitize(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.ln.Close()
+	c := NewClient()
+	c.Sanitize = func(e Event) Event {
+		e.Name = strings.Replace(e.Name, "\n", " ", -1)
+		e.Text = strings.Replace(e.Text, "\n", " ", -1)
+		return e
+	}
+	if err := c.Connect(s.ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn := <-s.conn
+	conn.Write([]byte("ROOM_ADDED 3 carol 10 lob\nby\r\nMESSAGE 3 bob hi\nQUIT\r\n"))
+	if e := next(t, c, EventMessage); e.Text != "hi QUIT" {
+		t.Errorf("Event should be sanitized: %+v", e)
+	}
+	if r, _ := c.Room(3); r.Name != "lob by" {
+		t.Errorf("Room state should be sanitized: %+v", r)
+	}
+}
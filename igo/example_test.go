@@ -0,0 +1,32 @@
+package igo_test
+
+import (
+	"log"
+	"strings"
+
+	"github.com/Neetless/iGoClient/igo"
+)
+
+// This bot enters room 1 and answers "!ping".
+func Example() {
+	c := igo.NewClient()
+	if err := c.Connect("localhost:10000"); err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+	c.Login(igo.Profile{User: "pingbot", ID: 1, ClientInfo: "pingbot"})
+	c.OpenRoom(1)
+
+	for e := range c.Events() {
+		switch e.Type {
+		case igo.EventMessage:
+			if strings.TrimSpace(e.Text) == "!ping" {
+				c.Shout(e.Room, "pong "+e.Name)
+			}
+		case igo.EventEnter:
+			if r, ok := c.Room(e.Room); ok && r.Entered {
+				c.Shout(e.Room, "welcome "+e.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,328 @@
+// Package igo implements the iGo chat protocol, a connection and room state
+// so that bots and other frontends can be built on it.
+package igo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// EventType identify a decoded server line.
+type EventType string
+
+const (
+	// EventMessage is "MESSAGE <room> <sender> <text>".
+	EventMessage EventType = "message"
+	// EventEnter is "ENTER <room> <name>".
+	EventEnter EventType = "enter"
+	// EventLeave is "LEAVE <room> <name>".
+	EventLeave EventType = "leave"
+	// EventDirect is "PRIVATE <sender> <text>", a message only for us.
+	EventDirect EventType = "direct"
+	// EventUsers is "USERS <room> <name>:<name>:...".
+	EventUsers EventType = "users"
+	// EventProfile is "PROFILE <user> <id> <level> [<introduction>]", an answer of GET_PROFILE.
+	EventProfile EventType = "profile"
+	// EventRoomAdded is "ROOM_ADDED <room> <owner> <capacity> <name> [<topic>]".
+	EventRoomAdded EventType = "room_added"
+	// EventRoomRemoved is "ROOM_REMOVED <room>".
+	EventRoomRemoved EventType = "room_removed"
+	// EventOK is "OK <command> [<room>]", an acknowledgement of our command.
+	EventOK EventType = "ok"
+	// EventServerPing is "SVR_PING" which must be answered by "OK SVR_PING".
+	EventServerPing EventType = "server_ping"
+	// EventQuit is used when the server closed the connection.
+	EventQuit EventType = "quit"
+	// EventUnknown is used for lines which are not decoded.
+	EventUnknown EventType = "unknown"
+)
+
+// Event is a decoded server line.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	Room int       `json:"room,omitempty"`
+	// Name is a sender, a member or a room name depending on Type.
+	Name  string `json:"name,omitempty"`
+	Owner string `json:"owner,omitempty"`
+	// Text is a message or the topic of an added room.
+	Text  string   `json:"text,omitempty"`
+	Users []string `json:"users,omitempty"`
+	// Capacity is the max number of members of an added room.
+	Capacity int `json:"capacity,omitempty"`
+	// Profile is set for EventProfile.
+	Profile *Profile `json:"profile,omitempty"`
+	// Command is an acknowledged command of EventOK like "OPEN_ROOM".
+	Command string `json:"command,omitempty"`
+	// Args are every token after the first one.
+	Args []string `json:"-"`
+	Raw  string   `json:"raw"`
+}
+
+// LineSplitter split chunks read from the connection into lines.
+// A line divided between chunks is kept until the rest arrives.
+type LineSplitter struct {
+	pending string
+}
+
+// Split return complete lines in the chunk.
+func (ls *LineSplitter) Split(chunk string) []string {
+	lines := strings.Split(ls.pending+chunk, "\r\n")
+	ls.pending = lines[len(lines)-1]
+	var complete []string
+	for _, line := range lines[:len(lines)-1] {
+		if line != "" {
+			complete = append(complete, line)
+		}
+	}
+	return complete
+}
+
+// QuitEvent return EventQuit for a closed connection. err is the read error
+// and nil means the server closed it.
+func QuitEvent(err error) Event {
+	e := Event{Type: EventQuit, Time: time.Now()}
+	if err != nil {
+		e.Text = err.Error()
+	}
+	return e
+}
+
+// ParseLine decode a server line. Broken lines become EventUnknown.
+func ParseLine(line string) Event {
+	e := Event{Type: EventUnknown, Time: time.Now(), Raw: line}
+	tokens := strings.Split(line, " ")
+	e.Args = tokens[1:]
+	room := func() bool {
+		if len(tokens) < 2 {
+			return false
+		}
+		id, err := strconv.Atoi(tokens[1])
+		e.Room = id
+		return err == nil
+	}
+
+	switch tokens[0] {
+	case "MESSAGE":
+		if room() && len(tokens) >= 3 {
+			e.Type = EventMessage
+			e.Name = tokens[2]
+			e.Text = strings.Join(tokens[3:], " ")
+		}
+	case "PRIVATE":
+		if len(tokens) >= 3 {
+			e.Type = EventDirect
+			e.Name = tokens[1]
+			e.Text = strings.Join(tokens[2:], " ")
+		}
+	case "ENTER", "LEAVE":
+		if room() && len(tokens) >= 3 {
+			e.Type = EventEnter
+			if tokens[0] == "LEAVE" {
+				e.Type = EventLeave
+			}
+			e.Name = tokens[2]
+		}
+	case "USERS":
+		if room() && len(tokens) >= 3 {
+			e.Type = EventUsers
+			for _, user := range strings.Split(tokens[2], ":") {
+				if user != "" {
+					e.Users = append(e.Users, user)
+				}
+			}
+		}
+	case "PROFILE":
+		if len(tokens) >= 4 {
+			id, err := strconv.Atoi(tokens[2])
+			if err != nil {
+				break
+			}
+			e.Type = EventProfile
+			e.Name = tokens[1]
+			e.Profile = &Profile{User: tokens[1], ID: id, Level: tokens[3], Introduction: strings.Join(tokens[4:], " ")}
+		}
+	case "ROOM_ADDED":
+		if room() && len(tokens) >= 5 {
+			e.Type = EventRoomAdded
+			e.Owner = tokens[2]
+			e.Capacity, _ = strconv.Atoi(tokens[3])
+			e.Name = tokens[4]
+			e.Text = strings.Join(tokens[5:], " ")
+		}
+	case "ROOM_REMOVED":
+		if room() {
+			e.Type = EventRoomRemoved
+		}
+	case "OK":
+		if len(tokens) >= 2 {
+			e.Type = EventOK
+			e.Command = tokens[1]
+			if len(tokens) >= 3 {
+				e.Room, _ = strconv.Atoi(tokens[2])
+			}
+		}
+	case "SVR_PING":
+		e.Type = EventServerPing
+	}
+	return e
+}
+
+// String return the event as a line for humans.
+func (e Event) String() string {
+	switch e.Type {
+	case EventMessage:
+		return fmt.Sprintf("[%d] %s: %s", e.Room, e.Name, e.Text)
+	case EventDirect:
+		return fmt.Sprintf("[dm] %s: %s", e.Name, e.Text)
+	case EventEnter:
+		return fmt.Sprintf("[%d] -> %s entered", e.Room, e.Name)
+	case EventLeave:
+		return fmt.Sprintf("[%d] <- %s left", e.Room, e.Name)
+	case EventUsers:
+		return fmt.Sprintf("[%d] members: %s", e.Room, strings.Join(e.Users, " "))
+	case EventProfile:
+		return fmt.Sprintf("%s: id %d level %s %s", e.Name, e.Profile.ID, e.Profile.Level, e.Profile.Introduction)
+	case EventRoomAdded:
+		return fmt.Sprintf("room %d %s added by %s", e.Room, e.Name, e.Owner)
+	case EventRoomRemoved:
+		return fmt.Sprintf("room %d removed", e.Room)
+	case EventOK:
+		return "ok " + strings.Join(e.Args, " ")
+	case EventQuit:
+		return "disconnected"
+	}
+	return e.Raw
+}
+
+// ParseRoom parse a room ID typed by a user.
+func ParseRoom(room string) (int, error) {
+	id, err := strconv.Atoi(room)
+	if err != nil {
+		return 0, fmt.Errorf("invalid room %q", room)
+	}
+	return id, nil
+}
+
+// OpenRoomCommand return OPEN_ROOM command for the room.
+func OpenRoomCommand(room int) string {
+	return fmt.Sprintf("OPEN_ROOM %d", room)
+}
+
+// CloseRoomCommand return CLOSE_ROOM command for the room.
+func CloseRoomCommand(room int) string {
+	return fmt.Sprintf("CLOSE_ROOM %d", room)
+}
+
+// Capacity of a room created by AddRoomCommand.
+const (
+	MinRoomCapacity = 2
+	MaxRoomCapacity = 1000
+)
+
+// AddRoomCommand return ADD_ROOM command which create a room owned by us.
+// name must be one word and capacity must be MinRoomCapacity to MaxRoomCapacity.
+// The server answers "OK ADD_ROOM <room>" and broadcasts ROOM_ADDED.
+func AddRoomCommand(name string, capacity int, topic string) (string, error) {
+	if err := validName(name); err != nil {
+		return "", err
+	}
+	if capacity < MinRoomCapacity || capacity > MaxRoomCapacity {
+		return "", fmt.Errorf("capacity must be %d to %d", MinRoomCapacity, MaxRoomCapacity)
+	}
+	if err := ValidText(topic); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(fmt.Sprintf("ADD_ROOM %d %s %s", capacity, name, topic)), nil
+}
+
+// RemoveRoomCommand return REMOVE_ROOM command for a room owned by us.
+func RemoveRoomCommand(room int) string {
+	return fmt.Sprintf("REMOVE_ROOM %d", room)
+}
+
+// ErrMultiLine is returned for text which would be split into several protocol lines.
+var ErrMultiLine = errors.New("text must be one line")
+
+// ValidText return ErrMultiLine when text has CR or LF. A line break in a command
+// would let the rest of the text be read by the server as another command.
+func ValidText(text string) error {
+	if strings.ContainsAny(text, "\r\n") {
+		return ErrMultiLine
+	}
+	return nil
+}
+
+// ErrInvalidName is returned for a user or room name which is empty or has whitespace.
+// Such a name would shift the fields after it.
+var ErrInvalidName = errors.New("a name must be one word")
+
+// validName return ErrMultiLine or ErrInvalidName unless name is one word.
+func validName(name string) error {
+	if err := ValidText(name); err != nil {
+		return err
+	}
+	if name == "" || strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+// ShoutCommand return SHOUT command which send text to the room.
+func ShoutCommand(room int, text string) (string, error) {
+	if err := ValidText(text); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("SHOUT %d %s", room, text), nil
+}
+
+// DirectCommand return PRIVATE command which send text only to the user.
+// user must be one word. See ErrInvalidName.
+func DirectCommand(user, text string) (string, error) {
+	if err := validName(user); err != nil {
+		return "", err
+	}
+	if err := ValidText(text); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("PRIVATE %s %s", user, text), nil
+}
+
+// Profile is a user sent at login or received by GET_PROFILE.
+type Profile struct {
+	User         string `json:"user"`
+	ID           int    `json:"id"`
+	Introduction string `json:"introduction,omitempty"`
+	Level        string `json:"level,omitempty"`
+	ClientInfo   string `json:"client_info,omitempty"`
+}
+
+// LoginCommands return commands sent just after connecting.
+func LoginCommands(p Profile) []string {
+	return []string{
+		"LOGIN " + p.User,
+		IntroductionCommand(p.Introduction),
+		LevelCommand(p.Level),
+		"CLIENT_INFO " + p.ClientInfo,
+		"SET_ID " + fmt.Sprintf("%d", p.ID),
+	}
+}
+
+// IntroductionCommand return SET_INTRO command which change our introduction.
+func IntroductionCommand(text string) string {
+	return "SET_INTRO " + text
+}
+
+// LevelCommand return SET_LEVEL command which change our level.
+func LevelCommand(level string) string {
+	return "SET_LEVEL " + level
+}
+
+// ProfileCommand return GET_PROFILE command. The server answers PROFILE.
+func ProfileCommand(user string) string {
+	return "GET_PROFILE " + user
+}
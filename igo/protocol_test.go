@@ -0,0 +1,206 @@
+package igo
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	cases := []struct {
+		line     string
+		expected Event
+	}{
+		{"MESSAGE 3 alice hello world", Event{Type: EventMessage, Room: 3, Name: "alice", Text: "hello world"}},
+		{"MESSAGE 3 alice", Event{Type: EventMessage, Room: 3, Name: "alice"}},
+		{"ENTER 2 bob", Event{Type: EventEnter, Room: 2, Name: "bob"}},
+		{"LEAVE 2 bob", Event{Type: EventLeave, Room: 2, Name: "bob"}},
+		{"USERS 4 a:b:", Event{Type: EventUsers, Room: 4, Users: []string{"a", "b"}}},
+		{"ROOM_ADDED 5 carol 10 lobby", Event{Type: EventRoomAdded, Room: 5, Owner: "carol", Capacity: 10, Name: "lobby"}},
+		{"ROOM_ADDED 6 carol 4 dev release plans", Event{Type: EventRoomAdded, Room: 6, Owner: "carol", Capacity: 4, Name: "dev", Text: "release plans"}},
+		{"PRIVATE bob are you there", Event{Type: EventDirect, Name: "bob", Text: "are you there"}},
+		{"PRIVATE bob", Event{Type: EventUnknown}},
+		{"PROFILE bob 7 3 likes go", Event{Type: EventProfile, Name: "bob", Profile: &Profile{User: "bob", ID: 7, Level: "3", Introduction: "likes go"}}},
+		{"PROFILE bob x 3", Event{Type: EventUnknown}},
+		{"ROOM_REMOVED 5", Event{Type: EventRoomRemoved, Room: 5}},
+		{"OK OPEN_ROOM 7", Event{Type: EventOK, Room: 7, Command: "OPEN_ROOM"}},
+		{"OK PING", Event{Type: EventOK, Command: "PING"}},
+		{"SVR_PING", Event{Type: EventServerPing}},
+		// A server line can't fake a disconnect.
+		{"quit", Event{Type: EventUnknown}},
+		// Broken lines must not panic.
+		{"MESSAGE", Event{Type: EventUnknown}},
+		{"MESSAGE x alice hi", Event{Type: EventUnknown}},
+		{"ROOM_ADDED 5 carol", Event{Type: EventUnknown, Room: 5}},
+		{"", Event{Type: EventUnknown}},
+	}
+	for _, c := range cases {
+		e := ParseLine(c.line)
+		e.Time, e.Args, e.Raw = c.expected.Time, nil, ""
+		if !reflect.DeepEqual(e, c.expected) {
+			t.Errorf("Unexpected event for %q.\nexpected: %+v\nresult: %+v", c.line, c.expected, e)
+		}
+	}
+}
+
+func TestLineSplitter(t *testing.T) {
+	var ls LineSplitter
+	if lines := ls.Split("MESSAGE 1 a hi\r\nENTER 1 "); !reflect.DeepEqual(lines, []string{"MESSAGE 1 a hi"}) {
+		t.Errorf("Unexpected lines: %q", lines)
+	}
+	if lines := ls.Split("bob\r\n\r\nSVR_PING\r\n"); !reflect.DeepEqual(lines, []string{"ENTER 1 bob", "SVR_PING"}) {
+		t.Errorf("Unexpected lines: %q", lines)
+	}
+	if lines := ls.Split("quit"); lines != nil {
+		t.Errorf("Unexpected lines: %q", lines)
+	}
+}
+
+func TestRoomCommands(t *testing.T) {
+	if id, err := ParseRoom("3"); err != nil || id != 3 {
+		t.Errorf("Unexpected room: %d %v", id, err)
+	}
+	if _, err := ParseRoom("three"); err == nil {
+		t.Errorf("ParseRoom should reject invalid room")
+	}
+	if cmd := OpenRoomCommand(3); cmd != "OPEN_ROOM 3" {
+		t.Errorf("Unexpected command: %q", cmd)
+	}
+	if cmd := CloseRoomCommand(3); cmd != "CLOSE_ROOM 3" {
+		t.Errorf("Unexpected command: %q", cmd)
+	}
+	if cmd, err := ShoutCommand(2, "hi there"); err != nil || cmd != "SHOUT 2 hi there" {
+		t.Errorf("Unexpected command: %q %v", cmd, err)
+	}
+	if _, err := ShoutCommand(2, "hi\nREMOVE_ROOM 3"); err != ErrMultiLine {
+		t.Errorf("Multi-line text should be rejected: %v", err)
+	}
+	if cmd, err := AddRoomCommand("dev", 4, "release plans"); err != nil || cmd != "ADD_ROOM 4 dev release plans" {
+		t.Errorf("Unexpected command: %q %v", cmd, err)
+	}
+	if cmd, err := AddRoomCommand("dev", 4, ""); err != nil || cmd != "ADD_ROOM 4 dev" {
+		t.Errorf("Unexpected command: %q %v", cmd, err)
+	}
+	for _, c := range []struct {
+		name     string
+		capacity int
+		topic    string
+	}{
+		{"", 4, ""},
+		{"dev team", 4, ""},
+		{"dev\nLOGOUT", 4, ""},
+		{"dev", 1, ""},
+		{"dev", MaxRoomCapacity + 1, ""},
+		{"dev", 4, "plans\r\nREMOVE_ROOM 3"},
+	} {
+		if cmd, err := AddRoomCommand(c.name, c.capacity, c.topic); err == nil {
+			t.Errorf("AddRoomCommand(%q, %d, %q) should fail: %q", c.name, c.capacity, c.topic, cmd)
+		}
+	}
+	if cmd, err := DirectCommand("bob", "hi there"); err != nil || cmd != "PRIVATE bob hi there" {
+		t.Errorf("Unexpected command: %q %v", cmd, err)
+	}
+	for _, c := range []struct {
+		user, text string
+		err        error
+	}{
+		{"bob\rLOGOUT", "hi", ErrMultiLine},
+		{"bob", "hi\nLOGOUT", ErrMultiLine},
+		{"", "hi", ErrInvalidName},
+		{"a b", "hi", ErrInvalidName},
+		{"bob\t", "hi", ErrInvalidName},
+		{"ｂｏｂ\u3000", "hi", ErrInvalidName},
+	} {
+		if cmd, err := DirectCommand(c.user, c.text); err != c.err {
+			t.Errorf("DirectCommand(%q, %q): unexpected %q %v", c.user, c.text, cmd, err)
+		}
+	}
+	if cmd := ProfileCommand("bob"); cmd != "GET_PROFILE bob" {
+		t.Errorf("Unexpected command: %q", cmd)
+	}
+	if cmd := RemoveRoomCommand(3); cmd != "REMOVE_ROOM 3" {
+		t.Errorf("Unexpected command: %q", cmd)
+	}
+}
+
+// knownTypes are every EventType which ParseLine returns.
+var knownTypes = map[EventType]bool{
+	EventMessage: true, EventEnter: true, EventLeave: true, EventDirect: true, EventUsers: true,
+	EventProfile: true, EventRoomAdded: true, EventRoomRemoved: true, EventOK: true,
+	EventServerPing: true, EventQuit: true, EventUnknown: true,
+}
+
+func FuzzParseLine(f *testing.F) {
+	for _, seed := range []string{
+		"MESSAGE 3 alice hello world", "USERS 4 a::b:", "ROOM_ADDED 6 carol x dev topic",
+		"PROFILE bob 7 3", "PRIVATE bob  hi ", "OK OPEN_ROOM -1", "ENTER +3 bob", "",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		e := ParseLine(line)
+		if !knownTypes[e.Type] || e.Raw != line {
+			t.Fatalf("Unexpected type or raw: %+v", e)
+		}
+		if tokens := strings.Split(line, " "); !reflect.DeepEqual(e.Args, tokens[1:]) {
+			t.Fatalf("Args should be tokens after the first: %q", e.Args)
+		}
+		if strings.Contains(e.Name, " ") || strings.Contains(e.Owner, " ") || strings.Contains(e.Command, " ") {
+			t.Fatalf("Names are one token: %+v", e)
+		}
+		for _, u := range e.Users {
+			if u == "" || strings.ContainsAny(u, ": ") {
+				t.Fatalf("Unexpected user %q in %q", u, line)
+			}
+		}
+		if (e.Type == EventProfile) != (e.Profile != nil) {
+			t.Fatalf("Profile should be set only for EventProfile: %+v", e)
+		}
+
+		// A line written back from the event decodes to the same event.
+		var canonical string
+		switch e.Type {
+		case EventMessage:
+			canonical = fmt.Sprintf("MESSAGE %d %s %s", e.Room, e.Name, e.Text)
+		case EventDirect:
+			canonical = fmt.Sprintf("PRIVATE %s %s", e.Name, e.Text)
+		case EventEnter:
+			canonical = fmt.Sprintf("ENTER %d %s", e.Room, e.Name)
+		case EventRoomRemoved:
+			canonical = fmt.Sprintf("ROOM_REMOVED %d", e.Room)
+		default:
+			return
+		}
+		again := ParseLine(canonical)
+		again.Time, again.Args, again.Raw = e.Time, e.Args, e.Raw
+		if !reflect.DeepEqual(again, e) {
+			t.Fatalf("Round trip of %q changed the event.\nbefore: %+v\nafter: %+v", line, e, again)
+		}
+	})
+}
+
+func FuzzLineSplitter(f *testing.F) {
+	f.Add("MESSAGE 1 a hi\r\nENTER 1 bob\r\n", 10)
+	f.Add("a\r\n\r\nb\rc\n\r\n", 1)
+	f.Fuzz(func(t *testing.T, data string, cut int) {
+		if cut < 0 {
+			cut = -cut
+		}
+		cut %= len(data) + 1
+		var whole, parts LineSplitter
+		expected := whole.Split(data)
+		lines := append(parts.Split(data[:cut]), parts.Split(data[cut:])...)
+		if !reflect.DeepEqual(lines, expected) {
+			t.Fatalf("Lines depend on the chunk boundary %d of %q: %q %q", cut, data, lines, expected)
+		}
+		for _, line := range lines {
+			if line == "" || strings.Contains(line, "\r\n") {
+				t.Fatalf("Unexpected line %q", line)
+			}
+		}
+		if whole.pending != parts.pending {
+			t.Fatalf("Pending text differs: %q %q", whole.pending, parts.pending)
+		}
+	})
+}
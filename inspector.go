@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Neetless/iGoClient/igo"
+)
+
+// inspectorTimeFormat is a timestamp layout of inspector lines.
+const inspectorTimeFormat = "15:04:05"
+
+// InspectorLine is a protocol line sent or received.
+type InspectorLine struct {
+	Time    time.Time
+	Inbound bool
+	// Type is a decoded event type of an inbound line or the command of an outbound line.
+	Type string
+	Line string
+	seq  int
+}
+
+// Command return the first word of the line like "MESSAGE".
+func (l InspectorLine) Command() string {
+	return strings.SplitN(l.Line, " ", 2)[0]
+}
+
+// Text return the line with its timestamp, direction and type.
+func (l InspectorLine) Text() string {
+	arrow := "->"
+	if l.Inbound {
+		arrow = "<-"
+	}
+	return fmt.Sprintf("%s %s [%s] %s", l.Time.Format(inspectorTimeFormat), arrow, l.Type, l.Line)
+}
+
+// Inspector keep recent protocol lines of a connection and show them newest first.
+// Record is called from Ping goroutine too.
+type Inspector struct {
+	mu      sync.Mutex
+	maxLine int
+	lines   []InspectorLine
+	next    int
+	seq     int
+	// filter is commands to show. Empty means every command.
+	filter []string
+	// pausedAt is seq of the first line hidden by pause. 0 means not paused.
+	pausedAt int
+}
+
+// NewInspector create Inspector which keep maxLine lines.
+func NewInspector(maxLine int) *Inspector {
+	return &Inspector{maxLine: maxLine, lines: make([]InspectorLine, maxLine)}
+}
+
+// Record add a line. It can be set to ConnClient.Trace.
+// The line is sanitized because it is drawn as it is.
+func (in *Inspector) Record(line string, inbound bool) {
+	l := InspectorLine{Time: time.Now(), Inbound: inbound, Line: SanitizeText(line, maxRawLen)}
+	if inbound {
+		l.Type = string(igo.ParseLine(line).Type)
+	} else {
+		l.Type = l.Command()
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.seq++
+	l.seq = in.seq
+	in.lines[in.next] = l
+	in.next = (in.next + 1) % in.maxLine
+}
+
+// SetFilter show only lines of the commands. No command shows every line.
+func (in *Inspector) SetFilter(commands []string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.filter = nil
+	for _, c := range commands {
+		in.filter = append(in.filter, strings.ToUpper(c))
+	}
+}
+
+// Pause stop showing new lines. They are still recorded.
+func (in *Inspector) Pause() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.pausedAt == 0 {
+		in.pausedAt = in.seq + 1
+	}
+}
+
+// Resume show lines recorded while paused.
+func (in *Inspector) Resume() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.pausedAt = 0
+}
+
+// Paused return true while paused.
+func (in *Inspector) Paused() bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.pausedAt != 0
+}
+
+// shown return true when the line is visible. in.mu must be held.
+func (in *Inspector) shown(l InspectorLine) bool {
+	if l.seq == 0 || in.pausedAt != 0 && l.seq >= in.pausedAt {
+		return false
+	}
+	if len(in.filter) == 0 {
+		return true
+	}
+	for _, c := range in.filter {
+		if strings.ToUpper(l.Command()) == c || strings.ToUpper(l.Type) == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Line return nth newest visible line.
+func (in *Inspector) Line(n int) (InspectorLine, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for i := 1; i <= in.maxLine; i++ {
+		l := in.lines[(in.next-i+in.maxLine)%in.maxLine]
+		if !in.shown(l) {
+			continue
+		}
+		if n == 0 {
+			return l, true
+		}
+		n--
+	}
+	return InspectorLine{}, false
+}
+
+// GetMaxLine return the number of kept lines.
+func (in *Inspector) GetMaxLine() int {
+	return in.maxLine
+}
+
+// GetText return nth newest visible line with its number used by /inspect copy.
+func (in *Inspector) GetText(n int) string {
+	l, ok := in.Line(n)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%3d %s", n+1, l.Text())
+}
+
+// GetStyledText return nth newest visible line. Outbound lines use the own style.
+func (in *Inspector) GetStyledText(n int) []Span {
+	l, ok := in.Line(n)
+	if !ok {
+		return []Span{{"", StyleDefault}}
+	}
+	style := StyleDefault
+	if !l.Inbound {
+		style = StyleOwn
+	}
+	return []Span{
+		{fmt.Sprintf("%3d %s ", n+1, l.Time.Format(inspectorTimeFormat)), StyleTimestamp},
+		{strings.TrimPrefix(l.Text(), l.Time.Format(inspectorTimeFormat)+" "), style},
+	}
+}
+
+// RegisterInspectorCommands add /inspect. show switch to Inspector View and
+// edit put a line into EditBox.
+func RegisterInspectorCommands(cs CommandSet, inspector func() *Inspector, show func(), edit func(line string)) {
+	usage := "/inspect [filter [<command>...]|pause|resume|copy <#>]"
+	cs.Register("inspect", usage, func(args []string) ([]string, error) {
+		in := inspector()
+		if len(args) == 0 {
+			show()
+			return nil, nil
+		}
+		switch args[0] {
+		case "filter":
+			in.SetFilter(args[1:])
+			if len(args) == 1 {
+				return []string{"Inspector shows every line"}, nil
+			}
+			return []string{"Inspector shows " + strings.Join(args[1:], " ")}, nil
+		case "pause":
+			in.Pause()
+			return []string{"Inspector paused"}, nil
+		case "resume":
+			in.Resume()
+			return []string{"Inspector resumed"}, nil
+		case "copy":
+			if len(args) != 2 {
+				return nil, errUsage(usage)
+			}
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return nil, errUsage(usage)
+			}
+			l, ok := in.Line(n - 1)
+			if !ok {
+				return nil, errors.New("no line " + args[1])
+			}
+			edit(l.Line)
+			return nil, nil
+		}
+		return nil, errUsage(usage)
+	})
+}
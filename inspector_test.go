@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInspector(t *testing.T) {
+	in := NewInspector(3)
+	in.Record("LOGIN me", false)
+	in.Record("MESSAGE 3 bob hi", true)
+	if l, _ := in.Line(0); !l.Inbound || l.Type != "message" || l.Line != "MESSAGE 3 bob hi" {
+		t.Errorf("Unexpected line: %+v", l)
+	}
+	if text := in.GetText(1); !strings.HasSuffix(text, "-> [LOGIN] LOGIN me") || !strings.HasPrefix(text, "  2 ") {
+		t.Errorf("Unexpected text: %q", text)
+	}
+
+	in.Pause()
+	in.Record("SVR_PING", true)
+	if l, _ := in.Line(0); l.Line != "MESSAGE 3 bob hi" {
+		t.Errorf("Paused inspector should not show new lines: %+v", l)
+	}
+	in.Resume()
+	if l, _ := in.Line(0); l.Line != "SVR_PING" {
+		t.Errorf("Resumed inspector should show new lines: %+v", l)
+	}
+
+	in.SetFilter([]string{"message"})
+	if l, ok := in.Line(0); !ok || l.Line != "MESSAGE 3 bob hi" {
+		t.Errorf("Unexpected filtered line: %+v", l)
+	}
+	if _, ok := in.Line(1); ok {
+		t.Error("Other commands should be filtered")
+	}
+
+	// The oldest line is overwritten.
+	in.SetFilter(nil)
+	in.Record("OK SVR_PING", false)
+	if _, ok := in.Line(3); ok {
+		t.Error("Only 3 lines should be kept")
+	}
+}
+
+func TestInspectorCommands(t *testing.T) {
+	s, _ := newTestSession(t)
+	in := NewInspector(10)
+	s.Conn.Trace = in.Record
+	s.Events("MESSAGE 3 bob hi\r\n")
+	s.Conn.Send("SHOUT 3 hello")
+
+	shown, edited := false, ""
+	cs := NewCommandSet()
+	RegisterInspectorCommands(cs, func() *Inspector { return in }, func() { shown = true }, func(line string) { edited = line })
+	if _, err := cs.Execute("/inspect"); err != nil || !shown {
+		t.Errorf("Inspector should be shown: %v", err)
+	}
+	if _, err := cs.Execute("/inspect copy 2"); err != nil || edited != "MESSAGE 3 bob hi" {
+		t.Errorf("Unexpected copied line: %q %v", edited, err)
+	}
+	if _, err := cs.Execute("/inspect copy 9"); err == nil {
+		t.Error("Missing line should fail")
+	}
+	cs.Execute("/inspect pause")
+	if !in.Paused() {
+		t.Error("Inspector should be paused")
+	}
+}
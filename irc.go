@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Neetless/iGoClient/igo"
+)
+
+// ircServerName is a prefix of replies from the gateway.
+const ircServerName = "igo.gateway"
+
+// ircParse split an IRC line into the command and its parameters.
+// The prefix is dropped and the trailing parameter is the last one.
+func ircParse(line string) (string, []string) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, ":") {
+		i := strings.Index(line, " ")
+		if i < 0 {
+			return "", nil
+		}
+		line = line[i+1:]
+	}
+	var trailing *string
+	if i := strings.Index(line, " :"); i >= 0 {
+		t := line[i+2:]
+		trailing = &t
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	params := fields[1:]
+	if trailing != nil {
+		params = append(params, *trailing)
+	}
+	return strings.ToUpper(fields[0]), params
+}
+
+// ircChannel return the channel of the room.
+func ircChannel(room int) string {
+	return "#" + strconv.Itoa(room)
+}
+
+// ircRoom return the room of a channel like "#3" or "#3-lobby".
+func ircRoom(channel string) (int, bool) {
+	channel = strings.TrimPrefix(channel, "#")
+	if i := strings.IndexFunc(channel, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		channel = channel[:i]
+	}
+	id, err := strconv.Atoi(channel)
+	return id, err == nil && id > 0
+}
+
+// IRCGateway let IRC clients use the server. Each IRC connection has its own
+// upstream connection logged in with the IRC nick.
+type IRCGateway struct {
+	// Upstream is "host:port" of the iGo server.
+	Upstream string
+	// Profile is sent at login. User is replaced by the IRC nick.
+	Profile igo.Profile
+	// Encoding is the wire encoding of Upstream. See NewCodec.
+	Encoding string
+}
+
+// ircConn is an IRC client connection and its upstream.
+type ircConn struct {
+	gw       *IRCGateway
+	conn     net.Conn
+	nick     string
+	user     bool
+	upstream *igo.Client
+
+	// wmu serializes writes from the IRC reader and the upstream pump.
+	wmu sync.Mutex
+}
+
+// Serve accept IRC clients on ln.
+func (gw *IRCGateway) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go gw.ServeConn(conn)
+	}
+}
+
+// ServeConn talk with an IRC client until it quits.
+func (gw *IRCGateway) ServeConn(conn net.Conn) {
+	c := &ircConn{gw: gw, conn: conn}
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		cmd, params := ircParse(scanner.Text())
+		if cmd == "" {
+			continue
+		}
+		if !c.handle(cmd, params) {
+			break
+		}
+	}
+	if c.upstream != nil {
+		c.upstream.Logout()
+		c.upstream.Close()
+	}
+}
+
+// send write a raw IRC line. CR, LF and NUL in args are dropped so that
+// a value can't end the line and inject another command.
+func (c *ircConn) send(format string, args ...interface{}) {
+	line := strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == 0 {
+			return -1
+		}
+		return r
+	}, fmt.Sprintf(format, args...))
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	io.WriteString(c.conn, line+"\r\n")
+}
+
+// reply send a numeric reply to the client.
+func (c *ircConn) reply(code, text string) {
+	nick := c.nick
+	if nick == "" {
+		nick = "*"
+	}
+	c.send(":%s %s %s %s", ircServerName, code, nick, text)
+}
+
+// handle process a command from the IRC client. It return false to disconnect.
+func (c *ircConn) handle(cmd string, params []string) bool {
+	switch cmd {
+	case "CAP", "PASS":
+		// Capabilities are not negotiated.
+	case "NICK":
+		if len(params) < 1 {
+			c.reply("431", ":No nickname given")
+			return true
+		}
+		if c.upstream != nil {
+			c.reply("484", ":Nick change is not supported")
+			return true
+		}
+		c.nick = params[0]
+		return c.register()
+	case "USER":
+		c.user = true
+		return c.register()
+	case "PING":
+		c.send(":%s PONG %s :%s", ircServerName, ircServerName, strings.Join(params, " "))
+	case "QUIT":
+		return false
+	default:
+		if c.upstream == nil {
+			c.reply("451", ":You have not registered")
+			return true
+		}
+		c.command(cmd, params)
+	}
+	return true
+}
+
+// register connect upstream after NICK and USER.
+func (c *ircConn) register() bool {
+	if c.nick == "" || !c.user || c.upstream != nil {
+		return true
+	}
+	up := igo.NewClient()
+	// Each connection needs its own Codec which keeps split characters.
+	codec, err := NewCodec(c.gw.Encoding)
+	if err != nil {
+		c.send("ERROR :%s", err.Error())
+		return false
+	}
+	up.Codec = codec
+	// Text of other users must not break IRC lines.
+	up.Sanitize = sanitizeEvent
+	if err := up.Connect(c.gw.Upstream); err != nil {
+		c.send("ERROR :Cannot connect to %s: %s", c.gw.Upstream, err.Error())
+		return false
+	}
+	profile := c.gw.Profile
+	profile.User = c.nick
+	up.Login(profile)
+	c.upstream = up
+	go c.pump()
+
+	c.reply("001", ":Welcome to iGo through IRC "+c.nick)
+	c.reply("002", ":Your host is "+ircServerName+" for "+c.gw.Upstream)
+	c.reply("003", ":Rooms are channels like #1")
+	c.reply("004", ircServerName+" igo o o")
+	c.reply("422", ":MOTD File is missing")
+	return true
+}
+
+// command process commands after registration.
+func (c *ircConn) command(cmd string, params []string) {
+	switch cmd {
+	case "JOIN", "PART":
+		if len(params) < 1 {
+			c.reply("461", cmd+" :Not enough parameters")
+			return
+		}
+		for _, channel := range strings.Split(params[0], ",") {
+			room, ok := ircRoom(channel)
+			if !ok {
+				c.reply("403", channel+" :No such channel")
+				continue
+			}
+			if cmd == "JOIN" {
+				c.upstream.OpenRoom(room)
+			} else {
+				c.upstream.CloseRoom(room)
+			}
+		}
+	case "PRIVMSG", "NOTICE":
+		if len(params) < 2 {
+			c.reply("412", ":No text to send")
+			return
+		}
+		room, ok := ircRoom(params[0])
+		if !ok {
+			c.reply("401", params[0]+" :No such nick/channel")
+			return
+		}
+		c.upstream.Shout(room, params[1])
+	case "NAMES":
+		if len(params) < 1 {
+			c.reply("366", "* :End of /NAMES list")
+			return
+		}
+		for _, channel := range strings.Split(params[0], ",") {
+			if room, ok := ircRoom(channel); ok {
+				r, _ := c.upstream.Room(room)
+				c.names(room, r.Members)
+			}
+		}
+	case "LIST":
+		c.reply("321", "Channel :Users  Name")
+		for _, r := range c.upstream.Rooms() {
+			c.reply("322", fmt.Sprintf("%s %d :%s", ircChannel(r.ID), len(r.Members), r.Name))
+		}
+		c.reply("323", ":End of /LIST")
+	case "TOPIC":
+		if len(params) >= 1 {
+			c.topic(params[0])
+		}
+	case "MODE":
+		if len(params) == 1 && strings.HasPrefix(params[0], "#") {
+			c.reply("324", params[0]+" +")
+		}
+	case "WHO":
+		if len(params) >= 1 {
+			c.reply("315", params[0]+" :End of /WHO list")
+		}
+	default:
+		c.reply("421", cmd+" :Unknown command")
+	}
+}
+
+// names send NAMES of the room.
+func (c *ircConn) names(room int, members []string) {
+	channel := ircChannel(room)
+	if len(members) > 0 {
+		c.reply("353", "= "+channel+" :"+strings.Join(members, " "))
+	}
+	c.reply("366", channel+" :End of /NAMES list")
+}
+
+// topic send the room name as the topic.
+func (c *ircConn) topic(channel string) {
+	room, ok := ircRoom(channel)
+	if !ok {
+		return
+	}
+	if r, ok := c.upstream.Room(room); ok && r.Name != "" {
+		c.reply("332", ircChannel(room)+" :"+r.Name)
+	} else {
+		c.reply("331", ircChannel(room)+" :No topic is set")
+	}
+}
+
+// pump translate upstream events into IRC messages.
+func (c *ircConn) pump() {
+	for e := range c.upstream.Events() {
+		channel := ircChannel(e.Room)
+		switch e.Type {
+		case igo.EventMessage:
+			// IRC clients show their own messages already.
+			if e.Name != c.nick {
+				c.send(":%s!%s@igo PRIVMSG %s :%s", e.Name, e.Name, channel, e.Text)
+			}
+		case igo.EventEnter:
+			if e.Name != c.nick {
+				c.send(":%s!%s@igo JOIN %s", e.Name, e.Name, channel)
+			}
+		case igo.EventLeave:
+			if e.Name != c.nick {
+				c.send(":%s!%s@igo PART %s", e.Name, e.Name, channel)
+			}
+		case igo.EventUsers:
+			c.names(e.Room, e.Users)
+		case igo.EventOK:
+			switch e.Command {
+			case "OPEN_ROOM":
+				c.send(":%s!%s@igo JOIN %s", c.nick, c.nick, ircChannel(e.Room))
+				c.topic(ircChannel(e.Room))
+				r, _ := c.upstream.Room(e.Room)
+				c.names(e.Room, r.Members)
+			case "CLOSE_ROOM":
+				c.send(":%s!%s@igo PART %s", c.nick, c.nick, ircChannel(e.Room))
+			}
+		case igo.EventRoomAdded:
+			c.send(":%s NOTICE %s :Room %s (%s) was added by %s", ircServerName, c.nick, channel, e.Name, e.Owner)
+		case igo.EventRoomRemoved:
+			c.send(":%s NOTICE %s :Room %s was removed", ircServerName, c.nick, channel)
+		case igo.EventQuit:
+			c.send("ERROR :Closing link (upstream disconnected)")
+			c.conn.Close()
+		}
+	}
+}
+
+// runIRCGateway is gateway mode which listen for IRC clients on addr
+// and connect them to the server. addr must be loopback because IRC clients
+// are not authenticated and get a session logged in with the profile.
+func runIRCGateway(addr string, server ServerSettings) int {
+	if !isLoopback(addr) {
+		fmt.Fprintf(os.Stderr, "Error: %s is not a loopback address\n", addr)
+		return 2
+	}
+	if _, err := NewCodec(server.Encoding); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 2
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	defer ln.Close()
+	ircLog.Info("Start IRC gateway", "addr", addr)
+	gw := &IRCGateway{Upstream: server.Host + ":" + server.Port, Profile: server.profile(), Encoding: server.Encoding}
+	if err := gw.Serve(ln); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
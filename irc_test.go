@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Neetless/iGoClient/igo"
+)
+
+func TestIRCParse(t *testing.T) {
+	cases := []struct {
+		line   string
+		cmd    string
+		params []string
+	}{
+		{"NICK alice\r\n", "NICK", []string{"alice"}},
+		{"privmsg #3 :hello there", "PRIVMSG", []string{"#3", "hello there"}},
+		{":alice!a@h JOIN #1,#2", "JOIN", []string{"#1,#2"}},
+		{"USER a 0 * :Alice A", "USER", []string{"a", "0", "*", "Alice A"}},
+		{"", "", nil},
+		{":prefixonly", "", nil},
+	}
+	for _, c := range cases {
+		cmd, params := ircParse(c.line)
+		if cmd != c.cmd || !reflect.DeepEqual(params, c.params) {
+			t.Errorf("%q: unexpected %q %q", c.line, cmd, params)
+		}
+	}
+	for channel, room := range map[string]int{"#3": 3, "#12-lobby": 12, "#x": 0, "#0": 0} {
+		if id, ok := ircRoom(channel); id != room && ok {
+			t.Errorf("%s: unexpected room %d", channel, id)
+		}
+	}
+}
+
+func TestIRCSendStripsLineBreaks(t *testing.T) {
+	written := &bytes.Buffer{}
+	c := &ircConn{conn: recordConn{written: written}, nick: "alice"}
+	c.send(":%s NOTICE %s :%s %d", ircServerName, c.nick, "a\r\nQUIT\x00", 3)
+	if expected := ":igo.gateway NOTICE alice :aQUIT 3\r\n"; written.String() != expected {
+		t.Errorf("Unexpected line: %q", written.String())
+	}
+}
+
+// ircExpect read lines until one contains want.
+func ircExpect(t *testing.T, r *bufio.Reader, want string) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("waiting %q: %v", want, err)
+		}
+		if strings.Contains(line, want) {
+			return
+		}
+	}
+}
+
+func TestIRCGateway(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	upLines := make(chan string, 100)
+	upConn := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		upConn <- conn
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			upLines <- strings.TrimRight(scanner.Text(), "\r")
+		}
+	}()
+	upExpect := func(want string) {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case line := <-upLines:
+				if line == want {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("Timeout waiting upstream %q", want)
+			}
+		}
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	gw := &IRCGateway{Upstream: ln.Addr().String(), Profile: igo.Profile{User: "ignored", ID: 1}, Encoding: "euc-jp"}
+	go gw.ServeConn(server)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(client)
+	send := func(line string) {
+		go client.Write([]byte(line + "\r\n"))
+	}
+
+	send("PRIVMSG #1 :too early")
+	ircExpect(t, r, " 451 ")
+	send("NICK alice")
+	send("USER alice 0 * :Alice")
+	ircExpect(t, r, " 001 alice ")
+	upExpect("LOGIN alice")
+	up := <-upConn
+
+	up.Write([]byte("ROOM_ADDED 3 carol 10 lobby\r\n"))
+	ircExpect(t, r, "NOTICE alice :Room #3 (lobby) was added by carol")
+	send("JOIN #3")
+	upExpect("OPEN_ROOM 3")
+	up.Write([]byte("OK OPEN_ROOM 3\r\n"))
+	ircExpect(t, r, ":alice!alice@igo JOIN #3")
+	ircExpect(t, r, " 332 alice #3 :lobby")
+	up.Write([]byte("USERS 3 bob:alice\r\n"))
+	ircExpect(t, r, " 353 alice = #3 :bob alice")
+
+	up.Write([]byte("MESSAGE 3 bob hi alice\r\nENTER 3 dave\r\nLEAVE 3 bob\r\n"))
+	ircExpect(t, r, ":bob!bob@igo PRIVMSG #3 :hi alice")
+	ircExpect(t, r, ":dave!dave@igo JOIN #3")
+	ircExpect(t, r, ":bob!bob@igo PART #3")
+	// A bare LF from upstream must not start another IRC line.
+	up.Write([]byte("MESSAGE 3 dave hi\nKILL alice\r\n"))
+	ircExpect(t, r, ":dave!dave@igo PRIVMSG #3 :hi␊KILL alice")
+
+	send("PRIVMSG #3 :hello bob")
+	upExpect("SHOUT 3 hello bob")
+	// Upstream lines are converted from and to the encoding.
+	up.Write([]byte(eucjpHello))
+	ircExpect(t, r, ":bob!bob@igo PRIVMSG #3 :こんにちは")
+	send("PRIVMSG #3 :こんにちは")
+	upExpect("SHOUT 3 \xa4\xb3\xa4\xf3\xa4\xcb\xa4\xc1\xa4\xcf")
+	send("PING :abc")
+	ircExpect(t, r, "PONG igo.gateway :abc")
+	send("LIST")
+	ircExpect(t, r, " 322 alice #3 2 :lobby")
+	ircExpect(t, r, " 323 ")
+
+	send("PART #3")
+	upExpect("CLOSE_ROOM 3")
+	send("QUIT :bye")
+	upExpect("LOGOUT")
+}
+
+func TestRunIRCGatewayRefused(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:6667", ":6667", "192.0.2.1:6667"} {
+		if code := runIRCGateway(addr, ServerSettings{}); code != 2 {
+			t.Errorf("%s should be refused: %d", addr, code)
+		}
+	}
+	if code := runIRCGateway("127.0.0.1:0", ServerSettings{Encoding: "ebcdic"}); code != 2 {
+		t.Errorf("Unknown encoding should be refused: %d", code)
+	}
+}
@@ -2,10 +2,11 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"os"
+	"strings"
 	"unicode/utf8"
 
+	"github.com/Neetless/iGoClient/igo"
 	"github.com/nsf/termbox-go"
 )
 
@@ -17,7 +18,8 @@ type TextArea interface {
 
 // TextScreen manage what should show in the text area.
 type TextScreen struct {
-	ta TextArea
+	ta    TextArea
+	Theme *Theme
 }
 
 // SetTextArea set TextArea field.
@@ -32,6 +34,12 @@ func (ts *TextScreen) GetTextArea() TextArea {
 
 // Draw textlog.
 func (ts *TextScreen) Draw() {
+	if sta, ok := ts.GetTextArea().(StyledTextArea); ok && ts.Theme != nil {
+		for i := 0; i < sta.GetMaxLine(); i++ {
+			setSpanLine(0, i+2, ts.Theme, sta.GetStyledText(i))
+		}
+		return
+	}
 	for i := 0; i < ts.GetTextArea().GetMaxLine(); i++ {
 		setCellLine(0, i+2,
 			termbox.ColorDefault, termbox.ColorDefault,
@@ -45,25 +53,43 @@ type TextBox struct {
 	maxLine        int
 	oldestPosition int
 	textLogs       []string
+	styles         []string
 }
 
 // NewTextBox create TextBox instance.
 func NewTextBox(maxLine int) *TextBox {
-	return &TextBox{maxLine, 0, make([]string, maxLine)}
+	return &TextBox{maxLine, 0, make([]string, maxLine), make([]string, maxLine)}
 }
 
-// GetText return the ordered textLog.
-func (tb *TextBox) GetText(n int) string {
+// position return index of nth newest text.
+func (tb *TextBox) position(n int) int {
 	position := (tb.oldestPosition+tb.maxLine-1)%tb.maxLine - n
 	for position < 0 {
 		position = tb.maxLine + position
 	}
-	return tb.textLogs[position]
+	return position
+}
+
+// GetText return the ordered textLog.
+func (tb *TextBox) GetText(n int) string {
+	return tb.textLogs[tb.position(n)]
+}
+
+// GetStyledText return the ordered textLog with its style.
+func (tb *TextBox) GetStyledText(n int) []Span {
+	p := tb.position(n)
+	return []Span{{tb.textLogs[p], tb.styles[p]}}
 }
 
 // AppendText append text to textLogs.
 func (tb *TextBox) AppendText(text string) {
+	tb.AppendStyledText(text, StyleDefault)
+}
+
+// AppendStyledText append text drawn with given style name.
+func (tb *TextBox) AppendStyledText(text, style string) {
 	tb.textLogs[tb.oldestPosition] = text
+	tb.styles[tb.oldestPosition] = style
 	// Set position to oldest log.
 	tb.oldestPosition = (tb.oldestPosition + 1) % tb.maxLine
 }
@@ -80,30 +106,149 @@ const (
 
 // ChatBox contain a every room's conversation logs.
 type ChatBox struct {
+	// MaxRoom is the number of logs allocated first. More logs are added when they are used up.
 	MaxRoom        int
 	CurrentRoomID  int
 	ChatLogs       []ChatLog
 	rooms          *[]RoomInfo
 	ShowRoomMember bool
+	// Self is our login name used to find our own messages.
+	Self string
+	// Highlighter find mentions. Nil means no mention detection.
+	Highlighter *Highlighter
+	// Mentions collect every mentioned line of all rooms.
+	Mentions *TextBox
+	// Filter dim ignored members in member view. Nil means no filter.
+	Filter *Filter
+	// TimeFormat is a layout of timestamps. Empty means no timestamp.
+	TimeFormat string
+	// History store appended entries on disk. Nil means no history.
+	History *History
+	// CurrentPeer is the user of the direct conversation shown instead of a room.
+	CurrentPeer string
+	// Profiles give levels shown in member view. Nil means no level.
+	Profiles map[string]igo.Profile
 }
 
 // NewChatBox create new instance for ChatBox
 func NewChatBox(maxRoom int, rooms *[]RoomInfo) *ChatBox {
-	MaxLogs := 30
 	logs := make([]ChatLog, maxRoom)
 	for i := range logs {
-		logs[i] = ChatLog{NotExist, MaxLogs, NewTextBox(MaxLogs)}
+		logs[i] = newChatLog()
 	}
-	return &ChatBox{maxRoom, NotExist, logs, rooms, false}
+	return &ChatBox{maxRoom, NotExist, logs, rooms, false, "", nil, NewTextBox(20), nil,
+		DefaultTimestampFormat, nil, "", nil}
 }
 
-// AppendText append chat log
+// AppendText append chat log received now.
+// A chat log starts with the sender's name.
 func (cb *ChatBox) AppendText(id int, chatLog string) {
+	sender, text := splitSender(chatLog)
+	cb.AppendEntry(NewMessageEntry(id, sender, text))
+}
+
+// AppendEntry append the entry to its room's or peer's log.
+// A day change separator is inserted before the first entry of a day.
+func (cb *ChatBox) AppendEntry(e ChatEntry) {
+	i := cb.logIndex(e.RoomID, e.Peer)
+	logs := cb.ChatLogs[i].Logs
+	if last := logs.Entry(0); !last.IsZero() && !sameDay(last.Time, e.Time) {
+		logs.Append(ChatEntry{Time: e.Time, RoomID: e.RoomID, Kind: KindDayChange})
+	}
+
+	// A pending message is written when the server confirms it. See ResolvePending.
+	if !e.Pending {
+		cb.appendHistory(e)
+	}
+
+	if e.Kind != KindMessage || e.Style == StyleDim {
+		logs.Append(e)
+		return
+	}
+	if cb.Highlighter != nil && e.Sender != cb.Self && cb.Highlighter.Match(e.Body) {
+		e.Style = StyleMention
+	}
+	logs.Append(e)
+	cb.countUnread(i)
+	if e.Style == StyleMention {
+		cb.ChatLogs[i].Mentions++
+		where := fmt.Sprintf("Room %d", e.RoomID)
+		if e.Peer != "" {
+			where = "Direct " + e.Peer
+		}
+		cb.Mentions.AppendStyledText(fmt.Sprintf("%s %s %s",
+			e.Time.Format(DefaultTimestampFormat), where, e.Text()), StyleMention)
+		cb.Highlighter.Alert(where, e.Text())
+	}
+}
+
+// RoomEntries return entries of the room kept in memory from the oldest.
+func (cb *ChatBox) RoomEntries(id int) []ChatEntry {
+	for _, cl := range cb.ChatLogs {
+		if cl.RoomID == id && cl.Peer == "" {
+			return cl.Logs.Entries()
+		}
+	}
+	return nil
+}
+
+// ResolvePending mark our oldest pending message in the room as sent and write it to History.
+// text is an echo sanitized like other inbound text, so it is compared with the sanitized body.
+// Empty text match any message.
+func (cb *ChatBox) ResolvePending(id int, text string) bool {
+	for _, cl := range cb.ChatLogs {
+		if cl.RoomID == id && cl.Peer == "" {
+			e, ok := cl.Logs.Resolve(func(e ChatEntry) bool {
+				return text == "" || SanitizeText(e.Body, maxTextLen) == text
+			})
+			if ok {
+				cb.appendHistory(e)
+			}
+			return ok
+		}
+	}
+	return false
+}
+
+// appendHistory write the entry to History when it is enabled.
+func (cb *ChatBox) appendHistory(e ChatEntry) {
+	if cb.History == nil {
+		return
+	}
+	if err := cb.History.Append(e); err != nil {
+		uiLog.Error("Cannot write history", "room", e.RoomID, "error", err)
+	}
+}
+
+// DirectEntries return entries of the direct conversation kept in memory from the oldest.
+func (cb *ChatBox) DirectEntries(peer string) []ChatEntry {
+	for _, cl := range cb.ChatLogs {
+		if cl.Peer == peer && peer != "" {
+			return cl.Logs.Entries()
+		}
+	}
+	return nil
+}
+
+// Peers return logs of direct conversations.
+func (cb *ChatBox) Peers() []ChatLog {
+	var peers []ChatLog
+	for _, cl := range cb.ChatLogs {
+		if cl.Peer != "" {
+			peers = append(peers, cl)
+		}
+	}
+	return peers
+}
+
+// logIndex return index of the conversation log of the room or the peer.
+// When cb doesn't have the log, a free log is assigned to them.
+// ChatLogs grow when every log is used, so no room or peer is dropped.
+func (cb *ChatBox) logIndex(id int, peer string) int {
 	// When cb has the room's conversation log.
 	for i, log := range cb.ChatLogs {
-		if log.RoomID == id {
-			cb.ChatLogs[i].Logs.AppendText(chatLog)
-			return
+		if log.RoomID == id && log.Peer == peer {
+			return i
 		}
 	}
 
@@ -111,37 +256,107 @@ func (cb *ChatBox) AppendText(id int, chatLog string) {
 	for i, cl := range cb.ChatLogs {
 		if cl.RoomID == NotExist {
 			cb.ChatLogs[i].RoomID = id
-			cb.ChatLogs[i].Logs.AppendText(chatLog)
-			return
+			cb.ChatLogs[i].Peer = peer
+			return i
 		}
 	}
+	cl := newChatLog()
+	cl.RoomID, cl.Peer = id, peer
+	cb.ChatLogs = append(cb.ChatLogs, cl)
+	return len(cb.ChatLogs) - 1
+}
+
+// countUnread increase unread count when the log is not shown now.
+func (cb *ChatBox) countUnread(i int) {
+	if cb.ChatLogs[i].RoomID != cb.CurrentRoomID || cb.ChatLogs[i].Peer != cb.CurrentPeer {
+		cb.ChatLogs[i].Unread++
+	}
+}
+
+// SetCurrentRoom change the room to show and mark its log as read.
+func (cb *ChatBox) SetCurrentRoom(id int) {
+	cb.CurrentRoomID = id
+	cb.CurrentPeer = ""
+	cb.markRead()
+}
+
+// SetCurrentPeer show the direct conversation with the peer and mark it as read.
+func (cb *ChatBox) SetCurrentPeer(peer string) {
+	cb.CurrentRoomID = 0
+	cb.CurrentPeer = peer
+	cb.markRead()
+}
+
+// markRead clear unread counts of the log shown now.
+func (cb *ChatBox) markRead() {
+	for i, cl := range cb.ChatLogs {
+		if cl.RoomID == cb.CurrentRoomID && cl.Peer == cb.CurrentPeer {
+			cb.ChatLogs[i].Unread = 0
+			cb.ChatLogs[i].Mentions = 0
+		}
+	}
+}
+
+// MentionTotal return the sum of unread mentions of every room.
+func (cb *ChatBox) MentionTotal() int {
+	total := 0
+	for _, cl := range cb.ChatLogs {
+		total += cl.Mentions
+	}
+	return total
+}
+
+// UnreadTotal return the sum of unread lines of every room.
+func (cb *ChatBox) UnreadTotal() int {
+	total := 0
+	for _, cl := range cb.ChatLogs {
+		total += cl.Unread
+	}
+	return total
 }
 
 // GetText return given line's text
 func (cb *ChatBox) GetText(n int) string {
+	var text string
+	for _, span := range cb.GetStyledText(n) {
+		text += span.Text
+	}
+	return text
+}
+
+// GetStyledText return given line's text with timestamp and nick colored.
+func (cb *ChatBox) GetStyledText(n int) []Span {
 	if cb.CurrentRoomID == NotExist {
-		return " "
+		return []Span{{" ", StyleDefault}}
 	}
 
-	if cb.ShowRoomMember {
+	if cb.ShowRoomMember && cb.CurrentPeer == "" {
 		for _, room := range *cb.rooms {
 			if room.ID == cb.CurrentRoomID {
-				return fmt.Sprintf("Room %d %s", room.ID, room.Members[n])
+				prefix := fmt.Sprintf("Room %d ", room.ID)
+				name := room.Members[n]
+				level := ""
+				if p, ok := cb.Profiles[name]; ok && name != EmptyMember && p.Level != "" {
+					level = " (level " + p.Level + ")"
+				}
+				if cb.Filter != nil && name != EmptyMember && cb.Filter.Ignored(name) {
+					return []Span{{prefix + name + level, StyleDim}}
+				}
+				return []Span{{prefix, StyleDefault}, {name, StyleNick}, {level, StyleNotice}}
 			}
 		}
 	}
 	for _, chatlog := range cb.ChatLogs {
-		if chatlog.RoomID == cb.CurrentRoomID {
-			return chatlog.Logs.GetText(n)
+		if chatlog.RoomID == cb.CurrentRoomID && chatlog.Peer == cb.CurrentPeer {
+			return chatlog.Logs.Entry(n).Spans(cb.Self, cb.TimeFormat)
 		}
 	}
-	return " "
-
+	return []Span{{" ", StyleDefault}}
 }
 
 // GetMaxLine return max line of conversation.
 func (cb *ChatBox) GetMaxLine() int {
-	if cb.ShowRoomMember {
+	if cb.ShowRoomMember && cb.CurrentPeer == "" {
 		for _, room := range *cb.rooms {
 			if room.ID == cb.CurrentRoomID {
 				return room.MaxMember
@@ -156,19 +371,46 @@ func (cb *ChatBox) GetMaxLine() int {
 type ChatLog struct {
 	RoomID  int
 	MaxLine int
-	Logs    *TextBox
+	Logs    *EntryBox
+	// Unread is a number of messages appended while the room is not shown.
+	Unread int
+	// Mentions is a number of unread messages which matched Highlighter.
+	Mentions int
+	// Peer is set for a direct conversation. RoomID is 0 then.
+	Peer string
+}
+
+// newChatLog return an unassigned log.
+func newChatLog() ChatLog {
+	const maxLogs = 30
+	return ChatLog{NotExist, maxLogs, NewEntryBox(maxLogs), 0, 0, ""}
 }
 
 // RoomBox has room list to show
 type RoomBox struct {
 	maxRoomNum int
 	rooms      *[]RoomInfo
+	selected   *int
 }
 
 // NewRoomBox is a constructor of RoomBox
 func NewRoomBox(max int) *RoomBox {
 	ris := make([]RoomInfo, max)
-	return &RoomBox{max, &ris}
+	return &RoomBox{max, &ris, nil}
+}
+
+// TrackSelection share the selected room ID, usually ChatBox.CurrentRoomID.
+func (r *RoomBox) TrackSelection(id *int) {
+	r.selected = id
+}
+
+// GetStyledText return room line with the selected room highlighted.
+func (r *RoomBox) GetStyledText(n int) []Span {
+	style := StyleDefault
+	if r.selected != nil && (*r.rooms)[n].ID != 0 && (*r.rooms)[n].ID == *r.selected {
+		style = StyleSelected
+	}
+	return []Span{{r.GetText(n), style}}
 }
 
 // GetText return
@@ -186,6 +428,16 @@ func (r *RoomBox) GetMaxLine() int {
 	return r.maxRoomNum
 }
 
+// Room return RoomInfo which has given id.
+func (r *RoomBox) Room(id int) (RoomInfo, bool) {
+	for _, room := range *r.rooms {
+		if room.ID != 0 && room.ID == id {
+			return room, true
+		}
+	}
+	return RoomInfo{}, false
+}
+
 // RemoveRoom remove RoomInfo from list
 func (r *RoomBox) RemoveRoom(id int) {
 	for i, room := range *r.rooms {
@@ -194,7 +446,7 @@ func (r *RoomBox) RemoveRoom(id int) {
 			return
 		}
 	}
-	log.Println("Cannot remove room. No such room.")
+	uiLog.Warn("Cannot remove room. No such room.", "room", id)
 }
 
 // AppendRoom append new room to the slice
@@ -202,7 +454,7 @@ func (r *RoomBox) AppendRoom(ri RoomInfo) {
 	// Check whether the room already exist.
 	for _, room := range *r.rooms {
 		if ri.ID == room.ID {
-			log.Println("Cannnot append room. The room already exists.")
+			uiLog.Warn("Cannot append room. The room already exists.", "room", ri.ID)
 			return
 		}
 	}
@@ -223,9 +475,16 @@ const (
 )
 
 // OtherEnterRoom is detect someone entered a room and add the member to the room member list.
+// A member already in the list is not added again. ENTER and USERS can report the same
+// member, and a duplicate would take two slots and stay listed after one LEAVE.
 func (r *RoomBox) OtherEnterRoom(roomID int, name string) {
 	for i, room := range *r.rooms {
 		if room.ID == roomID {
+			for _, member := range room.Members {
+				if member == name {
+					return
+				}
+			}
 			for j, member := range room.Members {
 				if member == EmptyMember {
 					(*r.rooms)[i].Members[j] = name
@@ -275,14 +534,26 @@ type RoomInfo struct {
 	Name      string
 	Owner     string
 	MaxMember int
+	Topic     string
 	Members   []string
 	Entered   bool
 }
 
+// MemberCount return the number of members in the room.
+func (ri RoomInfo) MemberCount() int {
+	count := 0
+	for _, member := range ri.Members {
+		if member != EmptyMember {
+			count++
+		}
+	}
+	return count
+}
+
 // NewRoomInfo is a constructor of RoomInfo.
 func NewRoomInfo(id int, name, owner string) RoomInfo {
 	// Assume max member is 30.
-	return RoomInfo{id, name, owner, 10, make([]string, 10), false}
+	return RoomInfo{id, name, owner, 10, "", make([]string, 10), false}
 }
 
 // Drawable is a interface which has screen draw function.
@@ -306,16 +577,20 @@ func (ws *WholeScreen) append(box Drawable) {
 	ws.screenBoxes = append(ws.screenBoxes, box)
 }
 
-// TODO Not tested and not used
-func setCellLine(x, y int, fg, bg termbox.Attribute, msg string) {
+// setCell is termbox.SetCell. Tests replace it to see drawn cells.
+var setCell = termbox.SetCell
+
+// setCellLine draw msg from x, y and return the next x position.
+func setCellLine(x, y int, fg, bg termbox.Attribute, msg string) int {
 	for _, c := range msg {
-		termbox.SetCell(x, y, c, fg, bg)
+		setCell(x, y, c, fg, bg)
 		x++
 		// Multibyte character use 2 cell spaces.
 		if utf8.RuneLen(c) > 2 {
 			x++
 		}
 	}
+	return x
 }
 
 func setVoffsetAndCoffset(text []byte, boffset int) (voffset, coffset int) {
@@ -371,9 +646,10 @@ type EditBox struct {
 
 // Draw EditBox part on screen.
 func (eb *EditBox) Draw() {
+	// A new line of multi-line text is shown as a mark.
 	setCellLine(0, 0,
 		termbox.ColorDefault, termbox.ColorDefault,
-		string(eb.text[:]))
+		strings.Replace(string(eb.text[:]), "\n", "¶", -1))
 
 	setCellLine(0, 1,
 		termbox.ColorDefault, termbox.ColorDefault,
@@ -425,6 +701,13 @@ func (eb *EditBox) InsertRune(r rune) {
 	eb.MoveCursorOneRuneForward()
 }
 
+// InsertText insert text like pasted one to EditBox.text
+func (eb *EditBox) InsertText(text string) {
+	for _, r := range text {
+		eb.InsertRune(r)
+	}
+}
+
 // DeleteRuneBackward delete previous character from boffset position
 func (eb *EditBox) DeleteRuneBackward() {
 	if eb.cursorBoffset == 0 {
@@ -440,6 +723,12 @@ func (eb *EditBox) AdjustVOffset(witdh int) {
 	// TODO not implemented yet
 }
 
+// SetText replace the text and move the cursor to the end.
+func (eb *EditBox) SetText(text string) {
+	eb.text = []byte(text)
+	eb.MoveCursorTo(len(eb.text))
+}
+
 // GetAndDeleteText return current text and delete all the text
 func (eb *EditBox) GetAndDeleteText() []byte {
 	text := eb.text
@@ -480,8 +769,7 @@ func byteSliceRemove(text []byte, from, to int) []byte {
 // This function interrupt process.
 func Input(done <-chan struct{}) <-chan termbox.Event {
 	if !termbox.IsInit {
-		// TODO use logrus
-		fmt.Errorf("ERROR: termbox is not initialized\n")
+		uiLog.Error("termbox is not initialized")
 		os.Exit(1)
 	}
 	termbox.SetInputMode(termbox.InputEsc)
@@ -492,7 +780,7 @@ func Input(done <-chan struct{}) <-chan termbox.Event {
 		for {
 			select {
 			case <-done:
-				log.Println("Input got done")
+				uiLog.Debug("Input got done")
 				// Terminaite this goroutine.
 				return
 			default:
@@ -1,23 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
-	"os"
 	"strings"
 	"testing"
+	"testing/quick"
 	utf8 "unicode/utf8"
 
 	"github.com/nsf/termbox-go"
 )
 
 type mockDrawable struct {
-	t  *testing.T
-	id int
+	drawn *[]int
+	id    int
 }
 
 func (md *mockDrawable) Draw() {
-	// Run "go test -v ." to show Logf
-	md.t.Logf("This mock id is %d\n", md.id)
+	*md.drawn = append(*md.drawn, md.id)
 }
 
 func TestByteSliceInsert(t *testing.T) {
@@ -26,8 +26,12 @@ func TestByteSliceInsert(t *testing.T) {
 	offset := 3
 	what := []byte("1")
 	result := byteSliceInsert(text, offset, what)
-	t.Logf("result: %s\n", result)
-	t.Logf("expected: %s\n", expected)
+	if !bytes.Equal(result, expected) {
+		t.Errorf("Unexpected bytes.\nexpected: %s\nresult: %s", expected, result)
+	}
+	if result := byteSliceRemove(result, offset, offset+1); string(result) != "abcde" {
+		t.Errorf("Unexpected bytes after remove: %s", result)
+	}
 }
 
 func TestInsertRune(t *testing.T) {
@@ -36,24 +40,48 @@ func TestInsertRune(t *testing.T) {
 	insert, _ := utf8.DecodeRune([]byte("I"))
 	eb.InsertRune(insert)
 
-	t.Logf("result: %s\n", eb.text)
-	t.Logf("expected: %s\n", expected)
+	if !bytes.Equal(eb.text, expected) {
+		t.Errorf("Unexpected text.\nexpected: %s\nresult: %s", expected, eb.text)
+	}
+	if eb.cursorBoffset != 2 || eb.cursorCoffset != 2 || eb.cursorVoffset != 2 {
+		t.Errorf("Cursor should be after the inserted rune: %+v", eb)
+	}
 }
 
 func TestWholeScreen(t *testing.T) {
-	m1 := &mockDrawable{t: t, id: 1}
-	m2 := &mockDrawable{t: t, id: 2}
+	var drawn []int
+	m1 := &mockDrawable{&drawn, 1}
+	m2 := &mockDrawable{&drawn, 2}
 	ws := &WholeScreen{}
 	ws.append(m1)
 	ws.append(m2)
 	ws.drawAll()
+	if fmt.Sprint(drawn) != "[1 2]" {
+		t.Errorf("Parts should be drawn in order: %v", drawn)
+	}
 }
 
 func TestMoveCursor(t *testing.T) {
 	var eb EditBox
-	t.Logf("cursor boffset: %d\n", eb.cursorBoffset)
-	r, _ := utf8.DecodeLastRune([]byte("a"))
-	eb.InsertRune(r)
+	eb.InsertText("aあ\tb")
+	if eb.cursorBoffset != 6 || eb.cursorCoffset != 4 || eb.cursorVoffset != 9 {
+		t.Errorf("Unexpected cursor at the end: %+v", eb)
+	}
+	eb.MoveCursorOneRuneBackward()
+	eb.MoveCursorOneRuneBackward()
+	if eb.cursorBoffset != 4 || eb.cursorCoffset != 2 || eb.cursorVoffset != 3 {
+		t.Errorf("Unexpected cursor after moving back: %+v", eb)
+	}
+	eb.DeleteRuneBackward()
+	if string(eb.text) != "a\tb" || eb.cursorBoffset != 1 {
+		t.Errorf("Unexpected text after delete: %q %+v", eb.text, eb)
+	}
+	eb.MoveCursorTo(0)
+	eb.MoveCursorOneRuneBackward()
+	eb.DeleteRuneBackward()
+	if string(eb.text) != "a\tb" || eb.cursorBoffset != 0 {
+		t.Errorf("Nothing should happen at the start: %q %+v", eb.text, eb)
+	}
 }
 
 func TestRoomList(t *testing.T) {
@@ -61,17 +89,21 @@ func TestRoomList(t *testing.T) {
 	chatLogs := NewChatBox(20, roomList.rooms)
 	roomList.AppendRoom(NewRoomInfo(1, "a", "b"))
 	roomList.OtherEnterRoom(1, "test")
-	t.Logf((*roomList.rooms)[0].Members[0])
-	t.Logf((*chatLogs.rooms)[0].Members[0])
+	if (*chatLogs.rooms)[0].Members[0] != "test" {
+		t.Errorf("ChatBox should share rooms of RoomBox: %+v", (*chatLogs.rooms)[0])
+	}
 	chatLogs.CurrentRoomID = 1
 	chatLogs.ShowRoomMember = true
-	t.Logf(fmt.Sprintf("%d\n", chatLogs.CurrentRoomID))
-	t.Logf(chatLogs.GetText(0))
+	if text := chatLogs.GetText(0); text != "Room 1 test" {
+		t.Errorf("Unexpected member line: %q", text)
+	}
 	chatLogs.ShowRoomMember = false
-	t.Logf(chatLogs.GetText(0))
-
-	t.Logf(fmt.Sprintf("max line: %d", chatLogs.GetMaxLine()))
-
+	if text := chatLogs.GetText(0); text != " " {
+		t.Errorf("Room without logs should be empty: %q", text)
+	}
+	if n := chatLogs.GetMaxLine(); n != 30 {
+		t.Errorf("Unexpected max line: %d", n)
+	}
 }
 
 func TestAppendOfTextBox(t *testing.T) {
@@ -103,15 +135,38 @@ func TestAppendOfTextBox(t *testing.T) {
 
 func TestRoomBox(t *testing.T) {
 	rb := NewRoomBox(10)
+	if len(*rb.rooms) != 10 || rb.GetMaxLine() != 10 {
+		t.Fatalf("Unexpected rooms: %d", len(*rb.rooms))
+	}
 	for _, r := range *rb.rooms {
-		t.Logf("%v", r)
+		if r.ID != 0 {
+			t.Errorf("New RoomBox should be empty: %+v", r)
+		}
 	}
 }
 
+func TestRoomBoxEnterTwice(t *testing.T) {
+	rb := NewRoomBox(10)
+	rb.AppendRoom(NewRoomInfo(1, "lobby", "carol"))
+	rb.OtherEnterRoom(1, "bob")
+	rb.OtherEnterRoom(1, "bob")
+	ri, _ := rb.Room(1)
+	if ri.MemberCount() != 1 {
+		t.Errorf("A member entering twice should be listed once: %q", ri.Members)
+	}
+	rb.OtherLeaveRoom(1, "bob")
+	if ri, _ := rb.Room(1); ri.MemberCount() != 0 {
+		t.Errorf("One LEAVE should remove the member: %q", ri.Members)
+	}
+}
+
+// TestEditBoxDraw is an interactive test. Type and press Esc to finish.
 func TestEditBoxDraw(t *testing.T) {
+	if testing.Short() {
+		t.Skip("interactive test")
+	}
 	if err := termbox.Init(); err != nil {
-		t.Logf("ERROR: Cannot initialize termbox")
-		os.Exit(1)
+		t.Skip("no terminal: " + err.Error())
 	}
 	defer termbox.Close()
 	var eb EditBox
@@ -153,3 +208,187 @@ func TestEditBoxDraw(t *testing.T) {
 
 	}
 }
+
+// editRunes are runes inserted by FuzzEditBox. Invalid runes are stored as U+FFFD.
+var editRunes = []rune{'a', 'あ', '\t', '\n', '🍣', ' ', utf8.RuneError, -1, 0xd800}
+
+// visualWidth return cells which runes take like the cursor of EditBox.
+func visualWidth(runes []rune) int {
+	width := 0
+	for _, r := range runes {
+		switch {
+		case r == '\t':
+			width += tabstopLength - width%tabstopLength
+		case utf8.RuneLen(r) > 1:
+			width += 2
+		default:
+			width++
+		}
+	}
+	return width
+}
+
+// FuzzEditBox run a sequence of EditBox operations and compare it with a slice of runes.
+func FuzzEditBox(f *testing.F) {
+	f.Add([]byte{0, 1, 0, 4, 3, 0, 2, 1, 4, 5, 1, 2})
+	f.Add([]byte{0, 7, 0, 2, 3, 3, 0, 8, 1, 4, 0, 5})
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		var eb EditBox
+		var model []rune
+		cursor := 0
+		for i := 0; i < len(ops); i++ {
+			arg := 0
+			if i+1 < len(ops) {
+				arg = int(ops[i+1])
+			}
+			switch ops[i] % 6 {
+			case 0:
+				r := editRunes[arg%len(editRunes)]
+				eb.InsertRune(r)
+				if !utf8.ValidRune(r) {
+					r = utf8.RuneError
+				}
+				model = append(model[:cursor], append([]rune{r}, model[cursor:]...)...)
+				cursor++
+				i++
+			case 1:
+				eb.DeleteRuneBackward()
+				if cursor > 0 {
+					model = append(model[:cursor-1], model[cursor:]...)
+					cursor--
+				}
+			case 2:
+				eb.MoveCursorOneRuneForward()
+				if cursor < len(model) {
+					cursor++
+				}
+			case 3:
+				eb.MoveCursorOneRuneBackward()
+				if cursor > 0 {
+					cursor--
+				}
+			case 4:
+				cursor = arg % (len(model) + 1)
+				eb.MoveCursorTo(len(string(model[:cursor])))
+				i++
+			case 5:
+				if text := eb.GetAndDeleteText(); string(text) != string(model) {
+					t.Fatalf("Unexpected text %q, want %q", text, string(model))
+				}
+				model, cursor = nil, 0
+			}
+
+			if !utf8.Valid(eb.text) || string(eb.text) != string(model) {
+				t.Fatalf("Text %q should be %q after op %d", eb.text, string(model), i)
+			}
+			if eb.cursorBoffset != len(string(model[:cursor])) || eb.cursorCoffset != cursor {
+				t.Fatalf("Cursor %d/%d should be at rune %d of %q", eb.cursorBoffset, eb.cursorCoffset, cursor, string(model))
+			}
+			if w := visualWidth(model[:cursor]); eb.cursorVoffset != w {
+				t.Fatalf("Visual cursor %d should be %d in %q", eb.cursorVoffset, w, string(model))
+			}
+		}
+	})
+}
+
+func TestTextBoxOrderProperty(t *testing.T) {
+	property := func(maxLine uint8, texts []string) bool {
+		tb := NewTextBox(int(maxLine%20) + 1)
+		for _, text := range texts {
+			tb.AppendText(text)
+		}
+		for n := 0; n < tb.GetMaxLine(); n++ {
+			expected := ""
+			if n < len(texts) {
+				expected = texts[len(texts)-1-n]
+			}
+			if tb.GetText(n) != expected || tb.GetStyledText(n)[0].Text != expected {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// roomOp is an operation of RoomBox generated by testing/quick.
+type roomOp struct {
+	Kind, ID, Member uint8
+}
+
+// modelRoom is a room expected in RoomBox.
+type modelRoom struct {
+	entered bool
+	members map[string]bool
+}
+
+func TestRoomBoxProperty(t *testing.T) {
+	const maxRoom, maxID = 5, 8
+	// Missing rooms are warned on every operation.
+	_, restore := captureLog(LevelError, false)
+	defer restore()
+	property := func(ops []roomOp) bool {
+		rb := NewRoomBox(maxRoom)
+		model := make(map[int]*modelRoom)
+		for _, op := range ops {
+			id := int(op.ID%maxID) + 1
+			member := fmt.Sprintf("user%d", op.Member%15)
+			room := model[id]
+			switch op.Kind % 6 {
+			case 0:
+				rb.AppendRoom(NewRoomInfo(id, "room", "owner"))
+				if room == nil && len(model) < maxRoom {
+					model[id] = &modelRoom{members: make(map[string]bool)}
+				}
+			case 1:
+				rb.RemoveRoom(id)
+				delete(model, id)
+			case 2:
+				rb.EnterRoom(id)
+				if room != nil {
+					room.entered = true
+				}
+			case 3:
+				rb.QuitRoom(id)
+				if room != nil {
+					room.entered = false
+				}
+			case 4:
+				rb.OtherEnterRoom(id, member)
+				if room != nil && len(room.members) < defaultRoomCapacity {
+					room.members[member] = true
+				}
+			case 5:
+				rb.OtherLeaveRoom(id, member)
+				if room != nil {
+					delete(room.members, member)
+				}
+			}
+		}
+
+		for id := 1; id <= maxID; id++ {
+			ri, ok := rb.Room(id)
+			room := model[id]
+			if ok != (room != nil) {
+				return false
+			}
+			if !ok {
+				continue
+			}
+			if ri.Entered != room.entered || ri.MemberCount() != len(room.members) {
+				return false
+			}
+			for _, m := range ri.Members {
+				if m != EmptyMember && !room.members[m] {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
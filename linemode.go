@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Neetless/iGoClient/igo"
+)
+
+// Output formats of line mode.
+const (
+	LineText = "text"
+	LineJSON = "json"
+)
+
+// logoutGrace is how long line mode waits for the server after LOGOUT.
+const logoutGrace = 2 * time.Second
+
+// LinePrinter write events and command results of line mode.
+type LinePrinter struct {
+	out    io.Writer
+	format string
+	enc    *json.Encoder
+}
+
+// lineResult is a command result in JSON format.
+type lineResult struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// NewLinePrinter create LinePrinter for text or JSON format.
+func NewLinePrinter(out io.Writer, format string) (*LinePrinter, error) {
+	if format != LineText && format != LineJSON {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	return &LinePrinter{out: out, format: format, enc: json.NewEncoder(out)}, nil
+}
+
+// Event print the event. Hidden events are not printed.
+func (p *LinePrinter) Event(e Event) {
+	if e.Hidden {
+		return
+	}
+	if p.format == LineJSON {
+		p.enc.Encode(e)
+		return
+	}
+	fmt.Fprintln(p.out, e.String())
+}
+
+// Result print lines returned by a command and its error.
+func (p *LinePrinter) Result(lines []string, err error) {
+	for _, line := range lines {
+		if p.format == LineJSON {
+			p.enc.Encode(lineResult{"result", line})
+		} else {
+			fmt.Fprintln(p.out, "* "+line)
+		}
+	}
+	if err != nil {
+		if p.format == LineJSON {
+			p.enc.Encode(lineResult{"error", err.Error()})
+		} else {
+			fmt.Fprintln(p.out, "! "+err.Error())
+		}
+	}
+}
+
+// runLineMode run the frontend which read commands from in and print events to out.
+// Slash commands are executed, "quit" logs out and other lines are sent to the current room.
+func runLineMode(s *Session, in io.Reader, out io.Writer, format string) int {
+	p, err := NewLinePrinter(out, format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 2
+	}
+	if err := s.Connect(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+
+	commands := NewCommandSet()
+	s.RegisterCommands(commands)
+	searchResults := NewSearchBox(21)
+	RegisterSearchCommands(commands, s.Chats.History, searchResults, func() {
+		for n := 1; ; n++ {
+			r, ok := searchResults.Result(n)
+			if !ok {
+				return
+			}
+			p.Result([]string{fmt.Sprintf("#%d %s room %d %s",
+				n, r.Entry.Time.Format(exportTimeFormat), r.Entry.RoomID, r.Entry.Text())}, nil)
+		}
+	})
+
+	plugins := NewPluginHost(s.Settings.pluginDir(), s, commands)
+	RegisterPluginCommands(commands, plugins, func() {})
+	for _, err := range plugins.Load() {
+		lineLog.Warn("Cannot load plugin", "error", err)
+	}
+	api, err := StartAPI(s.Settings.API, s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: cannot start API: %s\n", err.Error())
+	}
+	defer api.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	s.Conn.StartQueue(s.Settings.Send, done)
+	go s.Conn.Ping(done)
+	response := s.Conn.Receive(done)
+	input := scan(done, in)
+	s.Login()
+
+	var logout <-chan time.Time
+	for {
+		select {
+		case line, ok := <-input:
+			if !ok || line == "quit" || line == "/quit" {
+				lineLog.Info("Exit by quit from line mode input")
+				s.Conn.sendInternal("LOGOUT")
+				input = nil
+				logout = time.After(logoutGrace)
+				continue
+			}
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if IsCommand(line) {
+				p.Result(commands.Execute(line))
+				continue
+			}
+			if err := s.Shout(line); err != nil {
+				p.Result(nil, err)
+			}
+		case chunk, ok := <-response:
+			events := s.Events(chunk)
+			if !ok {
+				events = s.Disconnected()
+			}
+			for _, e := range events {
+				p.Event(e)
+				api.Publish(e)
+				if e.Type == igo.EventQuit {
+					return 0
+				}
+			}
+		case err := <-s.Conn.Errors():
+			p.Result(nil, err)
+		case fn := <-api.Calls():
+			fn()
+		case <-logout:
+			return 0
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/Neetless/iGoClient/igo"
+)
+
+func TestLinePrinter(t *testing.T) {
+	if _, err := NewLinePrinter(&bytes.Buffer{}, "xml"); err == nil {
+		t.Errorf("NewLinePrinter should reject unknown format")
+	}
+
+	var text bytes.Buffer
+	p, _ := NewLinePrinter(&text, LineText)
+	p.Event(Event{Event: igo.Event{Type: igo.EventMessage, Room: 1, Name: "bob", Text: "hi"}})
+	p.Event(Event{Event: igo.Event{Type: igo.EventMessage, Room: 1, Name: "spam", Text: "buy"}, Hidden: true})
+	p.Result([]string{"done"}, errors.New("failed"))
+	expected := "[1] bob: hi\n* done\n! failed\n"
+	if text.String() != expected {
+		t.Errorf("Unexpected text output.\nexpected: %q\nresult: %q", expected, text.String())
+	}
+
+	var j bytes.Buffer
+	p, _ = NewLinePrinter(&j, LineJSON)
+	p.Event(Event{Event: igo.Event{Type: igo.EventEnter, Room: 2, Name: "bob", Raw: "ENTER 2 bob"}})
+	p.Result(nil, errors.New("failed"))
+	expected = `{"type":"enter","time":"0001-01-01T00:00:00Z","room":2,"name":"bob","raw":"ENTER 2 bob"}` + "\n" +
+		`{"type":"error","text":"failed"}` + "\n"
+	if j.String() != expected {
+		t.Errorf("Unexpected JSON output.\nexpected: %q\nresult: %q", expected, j.String())
+	}
+}
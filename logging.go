@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is a severity of a log line.
+type LogLevel int
+
+const (
+	// LevelDebug is for lines useful only while debugging like every protocol line.
+	LevelDebug LogLevel = iota
+	// LevelInfo is for normal progress like connecting.
+	LevelInfo
+	// LevelWarn is for problems which the client can work around.
+	LevelWarn
+	// LevelError is for failures.
+	LevelError
+)
+
+const (
+	// DefaultLogMaxSize is megabytes of a log file before rotation.
+	DefaultLogMaxSize = 5
+	// DefaultLogMaxFiles is rotated log files kept.
+	DefaultLogMaxFiles = 3
+	// logTimeFormat is a timestamp layout of log lines.
+	logTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// String return the name used in log lines and --log-level.
+func (l LogLevel) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "unknown"
+	}
+	return levelNames[l]
+}
+
+// ParseLogLevel return the level of the name. Empty name means LevelInfo.
+func ParseLogLevel(name string) (LogLevel, error) {
+	if name == "" {
+		return LevelInfo, nil
+	}
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return LogLevel(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q. Use debug, info, warn or error", name)
+}
+
+// LogSettings choose where and what to log.
+type LogSettings struct {
+	// Level is debug, info, warn or error. Empty means info.
+	Level string `json:"level"`
+	// File is a path of the log file. Empty means XDG state directory and "-" means stderr.
+	File string `json:"file"`
+	// MaxSize is megabytes of the file before rotation. 0 means DefaultLogMaxSize.
+	MaxSize int `json:"max_size"`
+	// MaxFiles is rotated files kept like igoclient.log.1. 0 means DefaultLogMaxFiles.
+	MaxFiles int `json:"max_files"`
+	// Bodies log message texts. They are redacted by default. Credentials are always redacted.
+	Bodies bool `json:"bodies"`
+}
+
+// logPath return the default log file.
+// It follows XDG base directory and falls back to ~/.local/state.
+func logPath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".local", "state")
+	}
+	return filepath.Join(dir, "igoclient", "igoclient.log")
+}
+
+// Loggers of components.
+var (
+	mainLog    = NewLogger("main")
+	connLog    = NewLogger("conn")
+	sessionLog = NewLogger("session")
+	uiLog      = NewLogger("ui")
+	lineLog    = NewLogger("linemode")
+	webLog     = NewLogger("web")
+	apiLog     = NewLogger("api")
+	botLog     = NewLogger("bot")
+	ircLog     = NewLogger("irc")
+	pluginLog  = NewLogger("plugin")
+)
+
+// bodyKeys are fields which have message texts.
+var bodyKeys = map[string]bool{"text": true, "body": true}
+
+// bodyCommands are protocol commands which have message texts.
+var bodyCommands = map[string]bool{"MESSAGE": true, "SHOUT": true, "PRIVATE": true, "SET_INTRO": true, "PROFILE": true}
+
+// credentialKeys are fields which are never logged.
+var credentialKeys = map[string]bool{"token": true, "password": true}
+
+// credentialCommands are protocol commands whose arguments are never logged.
+var credentialCommands = map[string]bool{"LOGIN": true, "SET_ID": true}
+
+// logSink is the destination shared by every Logger.
+type logSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	level  LogLevel
+	bodies bool
+	now    func() time.Time
+}
+
+var logOutput = &logSink{w: os.Stderr, level: LevelInfo, now: time.Now}
+
+// SetupLogging send log lines to the destination of settings.
+// The returned Closer closes the log file.
+func SetupLogging(settings LogSettings) (io.Closer, error) {
+	level, err := ParseLogLevel(settings.Level)
+	if err != nil {
+		return nil, err
+	}
+	var w io.WriteCloser = nopCloser{os.Stderr}
+	if settings.File != "-" {
+		path := settings.File
+		if path == "" {
+			path = logPath()
+		}
+		maxSize, maxFiles := settings.MaxSize, settings.MaxFiles
+		if maxSize <= 0 {
+			maxSize = DefaultLogMaxSize
+		}
+		if maxFiles <= 0 {
+			maxFiles = DefaultLogMaxFiles
+		}
+		if w, err = OpenRotatingFile(path, int64(maxSize)<<20, maxFiles); err != nil {
+			return nil, err
+		}
+	}
+	logOutput.mu.Lock()
+	defer logOutput.mu.Unlock()
+	logOutput.w = w
+	logOutput.level = level
+	logOutput.bodies = settings.Bodies
+	return w, nil
+}
+
+// nopCloser keep stderr open.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// Logger write leveled lines with structured fields of a component.
+type Logger struct {
+	component string
+	// fields are key and value pairs added to every line.
+	fields []interface{}
+}
+
+// NewLogger create Logger of the component like "session".
+func NewLogger(component string) *Logger {
+	return &Logger{component: component}
+}
+
+// With return Logger which add key and value pairs like "room", 3 to every line.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := append(append([]interface{}(nil), l.fields...), kv...)
+	return &Logger{component: l.component, fields: fields}
+}
+
+// Debug log msg with key and value pairs at LevelDebug.
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	l.log(LevelDebug, msg, kv)
+}
+
+// Info log msg with key and value pairs at LevelInfo.
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.log(LevelInfo, msg, kv)
+}
+
+// Warn log msg with key and value pairs at LevelWarn.
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	l.log(LevelWarn, msg, kv)
+}
+
+// Error log msg with key and value pairs at LevelError.
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.log(LevelError, msg, kv)
+}
+
+// Writer return io.Writer which log each written line as msg at LevelInfo.
+func (l *Logger) Writer() io.Writer {
+	return logWriter{l}
+}
+
+// logWriter is returned by Logger.Writer.
+type logWriter struct {
+	l *Logger
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.l.Info(line)
+	}
+	return len(p), nil
+}
+
+// log write a line like `time=... level=info component=session msg="..." room=3`.
+func (l *Logger) log(level LogLevel, msg string, kv []interface{}) {
+	out := logOutput
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if level < out.level {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("time=" + out.now().Format(logTimeFormat))
+	b.WriteString(" level=" + level.String())
+	b.WriteString(" component=" + logValue(l.component))
+	b.WriteString(" msg=" + logValue(msg))
+	fields := append(append([]interface{}(nil), l.fields...), kv...)
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		var value interface{} = "(missing)"
+		if i+1 < len(fields) {
+			value = fields[i+1]
+		}
+		b.WriteString(" " + key + "=" + logValue(redact(key, fmt.Sprint(value), out.bodies)))
+	}
+	b.WriteString("\n")
+	io.WriteString(out.w, b.String())
+}
+
+// redact hide credentials and, unless bodies is set, message texts of a field.
+func redact(key, value string, bodies bool) string {
+	if credentialKeys[key] {
+		return "[redacted]"
+	}
+	if key == "line" {
+		fields := strings.SplitN(value, " ", 2)
+		if len(fields) == 2 && (credentialCommands[fields[0]] || bodyCommands[fields[0]] && !bodies) {
+			return fields[0] + " [redacted]"
+		}
+		return value
+	}
+	if bodyKeys[key] && !bodies && value != "" {
+		return fmt.Sprintf("[redacted %d bytes]", len(value))
+	}
+	return value
+}
+
+// logValue quote a value when it has spaces, quotes, = or control characters.
+func logValue(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '"' || r == '=' || r == 0x7f {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+// RotatingFile is a log file renamed to path.1, path.2 and so on when it gets
+// larger than maxSize. Files older than maxFiles are removed.
+type RotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	size     int64
+}
+
+// OpenRotatingFile open or create the file only the user can read.
+func OpenRotatingFile(path string, maxSize int64, maxFiles int) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	r := &RotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open open the file at the end.
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.size = info.Size()
+	return nil
+}
+
+// Write write p and rotate the file first when p doesn't fit.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shift old files and start a new file.
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxFiles))
+	for i := r.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// Close close the file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLog send log lines to a buffer until the returned func is called.
+func captureLog(level LogLevel, bodies bool) (*bytes.Buffer, func()) {
+	buf := &bytes.Buffer{}
+	w, level0, bodies0, now := logOutput.w, logOutput.level, logOutput.bodies, logOutput.now
+	logOutput.w = buf
+	logOutput.level = level
+	logOutput.bodies = bodies
+	logOutput.now = func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return buf, func() {
+		logOutput.w, logOutput.level, logOutput.bodies, logOutput.now = w, level0, bodies0, now
+	}
+}
+
+func TestLogger(t *testing.T) {
+	buf, restore := captureLog(LevelInfo, false)
+	defer restore()
+
+	l := NewLogger("session").With("room", 3)
+	l.Debug("hidden")
+	l.Info("Room opened", "dir", "in")
+	l.Warn("Shout", "text", "secret words", "token", "abc")
+	l.Error("Protocol line", "line", "LOGIN me")
+	l.Info("Protocol line", "line", "SHOUT 3 secret words")
+	l.Info("Protocol line", "line", "OK OPEN_ROOM 3")
+	expected := []string{
+		`time=2026-01-02T03:04:05.000Z level=info component=session msg="Room opened" room=3 dir=in`,
+		`time=2026-01-02T03:04:05.000Z level=warn component=session msg=Shout room=3 text="[redacted 12 bytes]" token=[redacted]`,
+		`time=2026-01-02T03:04:05.000Z level=error component=session msg="Protocol line" room=3 line="LOGIN [redacted]"`,
+		`time=2026-01-02T03:04:05.000Z level=info component=session msg="Protocol line" room=3 line="SHOUT [redacted]"`,
+		`time=2026-01-02T03:04:05.000Z level=info component=session msg="Protocol line" room=3 line="OK OPEN_ROOM 3"`,
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected log:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	logOutput.bodies = true
+	l.Info("Protocol line", "line", "SHOUT 3 hi", "text", "hi")
+	l.Info("Protocol line", "line", "SET_ID 42")
+	if s := buf.String(); !strings.Contains(s, `line="SHOUT 3 hi" text=hi`) || !strings.Contains(s, `line="SET_ID [redacted]"`) {
+		t.Errorf("Bodies should be shown but not credentials: %s", s)
+	}
+
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Error("Unknown level should fail")
+	}
+	if level, _ := ParseLogLevel("WARN"); level != LevelWarn {
+		t.Errorf("Unexpected level: %v", level)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igolog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state", "igoclient.log")
+	r, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+	for name, expected := range map[string]string{"": "fourth\n", ".1": "third\n", ".2": "second\n"} {
+		b, err := ioutil.ReadFile(path + name)
+		if err != nil || string(b) != expected {
+			t.Errorf("Unexpected %s: %q %v", path+name, b, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Old file should be removed")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Log should be private: %v", info.Mode())
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Notification sequences supported by Highlighter.
+const (
+	NotifyNone   = ""
+	NotifyOSC9   = "osc9"
+	NotifyOSC777 = "osc777"
+)
+
+// HighlightSettings is a highlight configuration in the settings file.
+type HighlightSettings struct {
+	// Words are matched as whole words ignoring case.
+	Words []string `json:"words"`
+	// Patterns are regular expressions matched against message text.
+	Patterns []string `json:"patterns"`
+	// Bell ring the terminal bell on mention.
+	Bell bool `json:"bell"`
+	// Notify is "osc9" or "osc777" to ask the terminal a desktop notification.
+	Notify string `json:"notify"`
+}
+
+// Highlighter find messages addressed to us.
+type Highlighter struct {
+	rules  []*regexp.Regexp
+	bell   bool
+	notify string
+	// out is the terminal which gets the bell and notifications. Nil disables them.
+	out io.Writer
+}
+
+// NewHighlighter create Highlighter which matches self, words and patterns.
+// The bell and notifications are written to out, which should be the terminal of
+// a full-screen frontend. Nil out disables them, e.g. when stdout is a pipe.
+func NewHighlighter(self string, hs HighlightSettings, out io.Writer) (*Highlighter, error) {
+	h := &Highlighter{bell: hs.Bell, notify: hs.Notify, out: out}
+	switch hs.Notify {
+	case NotifyNone, NotifyOSC9, NotifyOSC777:
+	default:
+		return h, fmt.Errorf("unknown notify %q", hs.Notify)
+	}
+	words := hs.Words
+	if self != "" {
+		words = append([]string{self}, words...)
+	}
+	for _, w := range words {
+		if w == "" {
+			continue
+		}
+		// \b does not know multibyte letters, so write the boundary by hand.
+		re := regexp.MustCompile(`(?i)(^|[^\pL\pN_])` + regexp.QuoteMeta(w) + `($|[^\pL\pN_])`)
+		h.rules = append(h.rules, re)
+	}
+	for _, p := range hs.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return h, err
+		}
+		h.rules = append(h.rules, re)
+	}
+	return h, nil
+}
+
+// Match return true when the text matches one of the rules.
+func (h *Highlighter) Match(text string) bool {
+	for _, re := range h.rules {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetOutput change the writer of the bell and notifications. Nil disables them.
+func (h *Highlighter) SetOutput(out io.Writer) {
+	h.out = out
+}
+
+// Alert ring the bell and emit notification sequence if configured.
+func (h *Highlighter) Alert(title, body string) {
+	if h.out == nil {
+		return
+	}
+	if h.bell {
+		io.WriteString(h.out, "\a")
+	}
+	title, body = stripControl(title), stripControl(body)
+	switch h.notify {
+	case NotifyOSC9:
+		fmt.Fprintf(h.out, "\x1b]9;%s: %s\x07", title, body)
+	case NotifyOSC777:
+		fmt.Fprintf(h.out, "\x1b]777;notify;%s;%s\x07",
+			strings.Replace(title, ";", ",", -1), strings.Replace(body, ";", ",", -1))
+	}
+}
+
+// stripControl remove characters which terminate an OSC sequence.
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
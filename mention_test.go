@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHighlighterMatch(t *testing.T) {
+	h, err := NewHighlighter("neet", HighlightSettings{
+		Words:    []string{"release", "テスト"},
+		Patterns: []string{`bug #\d+`},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"hi neet":            true,
+		"Hi NEET!":           true,
+		"neetless is here":   false,
+		"next release soon":  true,
+		"releases":           false,
+		"これはテストです":           false,
+		"これは テスト です":         true,
+		"see bug #12":        true,
+		"see bug #":          false,
+		"nothing interested": false,
+	}
+	for text, expected := range cases {
+		if result := h.Match(text); result != expected {
+			t.Errorf("Unexpected match for %q.\nexpected: %v\nresult: %v", text, expected, result)
+		}
+	}
+
+	if _, err := NewHighlighter("neet", HighlightSettings{Patterns: []string{"("}}, nil); err == nil {
+		t.Errorf("Invalid pattern should be an error")
+	}
+}
+
+func TestHighlighterAlert(t *testing.T) {
+	var buf bytes.Buffer
+	h, _ := NewHighlighter("me", HighlightSettings{Bell: true, Notify: NotifyOSC777}, &buf)
+	h.Alert("Room 1", "alice hi me;\x1b]0;x\x07")
+	expected := "\a\x1b]777;notify;Room 1;alice hi me,]0,x\x07"
+	if buf.String() != expected {
+		t.Errorf("Unexpected alert.\nexpected: %q\nresult: %q", expected, buf.String())
+	}
+
+	// Line mode and web mode have no terminal to ring.
+	h.SetOutput(nil)
+	h.Alert("Room 1", "alice hi me")
+	if buf.String() != expected {
+		t.Errorf("Alert without output should write nothing: %q", buf.String())
+	}
+}
+
+func TestChatBoxMention(t *testing.T) {
+	var buf bytes.Buffer
+	roomList := NewRoomBox(20)
+	chatLogs := NewChatBox(20, roomList.rooms)
+	chatLogs.Self = "me"
+	chatLogs.Highlighter, _ = NewHighlighter("me", HighlightSettings{Bell: true}, &buf)
+
+	chatLogs.AppendText(1, "alice hello me")
+	chatLogs.AppendText(1, "me talking about me")
+	chatLogs.AppendText(2, "bob hello")
+
+	if chatLogs.MentionTotal() != 1 || buf.String() != "\a" {
+		t.Errorf("Only other's message should be a mention: %d %q", chatLogs.MentionTotal(), buf.String())
+	}
+	if !strings.HasSuffix(chatLogs.Mentions.GetText(0), " Room 1 alice hello me") {
+		t.Errorf("Unexpected mentions view: %v", chatLogs.Mentions.GetText(0))
+	}
+	chatLogs.SetCurrentRoom(1)
+	if chatLogs.MentionTotal() != 0 {
+		t.Errorf("Showing the room should clear mentions")
+	}
+	chatLogs.TimeFormat = ""
+	if spans := chatLogs.GetStyledText(1); spans[1].Style != StyleMention {
+		t.Errorf("Mentioned line should be highlighted: %v", spans)
+	}
+}
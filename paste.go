@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+const (
+	// bracketedPasteOn and bracketedPasteOff ask the terminal to mark pastes.
+	bracketedPasteOn  = "\x1b[?2004h"
+	bracketedPasteOff = "\x1b[?2004l"
+	// escWait is how long Esc is held to find a sequence following it.
+	escWait = 30 * time.Millisecond
+	// pasteWait ends a paste whose end mark is lost.
+	pasteWait = time.Second
+	// pasteConfirmLines is the number of pasted lines which need confirmation.
+	pasteConfirmLines = 5
+)
+
+// Terminals send these after Esc around a paste. termbox gives them as runes.
+var (
+	pasteStart = []rune("[200~")
+	pasteEnd   = []rune("[201~")
+)
+
+// InputEvent is a key event or a paste.
+type InputEvent struct {
+	termbox.Event
+	// Paste is text pasted at once. Event is empty then.
+	Paste string
+}
+
+// PasteDecoder turn bracketed pastes into one InputEvent and Esc+Enter into Alt+Enter.
+type PasteDecoder struct {
+	// held is Esc and runes which may be a start or an end mark.
+	held    []termbox.Event
+	pasting bool
+	paste   []rune
+}
+
+// Pasting return true between the start and the end mark.
+func (d *PasteDecoder) Pasting() bool {
+	return d.pasting
+}
+
+// Feed take a raw event and return decoded events.
+func (d *PasteDecoder) Feed(ev termbox.Event) []InputEvent {
+	if len(d.held) == 0 {
+		if ev.Type == termbox.EventKey && ev.Key == termbox.KeyEsc {
+			d.held = []termbox.Event{ev}
+			return nil
+		}
+		return d.emit(ev)
+	}
+
+	if !d.pasting && len(d.held) == 1 && ev.Type == termbox.EventKey && ev.Key == termbox.KeyEnter {
+		d.held = nil
+		ev.Mod |= termbox.ModAlt
+		return []InputEvent{{Event: ev}}
+	}
+	mark := pasteStart
+	if d.pasting {
+		mark = pasteEnd
+	}
+	i := len(d.held) - 1
+	if ev.Type == termbox.EventKey && ev.Key == 0 && ev.Ch == mark[i] {
+		d.held = append(d.held, ev)
+		if len(d.held)-1 < len(mark) {
+			return nil
+		}
+		d.held = nil
+		if !d.pasting {
+			d.pasting = true
+			return nil
+		}
+		return d.endPaste()
+	}
+
+	// Not a mark. Release held events and look at ev again.
+	out := d.Flush()
+	return append(out, d.Feed(ev)...)
+}
+
+// Flush release held events. It is called when no event comes for a while.
+// A paste without its end mark is ended too.
+func (d *PasteDecoder) Flush() []InputEvent {
+	var out []InputEvent
+	held := d.held
+	d.held = nil
+	for _, ev := range held {
+		out = append(out, d.emit(ev)...)
+	}
+	if d.pasting && len(held) == 0 {
+		out = append(out, d.endPaste()...)
+	}
+	return out
+}
+
+// endPaste return the pasted text. An empty paste is dropped.
+func (d *PasteDecoder) endPaste() []InputEvent {
+	d.pasting = false
+	text := string(d.paste)
+	d.paste = nil
+	if text == "" {
+		return nil
+	}
+	return []InputEvent{{Paste: text}}
+}
+
+// emit return ev or add it to the paste.
+func (d *PasteDecoder) emit(ev termbox.Event) []InputEvent {
+	if !d.pasting {
+		return []InputEvent{{Event: ev}}
+	}
+	switch {
+	case ev.Type != termbox.EventKey:
+	case ev.Key == 0:
+		d.paste = append(d.paste, ev.Ch)
+	case ev.Key == termbox.KeyEnter || ev.Key == termbox.KeyCtrlJ:
+		d.paste = append(d.paste, '\n')
+	case ev.Key == termbox.KeySpace:
+		d.paste = append(d.paste, ' ')
+	case ev.Key == termbox.KeyTab:
+		d.paste = append(d.paste, '\t')
+	}
+	return nil
+}
+
+// EnableBracketedPaste ask the terminal to mark pastes. The returned func
+// disable it and should be called before termbox.Close.
+func EnableBracketedPaste() func() {
+	fmt.Fprint(os.Stdout, bracketedPasteOn)
+	return func() {
+		fmt.Fprint(os.Stdout, bracketedPasteOff)
+	}
+}
+
+// DecodeInput decode raw events from Input with PasteDecoder.
+func DecodeInput(raw <-chan termbox.Event, done <-chan struct{}) <-chan InputEvent {
+	out := make(chan InputEvent)
+	go func() {
+		var d PasteDecoder
+		send := func(events []InputEvent) bool {
+			for _, e := range events {
+				select {
+				case out <- e:
+				case <-done:
+					return false
+				}
+			}
+			return true
+		}
+		for {
+			var timeout <-chan time.Time
+			if d.Pasting() {
+				timeout = time.After(pasteWait)
+			} else if len(d.held) > 0 {
+				timeout = time.After(escWait)
+			}
+			select {
+			case ev, ok := <-raw:
+				if !ok {
+					return
+				}
+				if !send(d.Feed(ev)) {
+					return
+				}
+			case <-timeout:
+				if !send(d.Flush()) {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// pasteLines return the number of lines of pasted text.
+func pasteLines(text string) int {
+	return strings.Count(strings.TrimRight(text, "\n"), "\n") + 1
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nsf/termbox-go"
+)
+
+// keyEvents return events which termbox gives for s. '\x1b' is Esc and '\r' is Enter.
+func keyEvents(s string) []termbox.Event {
+	var events []termbox.Event
+	for _, r := range s {
+		switch r {
+		case '\x1b':
+			events = append(events, termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEsc})
+		case '\r':
+			events = append(events, termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEnter})
+		case ' ':
+			events = append(events, termbox.Event{Type: termbox.EventKey, Key: termbox.KeySpace})
+		default:
+			events = append(events, termbox.Event{Type: termbox.EventKey, Ch: r})
+		}
+	}
+	return events
+}
+
+func feedAll(d *PasteDecoder, s string) []InputEvent {
+	var out []InputEvent
+	for _, ev := range keyEvents(s) {
+		out = append(out, d.Feed(ev)...)
+	}
+	return out
+}
+
+func TestPasteDecoder(t *testing.T) {
+	var d PasteDecoder
+	out := feedAll(&d, "a\x1b[200~one line\rtwo\r\x1b[201~b")
+	if len(out) != 3 || out[0].Ch != 'a' || out[1].Paste != "one line\ntwo\n" || out[2].Ch != 'b' {
+		t.Fatalf("Paste should be one event: %+v", out)
+	}
+
+	out = feedAll(&d, "\x1b\r")
+	if len(out) != 1 || out[0].Key != termbox.KeyEnter || out[0].Mod&termbox.ModAlt == 0 {
+		t.Errorf("Esc and Enter should be Alt+Enter: %+v", out)
+	}
+
+	// Esc alone is released by Flush and a broken mark is given as keys.
+	if out = feedAll(&d, "\x1b"); len(out) != 0 {
+		t.Errorf("Esc should be held: %+v", out)
+	}
+	if out = d.Flush(); len(out) != 1 || out[0].Key != termbox.KeyEsc {
+		t.Errorf("Esc should be released: %+v", out)
+	}
+	out = feedAll(&d, "\x1b[2x")
+	if len(out) != 4 || out[0].Key != termbox.KeyEsc || out[1].Ch != '[' || out[3].Ch != 'x' {
+		t.Errorf("Unexpected keys: %+v", out)
+	}
+
+	// A paste without its end mark ends by Flush.
+	feedAll(&d, "\x1b[200~lost")
+	if !d.Pasting() {
+		t.Fatal("Paste should be started")
+	}
+	if out = d.Flush(); len(out) != 1 || out[0].Paste != "lost" || d.Pasting() {
+		t.Errorf("Paste should be ended: %+v", out)
+	}
+}
+
+func TestPasteLines(t *testing.T) {
+	for text, expected := range map[string]int{"a": 1, "a\nb": 2, "a\nb\n": 2, "a\n\nb": 3} {
+		if n := pasteLines(text); n != expected {
+			t.Errorf("%q has %d lines: %d", text, expected, n)
+		}
+	}
+}
+
+func TestSessionShoutLines(t *testing.T) {
+	s, written := newTestSession(t)
+	s.Chats.SetCurrentRoom(3)
+	if err := s.ShoutLines("first\n\nsecond\n"); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "SHOUT 3 first\r\nSHOUT 3 second\r\n"; written.String() != expected {
+		t.Errorf("Each line should be a SHOUT: %q", written.String())
+	}
+
+	eb := new(EditBox)
+	eb.InsertText("a\nb")
+	if eb.cursorVoffset != 3 || string(eb.GetAndDeleteText()) != "a\nb" {
+		t.Errorf("Unexpected text: cursor %d", eb.cursorVoffset)
+	}
+}
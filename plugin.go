@@ -0,0 +1,430 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// pluginPaneLines is the number of lines kept in a plugin pane.
+const pluginPaneLines = 20
+
+// Plugin is a Lua script loaded from the plugin directory.
+type Plugin struct {
+	Name string
+	L    *lua.LState
+	// Pane is drawn by igo.print and shown in Plugin View.
+	Pane     *TextBox
+	commands []string
+	inbound  []*lua.LFunction
+	outbound []*lua.LFunction
+}
+
+// PluginHost load plugins and call their hooks.
+// Scripts can use these functions of the igo table.
+//
+//	igo.command(name, usage, function(args) return "result" end)
+//	igo.on_event(function(event) return false end)  -- false drops a message, a string replaces its text
+//	igo.on_send(function(line) return line end)     -- false drops the line, a string replaces it
+//	igo.send(line)  igo.shout(text)  igo.rooms()  igo.current_room()  igo.self()
+//	igo.print(text)  igo.clear()
+//
+// Plugins are bound to one session. Hooks and functions work on it, while plugin commands
+// are registered to the command set of every server.
+type PluginHost struct {
+	dir     string
+	session *Session
+	// commands are the command sets which plugin commands are registered to.
+	commands []CommandSet
+
+	// mu guards plugins. Hooks are called from Ping goroutine too.
+	mu       sync.Mutex
+	plugins  []*Plugin
+	selected int
+	// loaded is modification times of scripts seen by Load including broken ones.
+	loaded map[string]time.Time
+}
+
+// NewPluginHost create PluginHost of the session which register plugin commands to every cs.
+// Plugins are loaded by Load.
+func NewPluginHost(dir string, s *Session, cs ...CommandSet) *PluginHost {
+	h := &PluginHost{dir: dir, session: s, commands: cs}
+	s.Inbound = h.Inbound
+	s.Conn.Outbound = h.Outbound
+	return h
+}
+
+// scripts return *.lua files and their modification times.
+func (h *PluginHost) scripts() (map[string]time.Time, error) {
+	scripts := make(map[string]time.Time)
+	files, err := ioutil.ReadDir(h.dir)
+	if os.IsNotExist(err) {
+		return scripts, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".lua") {
+			scripts[filepath.Join(h.dir, f.Name())] = f.ModTime()
+		}
+	}
+	return scripts, nil
+}
+
+// Changed return true when a script is added, removed or modified.
+func (h *PluginHost) Changed() bool {
+	scripts, err := h.scripts()
+	if err != nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(scripts) != len(h.loaded) {
+		return true
+	}
+	for path, t := range scripts {
+		if loaded, ok := h.loaded[path]; !ok || !t.Equal(loaded) {
+			return true
+		}
+	}
+	return false
+}
+
+// Load unload every plugin and load scripts in the directory.
+// A broken script is skipped and reported in errs.
+func (h *PluginHost) Load() (errs []error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, p := range h.plugins {
+		h.unregister(p)
+		p.L.Close()
+	}
+	h.plugins = nil
+
+	scripts, err := h.scripts()
+	if err != nil {
+		return []error{err}
+	}
+	h.loaded = scripts
+	var paths []string
+	for path := range scripts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		p := &Plugin{
+			Name: strings.TrimSuffix(filepath.Base(path), ".lua"),
+			L:    lua.NewState(),
+			Pane: NewTextBox(pluginPaneLines),
+		}
+		h.expose(p)
+		if err := p.L.DoFile(path); err != nil {
+			h.unregister(p)
+			p.L.Close()
+			errs = append(errs, fmt.Errorf("%s: %s", p.Name, err.Error()))
+			continue
+		}
+		h.plugins = append(h.plugins, p)
+	}
+	if h.selected >= len(h.plugins) {
+		h.selected = 0
+	}
+	return errs
+}
+
+// unregister remove commands of the plugin from every command set.
+func (h *PluginHost) unregister(p *Plugin) {
+	for _, cs := range h.commands {
+		for _, name := range p.commands {
+			delete(cs, name)
+		}
+	}
+}
+
+// Plugins return names of loaded plugins.
+func (h *PluginHost) Plugins() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var names []string
+	for _, p := range h.plugins {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// Select show the pane of the plugin in Plugin View.
+func (h *PluginHost) Select(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, p := range h.plugins {
+		if p.Name == name {
+			h.selected = i
+			return true
+		}
+	}
+	return false
+}
+
+// pane return the selected pane. h.mu must be held.
+func (h *PluginHost) pane() *TextBox {
+	if h.selected < len(h.plugins) {
+		return h.plugins[h.selected].Pane
+	}
+	return nil
+}
+
+// GetMaxLine return the number of lines of a pane.
+func (h *PluginHost) GetMaxLine() int {
+	return pluginPaneLines
+}
+
+// noPluginText return nth line shown when no plugin is loaded.
+func (h *PluginHost) noPluginText(n int) string {
+	if n == 0 {
+		return "No plugin is loaded from " + h.dir
+	}
+	return ""
+}
+
+// GetText return nth line of the selected pane.
+func (h *PluginHost) GetText(n int) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if pane := h.pane(); pane != nil {
+		return pane.GetText(n)
+	}
+	return h.noPluginText(n)
+}
+
+// GetStyledText return nth line of the selected pane.
+// h.mu is held while the pane is read because plugins write it from other goroutines.
+func (h *PluginHost) GetStyledText(n int) []Span {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if pane := h.pane(); pane != nil {
+		return pane.GetStyledText(n)
+	}
+	return []Span{{h.noPluginText(n), StyleNotice}}
+}
+
+// Inbound call event hooks. It return false when a hook drops the event.
+// A hook returning a string replaces the text of the event.
+func (h *PluginHost) Inbound(e *Event) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, p := range h.plugins {
+		for _, fn := range p.inbound {
+			ret, err := p.call(fn, eventTable(p.L, e))
+			if err != nil {
+				continue
+			}
+			switch v := ret.(type) {
+			case lua.LBool:
+				if !bool(v) {
+					return false
+				}
+			case lua.LString:
+				e.Text = string(v)
+			}
+		}
+	}
+	return true
+}
+
+// Outbound call send hooks before a line is written to the server.
+// Lines the client sends by itself like PING and LOGIN don't reach it. See ConnClient.sendInternal.
+func (h *PluginHost) Outbound(line string) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, p := range h.plugins {
+		for _, fn := range p.outbound {
+			ret, err := p.call(fn, lua.LString(line))
+			if err != nil {
+				continue
+			}
+			switch v := ret.(type) {
+			case lua.LBool:
+				if !bool(v) {
+					return "", false
+				}
+			case lua.LString:
+				line = string(v)
+			}
+		}
+	}
+	return line, true
+}
+
+// call call fn and return its first result. Errors are shown in the pane.
+func (p *Plugin) call(fn *lua.LFunction, args ...lua.LValue) (lua.LValue, error) {
+	if err := p.L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, args...); err != nil {
+		pluginLog.Error("Plugin failed", "plugin", p.Name, "error", err)
+		p.Pane.AppendStyledText("error: "+err.Error(), StyleError)
+		return lua.LNil, err
+	}
+	ret := p.L.Get(-1)
+	p.L.Pop(1)
+	return ret, nil
+}
+
+// eventTable convert the event into a Lua table.
+func eventTable(L *lua.LState, e *Event) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString("type", lua.LString(e.Type))
+	t.RawSetString("room", lua.LNumber(e.Room))
+	t.RawSetString("name", lua.LString(e.Name))
+	t.RawSetString("text", lua.LString(e.Text))
+	t.RawSetString("raw", lua.LString(e.Raw))
+	users := L.NewTable()
+	for _, u := range e.Users {
+		users.Append(lua.LString(u))
+	}
+	t.RawSetString("users", users)
+	return t
+}
+
+// expose set the igo table to the plugin's state. h.mu must be held.
+func (h *PluginHost) expose(p *Plugin) {
+	L := p.L
+	s := h.session
+	api := L.NewTable()
+	L.SetFuncs(api, map[string]lua.LGFunction{
+		"command": func(L *lua.LState) int {
+			name, usage, fn := L.CheckString(1), L.CheckString(2), L.CheckFunction(3)
+			for _, cs := range h.commands {
+				if _, ok := cs[name]; ok {
+					L.RaiseError("command /%s already exists", name)
+				}
+			}
+			p.commands = append(p.commands, name)
+			run := func(args []string) ([]string, error) {
+				h.mu.Lock()
+				defer h.mu.Unlock()
+				t := p.L.NewTable()
+				for _, arg := range args {
+					t.Append(lua.LString(arg))
+				}
+				ret, err := p.call(fn, t)
+				if err != nil {
+					return nil, fmt.Errorf("/%s failed in plugin %s", name, p.Name)
+				}
+				if ret == lua.LNil {
+					return nil, nil
+				}
+				return strings.Split(ret.String(), "\n"), nil
+			}
+			for _, cs := range h.commands {
+				cs.Register(name, usage, run)
+			}
+			return 0
+		},
+		"on_event": func(L *lua.LState) int {
+			p.inbound = append(p.inbound, L.CheckFunction(1))
+			return 0
+		},
+		"on_send": func(L *lua.LState) int {
+			p.outbound = append(p.outbound, L.CheckFunction(1))
+			return 0
+		},
+		"send": func(L *lua.LState) int {
+			// Lines of plugins are queued and traced but skip send hooks.
+			if _, err := s.Conn.send(L.CheckString(1), false); err != nil {
+				L.RaiseError("%s", err.Error())
+			}
+			return 0
+		},
+		"shout": func(L *lua.LState) int {
+			room := s.Chats.CurrentRoomID
+			if L.GetTop() >= 2 {
+				room = L.CheckInt(2)
+			}
+			if err := s.shoutRoom(room, L.CheckString(1), false); err != nil {
+				L.RaiseError("%s", err.Error())
+			}
+			return 0
+		},
+		"rooms": func(L *lua.LState) int {
+			rooms := L.NewTable()
+			for _, r := range *s.Rooms.rooms {
+				if r.ID == 0 {
+					continue
+				}
+				t := L.NewTable()
+				t.RawSetString("id", lua.LNumber(r.ID))
+				t.RawSetString("name", lua.LString(r.Name))
+				t.RawSetString("owner", lua.LString(r.Owner))
+				t.RawSetString("entered", lua.LBool(r.Entered))
+				members := L.NewTable()
+				for _, m := range r.Members {
+					if m != "" {
+						members.Append(lua.LString(m))
+					}
+				}
+				t.RawSetString("members", members)
+				rooms.Append(t)
+			}
+			L.Push(rooms)
+			return 1
+		},
+		"current_room": func(L *lua.LState) int {
+			L.Push(lua.LNumber(s.Chats.CurrentRoomID))
+			return 1
+		},
+		"self": func(L *lua.LState) int {
+			L.Push(lua.LString(s.Chats.Self))
+			return 1
+		},
+		"print": func(L *lua.LState) int {
+			var parts []string
+			for i := 1; i <= L.GetTop(); i++ {
+				parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+			}
+			for _, line := range strings.Split(strings.Join(parts, " "), "\n") {
+				p.Pane.AppendText(line)
+			}
+			return 0
+		},
+		"clear": func(L *lua.LState) int {
+			p.Pane = NewTextBox(pluginPaneLines)
+			return 0
+		},
+	})
+	L.SetGlobal("igo", api)
+}
+
+// RegisterPluginCommands add /plugins.
+func RegisterPluginCommands(cs CommandSet, h *PluginHost, show func()) {
+	usage := "/plugins [reload|show <name>]"
+	cs.Register("plugins", usage, func(args []string) ([]string, error) {
+		switch {
+		case len(args) == 0:
+			names := h.Plugins()
+			if len(names) == 0 {
+				return []string{"No plugin is loaded from " + h.dir}, nil
+			}
+			return []string{"Plugins: " + strings.Join(names, " "),
+				"Plugin hooks and functions work on " + h.session.Server.Label()}, nil
+		case len(args) == 1 && args[0] == "reload":
+			errs := h.Load()
+			lines := []string{fmt.Sprintf("Loaded %d plugins", len(h.Plugins()))}
+			if len(errs) > 0 {
+				return lines, errs[0]
+			}
+			return lines, nil
+		case len(args) == 2 && args[0] == "show":
+			if !h.Select(args[1]) {
+				return nil, fmt.Errorf("no plugin %q", args[1])
+			}
+			show()
+			return nil, nil
+		}
+		return nil, errUsage(usage)
+	})
+}
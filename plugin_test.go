@@ -0,0 +1,204 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPlugin = `
+igo.command("hello", "/hello <name>", function(args)
+  return "hello " .. args[1] .. " from " .. igo.self()
+end)
+igo.on_event(function(e)
+  if e.type == "message" and e.name == "spam" then return false end
+  if e.type == "message" then return string.upper(e.text) end
+  if e.type == "enter" then igo.print(e.name .. " entered " .. #igo.rooms() .. " rooms") end
+end)
+igo.on_send(function(line)
+  if line == "SHOUT 3 secret" then return false end
+  return (string.gsub(line, ":%)", "☺"))
+end)
+`
+
+func TestPluginHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igoclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "greet.lua")
+	if err := ioutil.WriteFile(path, []byte(testPlugin), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "broken.lua"), []byte("igo.command("), 0600)
+
+	s, written := newTestSession(t)
+	cs := NewCommandSet()
+	s.RegisterCommands(cs)
+	h := NewPluginHost(dir, s, cs)
+	if errs := h.Load(); len(errs) != 1 {
+		t.Errorf("Broken plugin should be reported: %v", errs)
+	}
+	if names := h.Plugins(); !reflect.DeepEqual(names, []string{"greet"}) {
+		t.Errorf("Unexpected plugins: %v", names)
+	}
+
+	lines, err := cs.Execute("/hello bob")
+	if err != nil || !reflect.DeepEqual(lines, []string{"hello bob from me"}) {
+		t.Errorf("Unexpected command result: %q %v", lines, err)
+	}
+
+	events := s.Events("ROOM_ADDED 3 carol 10 lobby\r\nOK OPEN_ROOM 3\r\nMESSAGE 3 spam buy\r\nMESSAGE 3 bob hi\r\nENTER 3 alice\r\n")
+	if !events[2].Hidden || events[3].Hidden || events[3].Text != "HI" {
+		t.Errorf("Unexpected events: %+v", events)
+	}
+	if text := s.Chats.GetText(1); text != "bob HI" {
+		t.Errorf("Unexpected chat line: %q", text)
+	}
+	if text := h.GetText(0); text != "alice entered 1 rooms" {
+		t.Errorf("Unexpected pane line: %q", text)
+	}
+
+	s.Shout("secret")
+	s.Shout("hi :)")
+	if written.String() != "GET_PROFILE alice\r\nSHOUT 3 hi ☺\r\n" {
+		t.Errorf("Unexpected sent lines: %q", written.String())
+	}
+
+	if h.Changed() {
+		t.Errorf("Plugins should not be changed")
+	}
+	os.Remove(filepath.Join(dir, "broken.lua"))
+	os.Chtimes(path, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	ioutil.WriteFile(path, []byte(`igo.command("bye", "/bye", function() return "bye" end)`), 0600)
+	os.Chtimes(path, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	if !h.Changed() {
+		t.Errorf("Modified plugin should be detected")
+	}
+	if errs := h.Load(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if _, err := cs.Execute("/hello bob"); err == nil {
+		t.Errorf("/hello should be removed by reload")
+	}
+	if lines, _ := cs.Execute("/bye"); !reflect.DeepEqual(lines, []string{"bye"}) {
+		t.Errorf("Unexpected command result: %q", lines)
+	}
+}
+
+func TestPluginSend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igoclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "announce.lua"), []byte(`
+igo.command("announce", "/announce <text>", function(args)
+  igo.shout(args[1] .. " :)", 3)
+  igo.send("GET_ROOMS")
+end)
+igo.on_send(function(line) return (string.gsub(line, ":%)", "☺")) end)
+`), 0600)
+
+	s, written := newTestSession(t)
+	var traced []string
+	s.Conn.Trace = func(line string, inbound bool) { traced = append(traced, line) }
+	cs := NewCommandSet()
+	h := NewPluginHost(dir, s, cs)
+	if errs := h.Load(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if _, err := cs.Execute("/announce hi"); err != nil {
+		t.Fatal(err)
+	}
+	// Send hooks are skipped but the lines go through the send path.
+	if expected := "SHOUT 3 hi :)\r\nGET_ROOMS\r\n"; written.String() != expected {
+		t.Errorf("Unexpected sent lines: %q", written.String())
+	}
+	if !reflect.DeepEqual(traced, []string{"SHOUT 3 hi :)", "GET_ROOMS"}) {
+		t.Errorf("Plugin lines should be traced: %q", traced)
+	}
+	if entries := s.Chats.RoomEntries(3); len(entries) != 1 || !entries[0].Pending || entries[0].Body != "hi :)" {
+		t.Errorf("Plugin shout should be pending: %+v", entries)
+	}
+}
+
+func TestPluginCommandsOfEveryServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igoclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "greet.lua")
+	ioutil.WriteFile(path, []byte(testPlugin), 0600)
+
+	sessions := newTestSessions(t)
+	sets := []CommandSet{NewCommandSet(), NewCommandSet()}
+	h := NewPluginHost(dir, sessions[0], sets...)
+	for _, cs := range sets {
+		RegisterPluginCommands(cs, h, func() {})
+	}
+	if errs := h.Load(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	// Commands run on the session of the host whichever server is focused.
+	lines, err := sets[1].Execute("/hello bob")
+	if err != nil || !reflect.DeepEqual(lines, []string{"hello bob from me"}) {
+		t.Errorf("Unexpected command result: %q %v", lines, err)
+	}
+	if lines, _ := sets[1].Execute("/plugins"); len(lines) != 2 || lines[1] != "Plugin hooks and functions work on home" {
+		t.Errorf("The bound server should be shown: %q", lines)
+	}
+
+	ioutil.WriteFile(path, []byte(`igo.command("bye", "/bye", function() return "bye" end)`), 0600)
+	if errs := h.Load(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for i, cs := range sets {
+		if _, err := cs.Execute("/hello bob"); err == nil {
+			t.Errorf("/hello should be removed from server %d", i)
+		}
+	}
+}
+
+func TestPluginInternalLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igoclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "drop.lua"), []byte(`
+igo.on_send(function(line) return false end)
+igo.command("draw", "/draw", function() igo.clear() igo.print("a") end)
+`), 0600)
+
+	s, written := newTestSession(t)
+	h := NewPluginHost(dir, s, NewCommandSet())
+	if errs := h.Load(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	s.Login()
+	s.Events("SVR_PING\r\n")
+	s.Shout("dropped")
+	if lines := strings.Split(written.String(), "\r\n"); len(lines) != 7 || lines[0] != "LOGIN me" || lines[5] != "OK SVR_PING" {
+		t.Errorf("Internal lines should skip send hooks: %q", written.String())
+	}
+
+	// The pane is read while a plugin replaces it.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			h.commands[0].Execute("/draw")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		h.GetStyledText(0)
+	}
+	<-done
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Neetless/iGoClient/igo"
+)
+
+// askProfile request the profile of a member of an entered room once.
+func (s *Session) askProfile(room int, name string) {
+	if name == EmptyMember || name == s.Server.User || s.asked[name] {
+		return
+	}
+	if ri, ok := s.Rooms.Room(room); !ok || !ri.Entered {
+		return
+	}
+	if _, ok := s.Profiles[name]; ok {
+		return
+	}
+	s.asked[name] = true
+	s.Conn.sendInternal(igo.ProfileCommand(name))
+}
+
+// profileLines return lines which describe the profile.
+func profileLines(p igo.Profile) []string {
+	lines := []string{fmt.Sprintf("%s id %d level %s", p.User, p.ID, p.Level)}
+	if p.Introduction != "" {
+		lines = append(lines, "Introduction: "+p.Introduction)
+	}
+	return lines
+}
+
+// memberRooms return rooms where the user is a member.
+func (s *Session) memberRooms(user string) []string {
+	var rooms []string
+	for _, ri := range *s.Rooms.rooms {
+		for _, m := range ri.Members {
+			if ri.ID != 0 && m == user {
+				rooms = append(rooms, strconv.Itoa(ri.ID))
+				break
+			}
+		}
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// RegisterProfileCommands add /profile and /whois.
+// Changes by /profile last until the client exits.
+func RegisterProfileCommands(cs CommandSet, s *Session) {
+	usage := "/profile [intro <text>|level <level>]"
+	cs.Register("profile", usage, func(args []string) ([]string, error) {
+		if len(args) == 0 {
+			return profileLines(s.Server.profile()), nil
+		}
+		if len(args) < 2 {
+			return nil, errUsage(usage)
+		}
+		value := strings.Join(args[1:], " ")
+		switch args[0] {
+		case "intro":
+			if err := s.Conn.Send(igo.IntroductionCommand(value)); err != nil {
+				return nil, err
+			}
+			s.Server.Introduction = value
+		case "level":
+			if len(args) != 2 {
+				return nil, errUsage(usage)
+			}
+			if err := s.Conn.Send(igo.LevelCommand(value)); err != nil {
+				return nil, err
+			}
+			s.Server.Level = value
+		default:
+			return nil, errUsage(usage)
+		}
+		s.Profiles[s.Server.User] = s.Server.profile()
+		return profileLines(s.Server.profile()), nil
+	})
+	cs.Register("whois", "/whois <user>", func(args []string) ([]string, error) {
+		if len(args) != 1 {
+			return nil, errUsage("/whois <user>")
+		}
+		user := args[0]
+		if user == s.Server.User {
+			return profileLines(s.Server.profile()), nil
+		}
+		// Ask again to refresh. The answer is shown when it arrives.
+		if err := s.Conn.Send(igo.ProfileCommand(user)); err != nil {
+			return nil, err
+		}
+		s.asked[user] = true
+		p, ok := s.Profiles[user]
+		if !ok {
+			return []string{"Asked the server for the profile of " + user}, nil
+		}
+		lines := profileLines(p)
+		if rooms := s.memberRooms(user); len(rooms) > 0 {
+			lines = append(lines, "Rooms: "+strings.Join(rooms, " "))
+		}
+		return lines, nil
+	})
+}
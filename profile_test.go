@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfileCommands(t *testing.T) {
+	s, written := newTestSession(t)
+	cs := NewCommandSet()
+	s.RegisterCommands(cs)
+
+	s.Events("ROOM_ADDED 3 carol 10 lobby\r\nOK OPEN_ROOM 3\r\nUSERS 3 bob:me\r\nENTER 3 bob\r\n")
+	if written.String() != "GET_PROFILE bob\r\n" {
+		t.Errorf("A profile should be asked once: %q", written.String())
+	}
+	s.Events("PROFILE bob 7 3 likes go\r\n")
+	s.Chats.ShowRoomMember = true
+	if text := s.Chats.GetText(0); text != "Room 3 bob (level 3)" {
+		t.Errorf("Unexpected member line: %q", text)
+	}
+
+	written.Reset()
+	lines, err := cs.Execute("/whois bob")
+	if err != nil || strings.Join(lines, "\n") != "bob id 7 level 3\nIntroduction: likes go\nRooms: 3" {
+		t.Errorf("Unexpected whois: %q %v", lines, err)
+	}
+	if lines, _ := cs.Execute("/whois dave"); len(lines) != 1 || !strings.Contains(lines[0], "Asked") {
+		t.Errorf("Unknown profile should be asked: %q", lines)
+	}
+
+	if _, err := cs.Execute("/profile intro hello all"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.Execute("/profile level 5"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.Execute("/profile level 5 6"); err == nil {
+		t.Error("Level should be one word")
+	}
+	if expected := "GET_PROFILE bob\r\nGET_PROFILE dave\r\nSET_INTRO hello all\r\nSET_LEVEL 5\r\n"; written.String() != expected {
+		t.Errorf("Unexpected lines: %q", written.String())
+	}
+	if p := s.Profiles["me"]; p.Introduction != "hello all" || p.Level != "5" {
+		t.Errorf("Own profile should be updated: %+v", p)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Neetless/iGoClient/igo"
+)
+
+const (
+	// defaultRoomCapacity is used when the capacity of a new room is not given.
+	defaultRoomCapacity = 10
+	// maxRoomCapacity limits member slots kept for a room.
+	maxRoomCapacity = igo.MaxRoomCapacity
+)
+
+// Owns return true when the room is owned by our login.
+func (s *Session) Owns(room int) bool {
+	ri, ok := s.Rooms.Room(room)
+	return ok && ri.Owner != "" && ri.Owner == s.Server.User
+}
+
+// addRoomCommand check answers for a new room and return ADD_ROOM command.
+// An empty capacity means defaultRoomCapacity. See igo.AddRoomCommand for other checks.
+func addRoomCommand(name, capacity, topic string) (string, error) {
+	n := defaultRoomCapacity
+	if capacity != "" {
+		var err error
+		if n, err = strconv.Atoi(capacity); err != nil {
+			return "", fmt.Errorf("capacity must be %d to %d", igo.MinRoomCapacity, igo.MaxRoomCapacity)
+		}
+	}
+	return igo.AddRoomCommand(name, n, strings.TrimSpace(topic))
+}
+
+// RoomDialog ask the name, the capacity and the topic of a new room one by one.
+type RoomDialog struct {
+	answers []string
+}
+
+// roomDialogPrompts are questions of RoomDialog in order.
+var roomDialogPrompts = []string{
+	"Room name:",
+	fmt.Sprintf("Capacity (empty for %d):", defaultRoomCapacity),
+	"Topic (optional):",
+}
+
+// NewRoomDialog create RoomDialog.
+func NewRoomDialog() *RoomDialog {
+	return &RoomDialog{}
+}
+
+// Prompt return the current question.
+func (d *RoomDialog) Prompt() string {
+	return roomDialogPrompts[len(d.answers)]
+}
+
+// Input take the answer of the current question.
+// It return ADD_ROOM command and true after the last question.
+// An invalid answer returns an error and the question is asked again.
+func (d *RoomDialog) Input(answer string) (string, bool, error) {
+	answer = strings.TrimSpace(answer)
+	switch len(d.answers) {
+	case 0:
+		if _, err := addRoomCommand(answer, "", ""); err != nil {
+			return "", false, err
+		}
+	case 1:
+		if _, err := addRoomCommand("room", answer, ""); err != nil {
+			return "", false, err
+		}
+	}
+	d.answers = append(d.answers, answer)
+	if len(d.answers) < len(roomDialogPrompts) {
+		return "", false, nil
+	}
+	cmd, err := addRoomCommand(d.answers[0], d.answers[1], d.answers[2])
+	return cmd, true, err
+}
+
+// roomDetails return lines which describe the room.
+// Owner-only actions are listed only for our rooms.
+func (s *Session) roomDetails(ri RoomInfo) []string {
+	owner := ri.Owner
+	if s.Owns(ri.ID) {
+		owner += " (you)"
+	}
+	var members []string
+	for _, m := range ri.Members {
+		if m != EmptyMember {
+			members = append(members, m)
+		}
+	}
+	lines := []string{
+		fmt.Sprintf("Room %d %s", ri.ID, ri.Name),
+		"Owner: " + owner,
+		fmt.Sprintf("Members: %d/%d %s", len(members), ri.MaxMember, strings.Join(members, " ")),
+	}
+	if ri.Topic != "" {
+		lines = append(lines, "Topic: "+ri.Topic)
+	}
+	actions := fmt.Sprintf("/open %d", ri.ID)
+	if ri.Entered {
+		actions = fmt.Sprintf("/close %d", ri.ID)
+	}
+	if s.Owns(ri.ID) {
+		actions += fmt.Sprintf(" /removeroom %d", ri.ID)
+	}
+	return append(lines, "Actions: "+actions)
+}
+
+// RegisterRoomCommands add /addroom, /removeroom and /roominfo.
+// /addroom without arguments calls ask with a dialog when ask is not nil.
+func RegisterRoomCommands(cs CommandSet, s *Session, ask func(d *RoomDialog)) {
+	addUsage := "/addroom <name> [<capacity> [<topic>]]"
+	cs.Register("addroom", addUsage, func(args []string) ([]string, error) {
+		if len(args) == 0 {
+			if ask == nil {
+				return nil, errUsage(addUsage)
+			}
+			d := NewRoomDialog()
+			ask(d)
+			return []string{d.Prompt()}, nil
+		}
+		capacity := ""
+		if len(args) >= 2 {
+			capacity = args[1]
+		}
+		topic := ""
+		if len(args) >= 3 {
+			topic = strings.Join(args[2:], " ")
+		}
+		cmd, err := addRoomCommand(args[0], capacity, topic)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.Conn.Send(cmd)
+	})
+	cs.Register("removeroom", "/removeroom <room>", func(args []string) ([]string, error) {
+		if len(args) != 1 {
+			return nil, errUsage("/removeroom <room>")
+		}
+		id, err := igo.ParseRoom(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if !s.Owns(id) {
+			return nil, fmt.Errorf("room %d is not yours", id)
+		}
+		return nil, s.Conn.Send(igo.RemoveRoomCommand(id))
+	})
+	cs.Register("roominfo", "/roominfo [<room>]", func(args []string) ([]string, error) {
+		id := s.Chats.CurrentRoomID
+		switch len(args) {
+		case 0:
+		case 1:
+			var err error
+			if id, err = igo.ParseRoom(args[0]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errUsage("/roominfo [<room>]")
+		}
+		ri, ok := s.Rooms.Room(id)
+		if !ok {
+			return nil, fmt.Errorf("no room %d", id)
+		}
+		return s.roomDetails(ri), nil
+	})
+}
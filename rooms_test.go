@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRoomDialog(t *testing.T) {
+	d := NewRoomDialog()
+	if _, done, err := d.Input("two words"); err == nil || done {
+		t.Errorf("Name with spaces should be rejected: %v", err)
+	}
+	if p := d.Prompt(); p != "Room name:" {
+		t.Errorf("Name should be asked again: %q", p)
+	}
+	d.Input("dev")
+	if _, _, err := d.Input("1"); err == nil {
+		t.Error("Capacity 1 should be rejected")
+	}
+	d.Input("")
+	cmd, done, err := d.Input(" release plans ")
+	if err != nil || !done || cmd != "ADD_ROOM 10 dev release plans" {
+		t.Errorf("Unexpected command: %q %v %v", cmd, done, err)
+	}
+}
+
+func TestRoomCommands(t *testing.T) {
+	s, written := newTestSession(t)
+	var asked *RoomDialog
+	cs := NewCommandSet()
+	RegisterRoomCommands(cs, s, func(d *RoomDialog) { asked = d })
+	s.Events("ROOM_ADDED 3 me 4 dev release plans\r\nROOM_ADDED 5 carol 10 lobby\r\nUSERS 3 bob\r\n")
+
+	if lines, err := cs.Execute("/addroom"); err != nil || asked == nil || lines[0] != "Room name:" {
+		t.Errorf("/addroom should start a dialog: %q %v", lines, err)
+	}
+	if _, err := cs.Execute("/addroom games 20 board games"); err != nil {
+		t.Error(err)
+	}
+	if _, err := cs.Execute("/removeroom 5"); err == nil {
+		t.Error("Room of others should not be removed")
+	}
+	if _, err := cs.Execute("/removeroom 3"); err != nil {
+		t.Error(err)
+	}
+	if expected := "ADD_ROOM 20 games board games\r\nREMOVE_ROOM 3\r\n"; written.String() != expected {
+		t.Errorf("Unexpected lines: %q", written.String())
+	}
+
+	lines, err := cs.Execute("/roominfo 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	details := strings.Join(lines, "\n")
+	for _, want := range []string{"Owner: me (you)", "Members: 1/4 bob", "Topic: release plans", "/removeroom 3"} {
+		if !strings.Contains(details, want) {
+			t.Errorf("Details should contain %q:\n%s", want, details)
+		}
+	}
+	lines, _ = cs.Execute("/roominfo 5")
+	if details := strings.Join(lines, "\n"); strings.Contains(details, "/removeroom") {
+		t.Errorf("Owner actions should be hidden:\n%s", details)
+	}
+	if _, err := cs.Execute("/roominfo 9"); err == nil {
+		t.Error("Unknown room should fail")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Neetless/iGoClient/igo"
+)
+
+const (
+	// maxNameLen is runes kept of user names, owners and room names.
+	maxNameLen = 64
+	// maxTextLen is runes kept of messages, topics and introductions.
+	maxTextLen = 1024
+	// maxRawLen is runes kept of a raw protocol line.
+	maxRawLen = maxTextLen + 256
+	// truncatedMark is put at the end of a cut string.
+	truncatedMark = "…"
+)
+
+// isBidiControl return true for characters which reorder text around them.
+func isBidiControl(r rune) bool {
+	switch {
+	case r == 0x061c, r == 0x200e, r == 0x200f:
+		return true
+	case 0x202a <= r && r <= 0x202e:
+		return true
+	case 0x2066 <= r && r <= 0x2069:
+		return true
+	}
+	return false
+}
+
+// SanitizeText make untrusted text safe to draw. Control characters become
+// visible placeholders like "␛", bidi controls are dropped, invalid UTF-8 becomes
+// U+FFFD and the text is cut at max runes.
+func SanitizeText(s string, max int) string {
+	clean := true
+	n := 0
+	for _, r := range s {
+		n++
+		if r < 0x20 || 0x7f <= r && r <= 0x9f || r == utf8.RuneError || isBidiControl(r) {
+			clean = false
+			break
+		}
+	}
+	if clean && n <= max {
+		return s
+	}
+
+	var b strings.Builder
+	n = 0
+	for _, r := range s {
+		if isBidiControl(r) {
+			continue
+		}
+		if n == max {
+			b.WriteString(truncatedMark)
+			break
+		}
+		switch {
+		case r < 0x20:
+			// Control Pictures block has a symbol for each C0 control.
+			r = 0x2400 + r
+		case r == 0x7f:
+			r = 0x2421
+		case 0x80 <= r && r <= 0x9f:
+			r = utf8.RuneError
+		}
+		b.WriteRune(r)
+		n++
+	}
+	return b.String()
+}
+
+// sanitizeEvent sanitize every string of an inbound event before it is applied.
+func sanitizeEvent(e igo.Event) igo.Event {
+	e.Name = SanitizeText(e.Name, maxNameLen)
+	e.Owner = SanitizeText(e.Owner, maxNameLen)
+	e.Text = SanitizeText(e.Text, maxTextLen)
+	e.Command = SanitizeText(e.Command, maxNameLen)
+	e.Raw = SanitizeText(e.Raw, maxRawLen)
+	if e.Users != nil {
+		users := make([]string, len(e.Users))
+		for i, u := range e.Users {
+			users[i] = SanitizeText(u, maxNameLen)
+		}
+		e.Users = users
+	}
+	if e.Args != nil {
+		args := make([]string, len(e.Args))
+		for i, a := range e.Args {
+			args[i] = SanitizeText(a, maxTextLen)
+		}
+		e.Args = args
+	}
+	if e.Profile != nil {
+		p := *e.Profile
+		p.User = SanitizeText(p.User, maxNameLen)
+		p.Introduction = SanitizeText(p.Introduction, maxTextLen)
+		p.Level = SanitizeText(p.Level, maxNameLen)
+		p.ClientInfo = SanitizeText(p.ClientInfo, maxNameLen)
+		e.Profile = &p
+	}
+	return e
+}
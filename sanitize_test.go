@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/nsf/termbox-go"
+)
+
+func TestSanitizeText(t *testing.T) {
+	for _, tt := range []struct {
+		text     string
+		max      int
+		expected string
+	}{
+		{"hello", 10, "hello"},
+		{"\x1b[2Jbye\a", 10, "␛[2Jbye␇"},
+		{"tab\there\x7f", 10, "tab␉here␡"},
+		{"evil‮txt.exe", 20, "eviltxt.exe"},
+		{"⁦a⁩‏", 5, "a"},
+		{"\xff\xc2\x9b", 5, "��"},
+		{"abcdef", 3, "abc…"},
+		{"日本語", 3, "日本語"},
+	} {
+		if s := SanitizeText(tt.text, tt.max); s != tt.expected {
+			t.Errorf("SanitizeText(%q, %d) = %q, want %q", tt.text, tt.max, s, tt.expected)
+		}
+	}
+}
+
+func TestSessionSanitize(t *testing.T) {
+	s, _ := newTestSession(t)
+	s.Events("ROOM_ADDED 3 ca\x1brol 10 lob‮by\r\nOK OPEN_ROOM 3\r\nMESSAGE 3 bob \x1b]0;title\x07hi\r\n")
+	if text := s.Chats.GetText(0); text != "bob ␛]0;title␇hi" {
+		t.Errorf("Unexpected chat line: %q", text)
+	}
+	if room, _ := s.Rooms.Room(3); room.Name != "lobby" || room.Owner != "ca␛rol" {
+		t.Errorf("Unexpected room: %+v", room)
+	}
+	s.Events("MESSAGE 3 bob " + strings.Repeat("a", maxTextLen+10) + "\r\n")
+	if entry := s.Chats.RoomEntries(3); utf8.RuneCountInString(entry[len(entry)-1].Body) != maxTextLen+1 {
+		t.Error("Long text should be cut")
+	}
+}
+
+// captureCells replace setCell while f draws and return the drawn cells.
+func captureCells(f func()) map[[2]int]rune {
+	cells := make(map[[2]int]rune)
+	saved := setCell
+	setCell = func(x, y int, ch rune, fg, bg termbox.Attribute) {
+		cells[[2]int{x, y}] = ch
+	}
+	defer func() { setCell = saved }()
+	f()
+	return cells
+}
+
+// drawArea draw every line of the area like TextScreen.Draw.
+func drawArea(ta TextArea) {
+	ts := &TextScreen{ta: ta, Theme: DefaultTheme()}
+	ts.Draw()
+	ts.Theme = nil
+	ts.Draw()
+}
+
+// FuzzInboundRender send a line from the server through parsing, the session state
+// and every view showing server text, and check cells drawn on the screen.
+func FuzzInboundRender(f *testing.F) {
+	for _, seed := range []string{
+		"MESSAGE 3 bob \x1b[2J\x1b]0;pwn\x07",
+		"ROOM_ADDED 4 ev‮il 10 na\x00me to\x9bpic",
+		"USERS 3 a\x1b:b⁦",
+		"ENTER 3 \x1b[31mred",
+		"PROFILE bob 7 3 \x9b31m\r\nENTER 3 bob",
+		"PRIVATE bob \xff\xfe\x08\x08",
+		"MESSAGE 3 bob " + strings.Repeat("‮\x1b", 600),
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		s, _ := newTestSession(t)
+		inspector := NewInspector(20)
+		s.Conn.Trace = inspector.Record
+		connMsg := NewTextBox(20)
+		s.Events("ROOM_ADDED 3 carol 10 lobby\r\nOK OPEN_ROOM 3\r\n")
+		for _, e := range s.Events(line + "\r\n") {
+			connMsg.AppendStyledText("Server response: "+e.Raw, StyleNotice)
+		}
+		for name, p := range s.Profiles {
+			for _, l := range profileLines(p) {
+				connMsg.AppendText(name + " " + l)
+			}
+		}
+
+		cells := captureCells(func() {
+			drawArea(s.Rooms)
+			drawArea(s.Chats)
+			s.Chats.ShowRoomMember = true
+			drawArea(s.Chats)
+			for _, peer := range s.Chats.Peers() {
+				s.Chats.SetCurrentPeer(peer.Peer)
+				drawArea(s.Chats)
+			}
+			drawArea(connMsg)
+			drawArea(inspector)
+		})
+		for pos, r := range cells {
+			if unicode.IsControl(r) || isBidiControl(r) {
+				t.Fatalf("Unsafe rune %U drawn at %v from %q", r, pos, line)
+			}
+			if pos[0] > 2*(maxRawLen+len(truncatedMark))+100 {
+				t.Fatalf("Too long line drawn at %v from %q", pos, line)
+			}
+		}
+	})
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchDateFormats are accepted by before: and after:.
+var searchDateFormats = []string{"2006-01-02T15:04", "2006-01-02"}
+
+// Query is a parsed search query.
+//
+//	deploy "build failed" sender:alice room:3 after:2015-09-01 before:2015-09-14
+type Query struct {
+	Words   []string
+	Phrases []string
+	// Sender is a member name. "*" and "?" are wildcards.
+	Sender string
+	// Room is a room ID. 0 means every room.
+	Room   int
+	After  time.Time
+	Before time.Time
+}
+
+// ParseQuery parse a search query.
+func ParseQuery(s string) (Query, error) {
+	var q Query
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ")
+		if s == "" {
+			break
+		}
+		if s[0] == '"' {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				return q, errors.New("phrase is not closed")
+			}
+			if phrase := strings.TrimSpace(s[1 : end+1]); phrase != "" {
+				q.Phrases = append(q.Phrases, strings.ToLower(phrase))
+			}
+			s = s[end+2:]
+			continue
+		}
+		token := s
+		if i := strings.Index(s, " "); i >= 0 {
+			token = s[:i]
+		}
+		s = s[len(token):]
+
+		key, value := "", token
+		if i := strings.Index(token, ":"); i > 0 {
+			key, value = token[:i], token[i+1:]
+		}
+		var err error
+		switch key {
+		case "sender":
+			q.Sender = value
+		case "room":
+			q.Room, err = strconv.Atoi(value)
+		case "after":
+			q.After, err = parseSearchDate(value)
+		case "before":
+			q.Before, err = parseSearchDate(value)
+		default:
+			q.Words = append(q.Words, splitTerms(token)...)
+		}
+		if err != nil {
+			return q, fmt.Errorf("invalid %s: %q", key, value)
+		}
+	}
+	if q.IsEmpty() {
+		return q, errors.New("empty query")
+	}
+	return q, nil
+}
+
+func parseSearchDate(s string) (time.Time, error) {
+	var err error
+	for _, layout := range searchDateFormats {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// IsEmpty return true when the query has no condition.
+func (q Query) IsEmpty() bool {
+	return len(q.Words) == 0 && len(q.Phrases) == 0 && q.Sender == "" &&
+		q.Room == 0 && q.After.IsZero() && q.Before.IsZero()
+}
+
+// Match return true when the entry satisfies every condition of the query.
+func (q Query) Match(e ChatEntry) bool {
+	if e.Kind != KindMessage {
+		return false
+	}
+	if q.Room != 0 && e.RoomID != q.Room {
+		return false
+	}
+	if q.Sender != "" && !wildcardRegexp(strings.ToLower(q.Sender)).MatchString(strings.ToLower(e.Sender)) {
+		return false
+	}
+	if !q.After.IsZero() && e.Time.Before(q.After) {
+		return false
+	}
+	if !q.Before.IsZero() && !e.Time.Before(q.Before) {
+		return false
+	}
+	body := strings.ToLower(e.Body)
+	terms := make(map[string]bool)
+	for _, term := range splitTerms(body) {
+		terms[term] = true
+	}
+	for _, w := range q.Words {
+		if !terms[w] {
+			return false
+		}
+	}
+	for _, p := range q.Phrases {
+		if !strings.Contains(body, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// highlight return a regular expression matching the words and phrases.
+func (q Query) highlight() *regexp.Regexp {
+	var parts []string
+	for _, w := range append(append([]string{}, q.Phrases...), q.Words...) {
+		parts = append(parts, regexp.QuoteMeta(w))
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	return regexp.MustCompile("(?i)" + strings.Join(parts, "|"))
+}
+
+// SearchResult is an entry matched by a query and its line in the room history.
+type SearchResult struct {
+	Entry ChatEntry
+	Line  int
+}
+
+// Search return entries matching the query from the newest.
+// limit 0 means no limit.
+func (h *History) Search(q Query, limit int) ([]SearchResult, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var candidates []posting
+	if len(q.Words) > 0 {
+		candidates = h.lookup(q.Words)
+	} else {
+		for _, room := range h.Rooms() {
+			for line := 0; line < h.lines[room]; line++ {
+				candidates = append(candidates, posting{room, line})
+			}
+		}
+	}
+
+	var results []SearchResult
+	for _, p := range candidates {
+		if q.Room != 0 && p.Room != q.Room {
+			continue
+		}
+		entries, err := h.entries(p.Room)
+		if err != nil {
+			return nil, err
+		}
+		if p.Line < len(entries) && q.Match(entries[p.Line]) {
+			results = append(results, SearchResult{entries[p.Line], p.Line})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Entry.Time.After(results[j].Entry.Time)
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// lookup return places which have every word.
+func (h *History) lookup(words []string) []posting {
+	count := make(map[posting]int)
+	var order []posting
+	for i, w := range words {
+		for _, p := range h.index[w] {
+			if count[p] == i {
+				count[p]++
+				if i == 0 {
+					order = append(order, p)
+				}
+			}
+		}
+	}
+	var found []posting
+	for _, p := range order {
+		if count[p] == len(words) {
+			found = append(found, p)
+		}
+	}
+	return found
+}
+
+// SearchBox show search results.
+type SearchBox struct {
+	maxLine int
+	query   Query
+	results []SearchResult
+}
+
+// NewSearchBox create SearchBox instance.
+func NewSearchBox(maxLine int) *SearchBox {
+	return &SearchBox{maxLine: maxLine}
+}
+
+// SetResults replace results shown in the box.
+func (sb *SearchBox) SetResults(q Query, results []SearchResult) {
+	sb.query = q
+	sb.results = results
+}
+
+// Result return nth result counted from 1.
+func (sb *SearchBox) Result(n int) (SearchResult, bool) {
+	if n < 1 || n > len(sb.results) {
+		return SearchResult{}, false
+	}
+	return sb.results[n-1], true
+}
+
+// GetMaxLine return the number of lines of the box.
+func (sb *SearchBox) GetMaxLine() int {
+	return sb.maxLine
+}
+
+// GetText return nth result line.
+func (sb *SearchBox) GetText(n int) string {
+	var text string
+	for _, span := range sb.GetStyledText(n) {
+		text += span.Text
+	}
+	return text
+}
+
+// GetStyledText return nth result line with matched words highlighted.
+func (sb *SearchBox) GetStyledText(n int) []Span {
+	if n == 0 {
+		return []Span{{fmt.Sprintf("%d results. Type a number to jump.", len(sb.results)), StyleNotice}}
+	}
+	r, ok := sb.Result(n)
+	if !ok {
+		return []Span{{"", StyleDefault}}
+	}
+	spans := []Span{
+		{fmt.Sprintf("#%d ", n), StyleDefault},
+		{r.Entry.Time.Format("2006-01-02 15:04") + " ", StyleTimestamp},
+		{fmt.Sprintf("room %d ", r.Entry.RoomID), StyleDefault},
+		{r.Entry.Sender, StyleNick},
+		{" ", StyleDefault},
+	}
+	return append(spans, highlightSpans(r.Entry.Body, sb.query.highlight())...)
+}
+
+// highlightSpans split text into spans and mark parts matching re.
+func highlightSpans(text string, re *regexp.Regexp) []Span {
+	if re == nil {
+		return []Span{{text, StyleDefault}}
+	}
+	var spans []Span
+	last := 0
+	for _, m := range re.FindAllStringIndex(text, -1) {
+		if m[0] > last {
+			spans = append(spans, Span{text[last:m[0]], StyleDefault})
+		}
+		spans = append(spans, Span{text[m[0]:m[1]], StyleMention})
+		last = m[1]
+	}
+	if last < len(text) {
+		spans = append(spans, Span{text[last:], StyleDefault})
+	}
+	return spans
+}
+
+// ScrollBox show a room history from History around a line.
+// Like TextBox, the newest visible line comes first.
+type ScrollBox struct {
+	maxLine  int
+	entries  []ChatEntry
+	offset   int
+	selected int
+	// TimeFormat is a layout of timestamps.
+	TimeFormat string
+}
+
+// NewScrollBox create ScrollBox instance.
+func NewScrollBox(maxLine int) *ScrollBox {
+	return &ScrollBox{maxLine: maxLine, selected: NotExist, TimeFormat: "2006-01-02 15:04"}
+}
+
+// Open show entries and put the line at the middle of the box.
+func (s *ScrollBox) Open(entries []ChatEntry, line int) {
+	s.entries = entries
+	s.selected = line
+	s.offset = 0
+	s.Scroll(len(entries) - 1 - line - s.maxLine/2)
+}
+
+// Scroll move the view to older lines by n. Negative n moves to newer lines.
+func (s *ScrollBox) Scroll(n int) {
+	s.offset += n
+	if max := len(s.entries) - s.maxLine; s.offset > max {
+		s.offset = max
+	}
+	if s.offset < 0 {
+		s.offset = 0
+	}
+}
+
+// GetMaxLine return the number of lines of the box.
+func (s *ScrollBox) GetMaxLine() int {
+	return s.maxLine
+}
+
+// GetText return nth visible line.
+func (s *ScrollBox) GetText(n int) string {
+	var text string
+	for _, span := range s.GetStyledText(n) {
+		text += span.Text
+	}
+	return text
+}
+
+// GetStyledText return nth visible line. The selected line is highlighted.
+func (s *ScrollBox) GetStyledText(n int) []Span {
+	i := len(s.entries) - 1 - s.offset - n
+	if i < 0 {
+		return []Span{{"", StyleDefault}}
+	}
+	if i == s.selected {
+		var text string
+		for _, span := range s.entries[i].Spans("", s.TimeFormat) {
+			text += span.Text
+		}
+		return []Span{{text, StyleSelected}}
+	}
+	return s.entries[i].Spans("", s.TimeFormat)
+}
+
+// RegisterSearchCommands add /search. show is called to switch to the search view.
+func RegisterSearchCommands(cs CommandSet, h *History, box *SearchBox, show func()) {
+	usage := `/search <words> ["phrase"] [sender:<name>] [room:<id>] [after:<date>] [before:<date>]`
+	cs.Register("search", usage, func(args []string) ([]string, error) {
+		if h == nil {
+			return nil, errors.New("history is disabled")
+		}
+		if len(args) == 0 {
+			return nil, errUsage(usage)
+		}
+		q, err := ParseQuery(strings.Join(args, " "))
+		if err != nil {
+			return nil, err
+		}
+		results, err := h.Search(q, box.GetMaxLine()-1)
+		if err != nil {
+			return nil, err
+		}
+		box.SetResults(q, results)
+		show()
+		return []string{fmt.Sprintf("%d results", len(results))}, nil
+	})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	q, err := ParseQuery(`Deploy "Build Failed" sender:ali* room:3 after:2015-09-01 before:2015-09-14T12:00`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Words) != 1 || q.Words[0] != "deploy" {
+		t.Errorf("Unexpected words: %v", q.Words)
+	}
+	if len(q.Phrases) != 1 || q.Phrases[0] != "build failed" {
+		t.Errorf("Unexpected phrases: %v", q.Phrases)
+	}
+	if q.Sender != "ali*" || q.Room != 3 {
+		t.Errorf("Unexpected sender or room: %v %v", q.Sender, q.Room)
+	}
+	if q.After.Day() != 1 || q.Before.Hour() != 12 {
+		t.Errorf("Unexpected dates: %v %v", q.After, q.Before)
+	}
+
+	for _, s := range []string{"", `"open`, "room:x", "after:yesterday"} {
+		if _, err := ParseQuery(s); err == nil {
+			t.Errorf("%q should be an error", s)
+		}
+	}
+}
+
+func TestHistorySearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igoclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h, err := OpenHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2015, 9, 10, 12, 0, 0, 0, time.Local)
+	entries := []ChatEntry{
+		{Time: base, RoomID: 1, Sender: "alice", Body: "deploy is done", Kind: KindMessage},
+		{Time: base.Add(time.Hour), RoomID: 1, Sender: "bob", Body: "The build failed again", Kind: KindMessage},
+		{Time: base.Add(2 * time.Hour), RoomID: 1, Sender: "bob", Kind: KindEnter},
+		{Time: base.Add(24 * time.Hour), RoomID: 2, Sender: "alice", Body: "deploy: build failed!", Kind: KindMessage},
+		{Time: base.Add(48 * time.Hour), RoomID: 2, Sender: "carol", Body: "Deploy tomorrow", Kind: KindMessage},
+	}
+	for _, e := range entries {
+		if err := h.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.Close()
+
+	// Reopen to read the index from disk.
+	h, err = OpenHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{"deploy", []string{"Deploy tomorrow", "deploy: build failed!", "deploy is done"}},
+		{`deploy "build failed"`, []string{"deploy: build failed!"}},
+		{"sender:BOB", []string{"The build failed again"}},
+		{"deploy room:1", []string{"deploy is done"}},
+		{"deploy after:2015-09-11 before:2015-09-12", []string{"deploy: build failed!"}},
+		{"nothing", nil},
+	}
+	for _, c := range cases {
+		q, err := ParseQuery(c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := h.Search(q, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != len(c.expected) {
+			t.Errorf("%s: unexpected results %v", c.query, results)
+			continue
+		}
+		for i, r := range results {
+			if r.Entry.Body != c.expected[i] {
+				t.Errorf("%s: unexpected result %d.\nexpected: %v\nresult: %v", c.query, i, c.expected[i], r.Entry.Body)
+			}
+		}
+	}
+
+	q, _ := ParseQuery("failed")
+	results, _ := h.Search(q, 1)
+	if len(results) != 1 || results[0].Line != 0 || results[0].Entry.RoomID != 2 {
+		t.Errorf("Unexpected limited result: %v", results)
+	}
+}
+
+func TestHistoryRebuildIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igoclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h, _ := OpenHistory(dir)
+	h.Append(NewMessageEntry(5, "alice", "hello world"))
+	h.Close()
+	os.Remove(h.idx.Name())
+
+	h, err = OpenHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	q, _ := ParseQuery("world")
+	if results, _ := h.Search(q, 0); len(results) != 1 {
+		t.Errorf("Index should be rebuilt: %v", results)
+	}
+}
+
+func TestSearchBoxHighlight(t *testing.T) {
+	q, _ := ParseQuery("build")
+	box := NewSearchBox(5)
+	box.SetResults(q, []SearchResult{{NewMessageEntry(1, "bob", "Build it, build"), 0}})
+	spans := box.GetStyledText(1)
+	var highlighted []string
+	for _, s := range spans {
+		if s.Style == StyleMention {
+			highlighted = append(highlighted, s.Text)
+		}
+	}
+	if len(highlighted) != 2 || highlighted[0] != "Build" {
+		t.Errorf("Unexpected highlight: %v", spans)
+	}
+}
+
+func TestScrollBoxOpen(t *testing.T) {
+	var entries []ChatEntry
+	for i := 0; i < 100; i++ {
+		entries = append(entries, ChatEntry{RoomID: i, Kind: KindMessage})
+	}
+	s := NewScrollBox(10)
+	s.Open(entries, 40)
+	if s.GetStyledText(5)[0].Style != StyleSelected {
+		t.Errorf("Selected line should be in the middle")
+	}
+	s.Scroll(1000)
+	if s.offset != len(entries)-10 {
+		t.Errorf("Scroll should stop at the oldest line: %d", s.offset)
+	}
+	s.Scroll(-1000)
+	if s.offset != 0 {
+		t.Errorf("Scroll should stop at the newest line: %d", s.offset)
+	}
+}
+
+func TestHistoryDirect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igoclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h, err := OpenHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	e := ChatEntry{Time: time.Now(), Peer: "../bob", Sender: "../bob", Body: "secret", Kind: KindMessage}
+	if err := h.Append(e); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := h.DirectEntries("../bob"); err != nil || len(entries) != 1 || entries[0].Body != "secret" {
+		t.Errorf("Unexpected entries: %+v %v", entries, err)
+	}
+	if results, _ := h.Search(Query{Words: []string{"secret"}}, 10); len(results) != 0 {
+		t.Errorf("Direct messages should not be searched: %+v", results)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "direct-*")); len(files) != 1 {
+		t.Errorf("Unexpected files: %q", files)
+	}
+}
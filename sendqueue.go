@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultSendRate is lines per second sent after a burst.
+	DefaultSendRate = 2
+	// DefaultSendBurst is lines sent at once without waiting.
+	DefaultSendBurst = 5
+	// DefaultWriteTimeout is seconds to wait for a stalled server.
+	DefaultWriteTimeout = 10
+	// sendQueueSize is lines waiting to be sent. More lines are rejected.
+	sendQueueSize = 256
+)
+
+// errQueueFull is returned by Send when the server can't keep up with us.
+var errQueueFull = errors.New("too many lines are waiting to be sent")
+
+// SendSettings limit lines sent to the server so that a paste doesn't flood it.
+type SendSettings struct {
+	// Rate is lines per second. 0 means DefaultSendRate.
+	Rate float64 `json:"rate"`
+	// Burst is lines sent at once before Rate applies. 0 means DefaultSendBurst.
+	Burst int `json:"burst"`
+	// WriteTimeout is seconds to wait for a write. 0 means DefaultWriteTimeout.
+	WriteTimeout int `json:"write_timeout"`
+}
+
+// withDefaults fill zero values with defaults.
+func (s SendSettings) withDefaults() SendSettings {
+	if s.Rate <= 0 {
+		s.Rate = DefaultSendRate
+	}
+	if s.Burst <= 0 {
+		s.Burst = DefaultSendBurst
+	}
+	if s.WriteTimeout <= 0 {
+		s.WriteTimeout = DefaultWriteTimeout
+	}
+	return s
+}
+
+// TokenBucket allow burst lines at once and rate lines per second after that.
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket create a full TokenBucket.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// Take take a token at now and return how long to wait before using it.
+func (b *TokenBucket) Take(now time.Time) time.Duration {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// StartQueue make Send queue lines and write them in a goroutine limited by settings.
+// Failed writes are reported by Errors.
+func (c *ConnClient) StartQueue(settings SendSettings, done <-chan struct{}) {
+	settings = settings.withDefaults()
+	bucket := NewTokenBucket(settings.Rate, settings.Burst)
+	timeout := time.Duration(settings.WriteTimeout) * time.Second
+	c.queue = make(chan string, sendQueueSize)
+	c.errs = make(chan error, 8)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case msg := <-c.queue:
+				if wait := bucket.Take(time.Now()); wait > 0 {
+					select {
+					case <-time.After(wait):
+					case <-done:
+						return
+					}
+				}
+				c.conn.SetWriteDeadline(time.Now().Add(timeout))
+				if err := c.write(msg); err != nil {
+					select {
+					case c.errs <- fmt.Errorf("cannot send %q: %s", msg, err.Error()):
+					default:
+					}
+				}
+				c.mu.Lock()
+				c.queued--
+				c.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// Errors return errors of queued writes. It is nil before StartQueue.
+func (c *ConnClient) Errors() <-chan error {
+	return c.errs
+}
+
+// Flush wait until queued lines are written or the timeout.
+func (c *ConnClient) Flush(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		queued := c.queued
+		c.mu.Unlock()
+		if queued == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// enqueue add the line to the queue without blocking.
+func (c *ConnClient) enqueue(msg string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case c.queue <- msg:
+		c.queued++
+		return nil
+	default:
+		return errQueueFull
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failConn fail every write like a stalled server.
+type failConn struct {
+	ConnMock
+}
+
+func (c failConn) Write(b []byte) (int, error) {
+	return 0, errors.New("i/o timeout")
+}
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(2, 3)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if wait := b.Take(now); wait != 0 {
+			t.Errorf("Burst line %d should not wait: %v", i, wait)
+		}
+	}
+	if wait := b.Take(now); wait != 500*time.Millisecond {
+		t.Errorf("Unexpected wait: %v", wait)
+	}
+	// Tokens come back by the rate but not more than the burst.
+	if wait := b.Take(now.Add(time.Hour)); wait != 0 {
+		t.Errorf("Unexpected wait after a while: %v", wait)
+	}
+	if b.tokens != 2 {
+		t.Errorf("Unexpected tokens: %v", b.tokens)
+	}
+}
+
+func TestSendQueue(t *testing.T) {
+	s, written := newTestSession(t)
+	done := make(chan struct{})
+	defer close(done)
+	s.Conn.StartQueue(SendSettings{Rate: 1000, Burst: 2}, done)
+	for _, line := range []string{"a", "b", "c"} {
+		if err := s.Conn.Send(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Conn.Flush(time.Second)
+	if written.String() != "a\r\nb\r\nc\r\n" {
+		t.Errorf("Unexpected lines: %q", written.String())
+	}
+
+	s.Conn.conn = failConn{}
+	s.Conn.Send("d")
+	select {
+	case err := <-s.Conn.Errors():
+		if !strings.Contains(err.Error(), `"d"`) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Failed write should be reported")
+	}
+}
+
+func TestSessionPendingShout(t *testing.T) {
+	s, _ := newTestSession(t)
+	s.Events("ROOM_ADDED 3 carol 10 lobby\r\nOK OPEN_ROOM 3\r\n")
+	s.Shout("first")
+	s.Shout("second")
+	if text := s.Chats.GetText(0); text != "me second (sending)" {
+		t.Errorf("Shout should be pending: %q", text)
+	}
+
+	s.Events("MESSAGE 3 me second\r\nOK SHOUT 3\r\n")
+	for n, expected := range []string{"me second", "me first"} {
+		if text := s.Chats.GetText(n); text != expected {
+			t.Errorf("Line %d should be sent: %q", n, text)
+		}
+	}
+	// An echo after OK is not shown twice.
+	s.Events("MESSAGE 3 me first\r\nMESSAGE 3 me first\r\n")
+	if text := s.Chats.GetText(0); text != "me first" || s.Chats.GetText(1) != "me second" {
+		t.Errorf("Only an unknown echo should be appended: %q %q", text, s.Chats.GetText(1))
+	}
+}
+
+func TestSessionPendingHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igoclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, _ := newTestSession(t)
+	if s.Chats.History, err = OpenHistory(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Chats.History.Close()
+	s.Events("ROOM_ADDED 3 carol 10 lobby\r\nOK OPEN_ROOM 3\r\n")
+	s.Shout("first")
+	s.Shout("second")
+	s.Shout("lost")
+	bodies := func() []string {
+		entries, err := s.Chats.History.Entries(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var bodies []string
+		for _, e := range entries {
+			bodies = append(bodies, e.Body)
+		}
+		return bodies
+	}
+	if b := bodies(); len(b) != 0 {
+		t.Errorf("Pending messages should not be in history: %q", b)
+	}
+	s.Events("MESSAGE 3 me second\r\nOK SHOUT 3\r\n")
+	if b := bodies(); !reflect.DeepEqual(b, []string{"second", "first"}) {
+		t.Errorf("Only confirmed messages should be in history: %q", b)
+	}
+}
+
+func TestSessionPendingSanitizedEcho(t *testing.T) {
+	s, _ := newTestSession(t)
+	s.Events("ROOM_ADDED 3 carol 10 lobby\r\nOK OPEN_ROOM 3\r\n")
+	for _, text := range []string{"tab\there", strings.Repeat("long ", 300)} {
+		s.Shout(text)
+		s.Events("MESSAGE 3 me " + text + "\r\n")
+		entries := s.Chats.RoomEntries(3)
+		if last := entries[len(entries)-1]; last.Pending || len(entries) != 1 {
+			t.Errorf("Echo of %.10q should resolve the pending message: %d entries, pending %v", text, len(entries), last.Pending)
+		}
+		s.Chats.ChatLogs[0].Logs = NewEntryBox(30)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ServerRoomList show rooms of every session grouped by server.
+type ServerRoomList struct {
+	sessions []*Session
+	focused  *int
+}
+
+// NewServerRoomList create ServerRoomList. focused is the index of the focused session.
+func NewServerRoomList(sessions []*Session, focused *int) *ServerRoomList {
+	return &ServerRoomList{sessions, focused}
+}
+
+// lines return every line of the list.
+func (l *ServerRoomList) lines() [][]Span {
+	var lines [][]Span
+	for i, s := range l.sessions {
+		header := fmt.Sprintf("== %s %s@%s (%s) ==", s.Server.Label(), s.Server.User, s.Server.Host, s.Conn.state)
+		style := StyleNotice
+		if i == *l.focused {
+			header = "* " + header
+			style = StyleSelected
+		}
+		lines = append(lines, []Span{{header, style}})
+		for n, room := range *s.Rooms.rooms {
+			if room.ID != 0 {
+				lines = append(lines, s.Rooms.GetStyledText(n))
+			}
+		}
+	}
+	return lines
+}
+
+// GetMaxLine return the number of lines to show all rooms.
+func (l *ServerRoomList) GetMaxLine() int {
+	max := 0
+	for _, s := range l.sessions {
+		max += 1 + s.Rooms.GetMaxLine()
+	}
+	return max
+}
+
+// GetStyledText return nth line.
+func (l *ServerRoomList) GetStyledText(n int) []Span {
+	lines := l.lines()
+	if n >= len(lines) {
+		return []Span{{"", StyleDefault}}
+	}
+	return lines[n]
+}
+
+// GetText return nth line.
+func (l *ServerRoomList) GetText(n int) string {
+	var text string
+	for _, span := range l.GetStyledText(n) {
+		text += span.Text
+	}
+	return text
+}
+
+// nextConnected return the index of the next connected session after from.
+// It return from when no other session is connected.
+func nextConnected(sessions []*Session, from int) int {
+	for n := 1; n < len(sessions); n++ {
+		if i := (from + n) % len(sessions); sessions[i].Conn.connected() {
+			return i
+		}
+	}
+	return from
+}
+
+// RegisterServerCommands add /server which list servers or focus one of them.
+// A server which is not connected can't be focused because nothing can be sent to it.
+func RegisterServerCommands(cs CommandSet, sessions []*Session, focus func(i int)) {
+	usage := "/server [<name>|<#>]"
+	cs.Register("server", usage, func(args []string) ([]string, error) {
+		if len(args) == 0 {
+			var lines []string
+			for i, s := range sessions {
+				lines = append(lines, fmt.Sprintf("#%d %s %s@%s:%s %s", i+1, s.Server.Label(), s.Server.User, s.Server.Host, s.Server.Port, s.Conn.state))
+			}
+			return lines, nil
+		}
+		if len(args) != 1 {
+			return nil, errUsage(usage)
+		}
+		for i, s := range sessions {
+			if n, err := strconv.Atoi(strings.TrimPrefix(args[0], "#")); err == nil && n == i+1 || s.Server.Label() == args[0] {
+				if !s.Conn.connected() {
+					return nil, fmt.Errorf("%s is not connected", s.Server.Label())
+				}
+				focus(i)
+				return []string{"Focused " + s.Server.Label()}, nil
+			}
+		}
+		return nil, fmt.Errorf("no server %q", args[0])
+	})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestSessions(t *testing.T) []*Session {
+	settings := DefaultSettings()
+	settings.History = false
+	var sessions []*Session
+	for _, server := range []ServerSettings{{Name: "home", Host: "a", User: "me"}, {Host: "b", User: "other"}} {
+		s := NewSession(&settings, server)
+		s.Conn.conn = ConnMock{}
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+func TestSettingsServers(t *testing.T) {
+	d := defaultServer()
+	if servers, err := DefaultSettings().servers(); err != nil || len(servers) != 1 || servers[0] != d {
+		t.Errorf("Unexpected default servers: %+v %v", servers, err)
+	}
+
+	settings := DefaultSettings()
+	settings.Servers = []ServerSettings{{Name: "work", Host: "example.com", User: "alice"}, {Port: "20000"}}
+	servers, err := settings.servers()
+	if err != nil || len(servers) != 2 {
+		t.Fatalf("Unexpected servers: %+v %v", servers, err)
+	}
+	if s := servers[0]; s.Host != "example.com" || s.User != "alice" || s.Port != d.Port || s.ID != d.ID || s.Label() != "work" {
+		t.Errorf("Unexpected first server: %+v", s)
+	}
+	if s := servers[1]; s.Host != d.Host || s.Port != "20000" || s.Label() != d.Host {
+		t.Errorf("Unexpected second server: %+v", s)
+	}
+	if s, err := selectServer(servers, "work"); err != nil || s.Host != "example.com" {
+		t.Errorf("Unexpected selected server: %+v %v", s, err)
+	}
+	if s, err := selectServer(servers, ""); err != nil || s.Name != "work" {
+		t.Errorf("The first server should be the default: %+v %v", s, err)
+	}
+	if _, err := selectServer(servers, "home"); err == nil {
+		t.Errorf("Unknown server should fail")
+	}
+	if dir := servers[0].historyDir("h"); dir != filepath.Join("h", "work") {
+		t.Errorf("Unexpected history dir: %s", dir)
+	}
+	if dir := servers[1].historyDir("h"); dir != "h" {
+		t.Errorf("Unnamed server should use the history dir: %s", dir)
+	}
+
+	// History directories must not be shared.
+	for _, bad := range [][]ServerSettings{
+		{{Host: "a"}, {Host: "b"}},
+		{{Name: "work"}, {Name: "work", Host: "b"}},
+		{{Name: "../work"}},
+		{{Name: ".."}},
+	} {
+		settings.Servers = bad
+		if _, err := settings.servers(); err == nil {
+			t.Errorf("Servers should be rejected: %+v", bad)
+		}
+	}
+}
+
+func TestServerRoomList(t *testing.T) {
+	sessions := newTestSessions(t)
+	sessions[0].Events("ROOM_ADDED 3 carol 10 lobby\r\n")
+	sessions[1].Events("ROOM_ADDED 5 dave 10 games\r\n")
+	focused := 1
+	l := NewServerRoomList(sessions, &focused)
+
+	var lines []string
+	for n := 0; n < l.GetMaxLine(); n++ {
+		if text := l.GetText(n); text != "" {
+			lines = append(lines, text)
+		}
+	}
+	if len(lines) != 4 {
+		t.Fatalf("Unexpected lines: %q", lines)
+	}
+	if !strings.HasPrefix(lines[0], "== home me@a") || !strings.Contains(lines[1], "lobby") {
+		t.Errorf("Unexpected first server: %q", lines[:2])
+	}
+	if !strings.HasPrefix(lines[2], "* == b other@b") || !strings.Contains(lines[3], "games") {
+		t.Errorf("Unexpected second server: %q", lines[2:])
+	}
+	if style := l.GetStyledText(2)[0].Style; style != StyleSelected {
+		t.Errorf("Focused server should be selected: %v", style)
+	}
+}
+
+func TestServerCommand(t *testing.T) {
+	sessions := newTestSessions(t)
+	focused := 0
+	cs := NewCommandSet()
+	RegisterServerCommands(cs, sessions, func(i int) { focused = i })
+
+	lines, err := cs.Execute("/server")
+	if err != nil || len(lines) != 2 || !strings.HasPrefix(lines[1], "#2 b other@b") {
+		t.Errorf("Unexpected list: %q %v", lines, err)
+	}
+	if _, err := cs.Execute("/server #2"); err != nil || focused != 1 {
+		t.Errorf("Server #2 should be focused: %d %v", focused, err)
+	}
+	if _, err := cs.Execute("/server home"); err != nil || focused != 0 {
+		t.Errorf("Server home should be focused: %d %v", focused, err)
+	}
+	if _, err := cs.Execute("/server nowhere"); err == nil {
+		t.Error("Unknown server should fail")
+	}
+}
+
+func TestServerFailedSession(t *testing.T) {
+	sessions := newTestSessions(t)
+	failed := sessions[1]
+	failed.Conn.conn = nil
+	failed.Conn.setState(StateDisconnected)
+	focused := 0
+	cs := NewCommandSet()
+	RegisterServerCommands(cs, sessions, func(i int) { focused = i })
+	if _, err := cs.Execute("/server #2"); err == nil || focused != 0 {
+		t.Errorf("Failed server should not be focused: %d %v", focused, err)
+	}
+	if next := nextConnected(sessions, 0); next != 0 {
+		t.Errorf("Failed server should be skipped: %d", next)
+	}
+
+	// A failed session is reached by a plugin or the API even if it is not focused.
+	commands := NewCommandSet()
+	failed.RegisterCommands(commands)
+	failed.Chats.SetCurrentRoom(1)
+	for _, line := range []string{"/open 1", "/close 1", "/say hello", "/raw PING -1"} {
+		if _, err := commands.Execute(line); err != ErrNotConnected {
+			t.Errorf("%s should fail without connection: %v", line, err)
+		}
+	}
+	if err := failed.ShoutLines("hello"); err != ErrNotConnected {
+		t.Errorf("Chat text should fail without connection: %v", err)
+	}
+	if err := failed.Conn.write("PING -1"); err != ErrNotConnected {
+		t.Errorf("Write should fail without connection: %v", err)
+	}
+}
+
+func TestServerSharedFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igofilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("XDG_CONFIG_HOME", dir)
+
+	sessions := newTestSessions(t)
+	if sessions[0].Filter != sessions[1].Filter {
+		t.Fatal("Sessions should share the filter")
+	}
+	for i, name := range []string{"bob", "carol"} {
+		cs := NewCommandSet()
+		sessions[i].RegisterCommands(cs)
+		if _, err := cs.Execute("/ignore " + name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved, err := LoadSettings(settingsPath())
+	if err != nil || strings.Join(saved.Filter.Ignore, ",") != "bob,carol" {
+		t.Errorf("Both servers' changes should be saved: %v %v", saved.Filter.Ignore, err)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Neetless/iGoClient/igo"
+)
+
+// connTimeout is a read and write deadline extended by PING.
+const connTimeout = 400 * time.Second
+
+// outboxSize is texts remembered per room to find their echoes.
+const outboxSize = 100
+
+// Session has a connection and the room and chat state updated by its events.
+// It is shared by the termbox frontend and the line mode frontend.
+type Session struct {
+	Conn     *ConnClient
+	Rooms    *RoomBox
+	Chats    *ChatBox
+	Filter   *Filter
+	Settings *Settings
+	// Server is the server and the identity of the session.
+	Server ServerSettings
+	// Inbound is called before an event is applied. false hides a message.
+	Inbound func(e *Event) bool
+	// Profiles are known profiles of users by name.
+	Profiles map[string]igo.Profile
+
+	splitter igo.LineSplitter
+	// asked is users whose profiles were requested.
+	asked map[string]bool
+	// outbox is texts we shouted by room. Their echoes are not shown again.
+	outbox map[int][]string
+}
+
+// Event is a server event seen by a frontend.
+type Event struct {
+	igo.Event
+	// Hidden is set when Filter hide the event.
+	Hidden bool `json:"-"`
+}
+
+// NewSession create state for the server from settings.
+// Problems in settings are logged and the related feature is disabled.
+func NewSession(settings *Settings, server ServerSettings) *Session {
+	var err error
+	user := server.User
+	s := &Session{Settings: settings, Server: server, Profiles: make(map[string]igo.Profile), asked: make(map[string]bool),
+		outbox: make(map[int][]string)}
+	s.Profiles[user] = server.profile()
+	s.Conn = &ConnClient{mode: DirectMode, state: StateConnecting}
+	if s.Conn.Codec, err = NewCodec(server.Encoding); err != nil {
+		sessionLog.Warn("Cannot set encoding", "server", server.Label(), "error", err)
+	}
+	s.Rooms = NewRoomBox(20)
+	s.Chats = NewChatBox(20, s.Rooms.rooms)
+	s.Chats.Self = user
+	s.Chats.Profiles = s.Profiles
+	s.Chats.TimeFormat = settings.TimestampFormat
+	// Only the TUI owns the terminal. See runTUI.
+	if s.Chats.Highlighter, err = NewHighlighter(user, settings.Highlight, nil); err != nil {
+		sessionLog.Warn("Cannot set highlight rules", "error", err)
+	}
+	s.Rooms.TrackSelection(&s.Chats.CurrentRoomID)
+	if s.Filter, err = settings.sharedFilter(); err != nil {
+		sessionLog.Warn("Cannot set filter", "error", err)
+	}
+	s.Chats.Filter = s.Filter
+	if settings.History {
+		if s.Chats.History, err = OpenHistory(server.historyDir(settings.historyDir())); err != nil {
+			sessionLog.Error("Cannot open history", "error", err)
+		}
+	}
+	return s
+}
+
+// Connect dial the server and extend deadlines.
+func (s *Session) Connect() error {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", s.Server.Host+":"+s.Server.Port)
+	if err != nil {
+		return err
+	}
+
+	// Following are testing code for loacl environment
+	// clientAddr := new(net.TCPAddr)
+	// clientAddr.IP = net.ParseIP(ClientIP)
+	// clientAddr.Port, _ = strconv.Atoi(ClientPort)
+
+	sessionLog.Info("Start TCP dial", "server", s.Server.Label())
+	//conn, err := net.DialTCP("tcp", clientAddr, tcpAddr)
+	conn, err := net.DialTCP("tcp", nil, tcpAddr)
+	if err != nil {
+		return err
+	}
+
+	sessionLog.Debug("Set TCP conn deadline", "server", s.Server.Label())
+	conn.SetReadDeadline(time.Now().Add(connTimeout))
+	conn.SetWriteDeadline(time.Now().Add(connTimeout))
+	s.Conn.conn = conn
+	s.Conn.setState(StateConnected)
+	return nil
+}
+
+// Login send the profile of the server settings.
+func (s *Session) Login() {
+	for _, cmd := range igo.LoginCommands(s.Server.profile()) {
+		s.Conn.sendInternal(cmd)
+	}
+}
+
+// Close close the connection and the history.
+func (s *Session) Close() {
+	if s.Conn.conn != nil {
+		s.Conn.conn.Close()
+	}
+	if s.Chats.History != nil {
+		s.Chats.History.Close()
+	}
+}
+
+// Events decode a chunk from ConnClient.Receive and apply them to the state.
+// Strings of events are sanitized before they reach the state.
+// Events hidden by Filter are marked as Hidden.
+func (s *Session) Events(chunk string) []Event {
+	var events []Event
+	for _, line := range s.splitter.Split(chunk) {
+		if s.Conn.Trace != nil {
+			s.Conn.Trace(line, true)
+		}
+		sessionLog.Debug("Protocol line", "server", s.Server.Label(), "dir", "in", "line", line)
+		e := Event{Event: sanitizeEvent(igo.ParseLine(line))}
+		if s.Inbound != nil && !s.Inbound(&e) && (e.Type == igo.EventMessage || e.Type == igo.EventDirect) {
+			// Other events are applied to keep rooms and members right.
+			e.Hidden = true
+		} else {
+			e.Hidden = !s.Apply(e)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// Disconnected apply EventQuit when the channel of ConnClient.Receive is closed
+// and return it to be handled like other events.
+func (s *Session) Disconnected() []Event {
+	e := Event{Event: igo.QuitEvent(nil)}
+	s.Apply(e)
+	return []Event{e}
+}
+
+// Apply update the state by the event and answer the server if needed.
+// It return false when the event is hidden by Filter.
+func (s *Session) Apply(e Event) bool {
+	switch e.Type {
+	case igo.EventQuit:
+		sessionLog.Info("Server closed the connection", "server", s.Server.Label())
+		s.Conn.setState(StateDisconnected)
+	case igo.EventMessage:
+		if e.Name == s.Chats.Self && s.takeOutbox(e.Room, e.Text) {
+			s.Chats.ResolvePending(e.Room, e.Text)
+			return true
+		}
+		entry := ChatEntry{Time: e.Time, RoomID: e.Room, Sender: e.Name, Body: e.Text, Kind: KindMessage}
+		switch s.Filter.Check(e.Room, e.Name, e.Text) {
+		case FilterHide:
+			return false
+		case FilterDim:
+			entry.Style = StyleDim
+		}
+		s.Chats.AppendEntry(entry)
+	case igo.EventDirect:
+		entry := ChatEntry{Time: e.Time, Peer: e.Name, Sender: e.Name, Body: e.Text, Kind: KindMessage}
+		switch s.Filter.Check(NotExist, e.Name, e.Text) {
+		case FilterHide:
+			return false
+		case FilterDim:
+			entry.Style = StyleDim
+		}
+		s.Chats.AppendEntry(entry)
+	case igo.EventOK:
+		switch e.Command {
+		case "PING":
+			s.Conn.PingAcked()
+			s.Conn.conn.SetReadDeadline(time.Now().Add(connTimeout))
+			s.Conn.conn.SetWriteDeadline(time.Now().Add(connTimeout))
+		case "OPEN_ROOM":
+			s.Chats.SetCurrentRoom(e.Room)
+			s.Rooms.EnterRoom(e.Room)
+		case "ADD_ROOM":
+			s.Chats.SetCurrentRoom(e.Room)
+		case "CLOSE_ROOM":
+			s.Rooms.QuitRoom(e.Room)
+		case "SHOUT":
+			s.Chats.ResolvePending(e.Room, "")
+		}
+	case igo.EventServerPing:
+		s.Conn.sendInternal("OK SVR_PING")
+	case igo.EventProfile:
+		s.Profiles[e.Name] = *e.Profile
+	case igo.EventRoomAdded:
+		ri := NewRoomInfo(e.Room, e.Name, e.Owner)
+		if e.Capacity > 0 && e.Capacity <= maxRoomCapacity {
+			ri.MaxMember = e.Capacity
+			ri.Members = make([]string, e.Capacity)
+		}
+		ri.Topic = e.Text
+		s.Rooms.AppendRoom(ri)
+	case igo.EventRoomRemoved:
+		s.Rooms.RemoveRoom(e.Room)
+	case igo.EventEnter:
+		r := s.Filter.Check(e.Room, e.Name, "")
+		if r == FilterHide {
+			return false
+		}
+		s.Rooms.OtherEnterRoom(e.Room, e.Name)
+		s.askProfile(e.Room, e.Name)
+		s.appendMemberEntry(e, KindEnter, r)
+	case igo.EventLeave:
+		s.Rooms.OtherLeaveRoom(e.Room, e.Name)
+		r := s.Filter.Check(e.Room, e.Name, "")
+		if r == FilterHide {
+			return false
+		}
+		s.appendMemberEntry(e, KindLeave, r)
+	case igo.EventUsers:
+		for _, user := range e.Users {
+			if s.Filter.Check(e.Room, user, "") != FilterHide {
+				s.Rooms.OtherEnterRoom(e.Room, user)
+				s.askProfile(e.Room, user)
+			}
+		}
+	}
+	return true
+}
+
+// appendMemberEntry show ENTER and LEAVE in the log of a room we entered.
+func (s *Session) appendMemberEntry(e Event, kind EntryKind, r FilterResult) {
+	if room, ok := s.Rooms.Room(e.Room); !ok || !room.Entered {
+		return
+	}
+	entry := ChatEntry{Time: e.Time, RoomID: e.Room, Sender: e.Name, Kind: kind}
+	if r == FilterDim {
+		entry.Style = StyleDim
+	}
+	s.Chats.AppendEntry(entry)
+}
+
+// Shout send text to the current room or the current peer.
+func (s *Session) Shout(text string) error {
+	if s.Chats.CurrentPeer != "" {
+		return s.Direct(s.Chats.CurrentPeer, text)
+	}
+	if s.Chats.CurrentRoomID == NotExist {
+		return errors.New("no room is selected. Use /room <id>")
+	}
+	return s.ShoutRoom(s.Chats.CurrentRoomID, text)
+}
+
+// ShoutRoom send text to the room and show it as pending until the server echoes it.
+// Text with CR or LF is rejected.
+func (s *Session) ShoutRoom(room int, text string) error {
+	return s.shoutRoom(room, text, true)
+}
+
+// shoutRoom is ShoutRoom which skip send hooks unless hooks is set.
+func (s *Session) shoutRoom(room int, text string, hooks bool) error {
+	cmd, err := igo.ShoutCommand(room, text)
+	if err != nil {
+		return err
+	}
+	line, err := s.Conn.send(cmd, hooks)
+	if err != nil {
+		return err
+	}
+	// A plugin may rewrite the text. Other commands are not shown.
+	prefix, _ := igo.ShoutCommand(room, "")
+	if !strings.HasPrefix(line, prefix) {
+		return nil
+	}
+	text = strings.TrimPrefix(line, prefix)
+	if len(s.outbox[room]) >= outboxSize {
+		s.outbox[room] = s.outbox[room][1:]
+	}
+	// The echo is sanitized and cut like other inbound text. See Events.
+	s.outbox[room] = append(s.outbox[room], SanitizeText(text, maxTextLen))
+	entry := NewMessageEntry(room, s.Chats.Self, text)
+	entry.Pending = true
+	s.Chats.AppendEntry(entry)
+	return nil
+}
+
+// ShoutLines send each line of text by Shout in order. Empty lines are skipped.
+// The send queue paces the lines.
+func (s *Session) ShoutLines(text string) error {
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if err := s.Shout(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// takeOutbox remove the text from the outbox and return true when it was there.
+func (s *Session) takeOutbox(room int, text string) bool {
+	for i, sent := range s.outbox[room] {
+		if sent == text {
+			s.outbox[room] = append(s.outbox[room][:i], s.outbox[room][i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Direct send text only to the peer and log it in the conversation with the peer.
+func (s *Session) Direct(peer, text string) error {
+	cmd, err := igo.DirectCommand(peer, text)
+	if err != nil {
+		return err
+	}
+	if err := s.Conn.Send(cmd); err != nil {
+		return err
+	}
+	s.Chats.AppendEntry(ChatEntry{Time: time.Now(), Peer: peer, Sender: s.Chats.Self, Body: text, Kind: KindMessage})
+	return nil
+}
+
+// RegisterCommands add commands which work in every frontend.
+func (s *Session) RegisterCommands(cs CommandSet) {
+	RegisterFilterCommands(cs, s.Filter, func(fs FilterSettings) error {
+		s.Settings.Filter = fs
+		return s.Settings.Save(settingsPath())
+	})
+	RegisterExportCommands(cs, s.Chats, s.Chats.History)
+
+	cs.Register("open", "/open <room>", func(args []string) ([]string, error) {
+		if len(args) != 1 {
+			return nil, errUsage("/open <room>")
+		}
+		id, err := igo.ParseRoom(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.Conn.Send(igo.OpenRoomCommand(id))
+	})
+	cs.Register("close", "/close <room>", func(args []string) ([]string, error) {
+		if len(args) != 1 {
+			return nil, errUsage("/close <room>")
+		}
+		id, err := igo.ParseRoom(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.Conn.Send(igo.CloseRoomCommand(id))
+	})
+	RegisterRoomCommands(cs, s, nil)
+	RegisterProfileCommands(cs, s)
+	cs.Register("room", "/room <room>", func(args []string) ([]string, error) {
+		if len(args) != 1 {
+			return nil, errUsage("/room <room>")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, errUsage("/room <room>")
+		}
+		s.Chats.SetCurrentRoom(id)
+		return []string{"Current room is " + args[0]}, nil
+	})
+	msgUsage := "/msg [<user> [<text>]]"
+	cs.Register("msg", msgUsage, func(args []string) ([]string, error) {
+		switch len(args) {
+		case 0:
+			var lines []string
+			for _, cl := range s.Chats.Peers() {
+				lines = append(lines, fmt.Sprintf("%s %d unread", cl.Peer, cl.Unread))
+			}
+			if len(lines) == 0 {
+				return []string{"No direct conversation"}, nil
+			}
+			return lines, nil
+		case 1:
+			s.Chats.SetCurrentPeer(args[0])
+			return []string{"Talking with " + args[0]}, nil
+		}
+		return nil, s.Direct(args[0], strings.Join(args[1:], " "))
+	})
+	cs.Register("say", "/say <text>", func(args []string) ([]string, error) {
+		return nil, s.Shout(strings.Join(args, " "))
+	})
+	cs.Register("raw", "/raw <protocol line>", func(args []string) ([]string, error) {
+		if len(args) == 0 {
+			return nil, errUsage("/raw <protocol line>")
+		}
+		return nil, s.Conn.Send(strings.Join(args, " "))
+	})
+}
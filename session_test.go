@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Neetless/iGoClient/igo"
+)
+
+// recordConn record written lines.
+type recordConn struct {
+	ConnMock
+	written *bytes.Buffer
+}
+
+func (c recordConn) Write(b []byte) (int, error) {
+	return c.written.Write(b)
+}
+
+func newTestSession(t *testing.T) (*Session, *bytes.Buffer) {
+	settings := DefaultSettings()
+	settings.History = false
+	settings.Filter = FilterSettings{Ignore: []string{"spam*"}}
+	s := NewSession(&settings, ServerSettings{User: "me"})
+	s.Chats.TimeFormat = ""
+	written := &bytes.Buffer{}
+	s.Conn.conn = recordConn{written: written}
+	return s, written
+}
+
+func TestSessionEvents(t *testing.T) {
+	s, written := newTestSession(t)
+	events := s.Events("ROOM_ADDED 3 carol 10 lobby\r\nOK OPEN_ROOM 3\r\nUSERS 3 bob:spammer\r\nMESSAGE 3 bob hel")
+	if len(events) != 3 {
+		t.Fatalf("Unexpected events: %+v", events)
+	}
+	events = s.Events("lo\r\nMESSAGE 3 spammer buy\r\nSVR_PING\r\n")
+	if len(events) != 3 || events[0].Hidden || !events[1].Hidden {
+		t.Fatalf("Unexpected events: %+v", events)
+	}
+
+	room, ok := s.Rooms.Room(3)
+	if !ok || !room.Entered || room.MemberCount() != 1 {
+		t.Errorf("Unexpected room: %+v", room)
+	}
+	if s.Chats.CurrentRoomID != 3 {
+		t.Errorf("Current room should be 3: %d", s.Chats.CurrentRoomID)
+	}
+	if text := s.Chats.GetText(0); text != "bob hello" {
+		t.Errorf("Unexpected chat line: %q", text)
+	}
+	if written.String() != "GET_PROFILE bob\r\nOK SVR_PING\r\n" {
+		t.Errorf("Unexpected reply: %q", written.String())
+	}
+}
+
+func TestSessionCommands(t *testing.T) {
+	s, written := newTestSession(t)
+	cs := NewCommandSet()
+	s.RegisterCommands(cs)
+
+	if _, err := cs.Execute("/say hi"); err == nil {
+		t.Errorf("/say should fail without a room")
+	}
+	for _, line := range []string{"/open 4", "/room 4", "/say hi all", "/close 4", "/raw GET_ROOMS"} {
+		if _, err := cs.Execute(line); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+	}
+	expected := "OPEN_ROOM 4\r\nSHOUT 4 hi all\r\nCLOSE_ROOM 4\r\nGET_ROOMS\r\n"
+	if written.String() != expected {
+		t.Errorf("Unexpected commands.\nexpected: %q\nresult: %q", expected, written.String())
+	}
+	if _, err := cs.Execute("/open x"); err == nil || !strings.Contains(err.Error(), "invalid room") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestSessionDirect(t *testing.T) {
+	s, written := newTestSession(t)
+	cs := NewCommandSet()
+	s.RegisterCommands(cs)
+	s.Chats.SetCurrentRoom(3)
+
+	s.Events("PRIVATE bob are you there\r\nPRIVATE spammer buy\r\n")
+	if s.Chats.UnreadTotal() != 1 {
+		t.Errorf("Direct message should be unread: %d", s.Chats.UnreadTotal())
+	}
+	if lines, _ := cs.Execute("/msg"); len(lines) != 1 || lines[0] != "bob 1 unread" {
+		t.Errorf("Unexpected conversations: %q", lines)
+	}
+	if _, err := cs.Execute("/msg bob"); err != nil || s.Chats.CurrentPeer != "bob" || s.Chats.UnreadTotal() != 0 {
+		t.Errorf("Conversation with bob should be shown: %q %v", s.Chats.CurrentPeer, err)
+	}
+	if err := s.Shout("yes"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.Execute("/msg carol hi"); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "PRIVATE bob yes\r\nPRIVATE carol hi\r\n"; written.String() != expected {
+		t.Errorf("Unexpected lines: %q", written.String())
+	}
+	if text := s.Chats.GetText(0); text != "me yes" {
+		t.Errorf("Unexpected chat line: %q", text)
+	}
+	if entries := s.Chats.DirectEntries("bob"); len(entries) != 2 || entries[0].Body != "are you there" {
+		t.Errorf("Unexpected entries: %+v", entries)
+	}
+	if entries := s.Chats.RoomEntries(0); len(entries) != 0 {
+		t.Errorf("Direct messages should not be room entries: %+v", entries)
+	}
+	s.Chats.SetCurrentRoom(3)
+	if s.Chats.CurrentPeer != "" {
+		t.Errorf("Selecting a room should leave the conversation")
+	}
+}
+
+func TestSessionDisconnect(t *testing.T) {
+	s, written := newTestSession(t)
+	s.Chats.SetCurrentRoom(3)
+	for _, text := range []string{"hi\nREMOVE_ROOM 3", "hi\r\nLOGOUT"} {
+		if err := s.Shout(text); err != igo.ErrMultiLine {
+			t.Errorf("Multi-line text should be rejected: %v", err)
+		}
+	}
+	if err := s.Direct("bob\nLOGOUT", "hi"); err != igo.ErrMultiLine {
+		t.Errorf("Multi-line peer should be rejected: %v", err)
+	}
+	if written.Len() != 0 {
+		t.Errorf("Nothing should be sent: %q", written.String())
+	}
+
+	s.Conn.state = StateConnected
+	if events := s.Events("quit\r\n"); len(events) != 1 || events[0].Type == igo.EventQuit || s.Conn.state != StateConnected {
+		t.Errorf("A server line should not disconnect: %+v", events)
+	}
+
+	server, client := net.Pipe()
+	s.Conn.conn = client
+	done := make(chan struct{})
+	defer close(done)
+	chunks := s.Conn.Receive(done)
+	go func() {
+		server.Write([]byte("MESSAGE 3 bob hi\r\n"))
+		server.Close()
+	}()
+	if chunk := <-chunks; chunk != "MESSAGE 3 bob hi\r\n" {
+		t.Errorf("Unexpected chunk: %q", chunk)
+	}
+	if _, ok := <-chunks; ok {
+		t.Errorf("Receive should be closed after EOF")
+	}
+	if events := s.Disconnected(); len(events) != 1 || events[0].Type != igo.EventQuit || s.Conn.state != StateDisconnected {
+		t.Errorf("Unexpected disconnect: %+v %s", events, s.Conn.state)
+	}
+}
+
+func TestSessionNoAlert(t *testing.T) {
+	settings := DefaultSettings()
+	settings.History = false
+	settings.Highlight = HighlightSettings{Bell: true, Notify: NotifyOSC9}
+	s := NewSession(&settings, ServerSettings{User: "me"})
+	if s.Chats.Highlighter.out != nil {
+		t.Errorf("Only the TUI should ring the terminal")
+	}
+}
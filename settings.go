@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Neetless/iGoClient/igo"
+)
+
+// Settings has user preferences which are not needed to build the client.
+// Connection information stays in config.go.
+type Settings struct {
+	// StatusFormat is a format of the status bar. See StatusBar.
+	StatusFormat string `json:"status_format"`
+	// ThemeFile is a path to a theme file. Empty means DefaultTheme.
+	ThemeFile string `json:"theme_file"`
+	// Highlight is rules to find mentions. Our login name always matches.
+	Highlight HighlightSettings `json:"highlight"`
+	// TimestampFormat is a Go time layout of chat timestamps. Empty hides them.
+	TimestampFormat string `json:"timestamp_format"`
+	// History keep chat logs on disk for /search.
+	History bool `json:"history"`
+	// HistoryDir is a directory of chat logs. Empty means XDG data directory.
+	HistoryDir string `json:"history_dir"`
+	// Filter is an ignore list and filter rules managed by /ignore and /filters.
+	Filter FilterSettings `json:"filter"`
+	// PluginDir is a directory of Lua plugins. Empty means "plugins" next to the settings file.
+	PluginDir string `json:"plugin_dir"`
+	// API is the local HTTP API for other tools. It is disabled by default.
+	API APISettings `json:"api"`
+	// Servers are connected at the same time. Empty means the server in config.go.
+	Servers []ServerSettings `json:"servers"`
+	// Send limit lines sent to servers.
+	Send SendSettings `json:"send"`
+	// Log choose the log file and the level. --log-level and --log-file override it.
+	Log LogSettings `json:"log"`
+
+	// loadErr is set when the file exists but could not be loaded. Save refuses then
+	// so that the user's file is not overwritten by defaults.
+	loadErr error
+	// filter is built from Filter by the first session and shared by the others.
+	filter *Filter
+}
+
+// ServerSettings is a server and the identity used on it.
+// Empty fields are filled from config.go.
+type ServerSettings struct {
+	// Name is shown in the room list and separates history directories.
+	Name         string `json:"name"`
+	Host         string `json:"host"`
+	Port         string `json:"port"`
+	User         string `json:"user"`
+	ID           int    `json:"id"`
+	Introduction string `json:"introduction"`
+	Level        string `json:"level"`
+	ClientInfo   string `json:"client_info"`
+	// Encoding is the wire encoding like shift_jis or auto. Empty means UTF-8.
+	Encoding string `json:"encoding"`
+}
+
+// defaultServer return the server in config.go.
+func defaultServer() ServerSettings {
+	return ServerSettings{
+		Host:         Host,
+		Port:         Port,
+		User:         User.user,
+		ID:           User.id,
+		Introduction: User.introduction,
+		Level:        User.level,
+		ClientInfo:   User.clientInfo,
+	}
+}
+
+// servers return servers to connect. Names must be unique directory names because
+// they separate history directories. Only one server may be unnamed and it uses
+// the history directory itself.
+func (s Settings) servers() ([]ServerSettings, error) {
+	if len(s.Servers) == 0 {
+		return []ServerSettings{defaultServer()}, nil
+	}
+	d := defaultServer()
+	servers := make([]ServerSettings, len(s.Servers))
+	names := make(map[string]bool)
+	for i, server := range s.Servers {
+		if server.Name == "." || server.Name == ".." || strings.ContainsAny(server.Name, `/\`) {
+			return nil, fmt.Errorf("server name %q must be a plain directory name", server.Name)
+		}
+		if names[server.Name] {
+			if server.Name == "" {
+				return nil, errors.New("only one server can be unnamed. Give the others \"name\"")
+			}
+			return nil, fmt.Errorf("server name %q is used twice", server.Name)
+		}
+		names[server.Name] = true
+		fill := func(v *string, def string) {
+			if *v == "" {
+				*v = def
+			}
+		}
+		fill(&server.Host, d.Host)
+		fill(&server.Port, d.Port)
+		fill(&server.User, d.User)
+		fill(&server.Introduction, d.Introduction)
+		fill(&server.Level, d.Level)
+		fill(&server.ClientInfo, d.ClientInfo)
+		if server.ID == 0 {
+			server.ID = d.ID
+		}
+		servers[i] = server
+	}
+	return servers, nil
+}
+
+// selectServer return the server of the name. Empty name means the first server.
+func selectServer(servers []ServerSettings, name string) (ServerSettings, error) {
+	if name == "" {
+		return servers[0], nil
+	}
+	for _, server := range servers {
+		if server.Name == name {
+			return server, nil
+		}
+	}
+	return ServerSettings{}, fmt.Errorf("no server named %q in settings", name)
+}
+
+// historyDir return the history directory of the server under base.
+func (s ServerSettings) historyDir(base string) string {
+	if s.Name == "" {
+		return base
+	}
+	return filepath.Join(base, s.Name)
+}
+
+// Label return the name or the host of the server.
+func (s ServerSettings) Label() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Host
+}
+
+// profile return the login profile.
+func (s ServerSettings) profile() igo.Profile {
+	return igo.Profile{User: s.User, ID: s.ID, Introduction: s.Introduction, Level: s.Level, ClientInfo: s.ClientInfo}
+}
+
+// DefaultSettings return Settings used when no settings file exists.
+func DefaultSettings() Settings {
+	return Settings{
+		StatusFormat:    DefaultStatusFormat,
+		TimestampFormat: DefaultTimestampFormat,
+		History:         true,
+	}
+}
+
+// settingsPath return the place of the settings file.
+// It follows XDG base directory and falls back to ~/.config.
+func settingsPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, "igoclient", "settings.json")
+}
+
+// historyDir return the directory of chat logs.
+func (s Settings) historyDir() string {
+	if s.HistoryDir != "" {
+		return s.HistoryDir
+	}
+	return historyPath()
+}
+
+// pluginDir return the directory of plugins.
+func (s Settings) pluginDir() string {
+	if s.PluginDir != "" {
+		return s.PluginDir
+	}
+	return filepath.Join(filepath.Dir(settingsPath()), "plugins")
+}
+
+// LoadSettings read settings file on path.
+// Missing fields and missing file are filled with DefaultSettings.
+// A file which can't be read or parsed gives DefaultSettings which are never saved.
+func LoadSettings(path string) (Settings, error) {
+	s := DefaultSettings()
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(b, &s)
+	} else if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		s = DefaultSettings()
+		s.loadErr = fmt.Errorf("cannot load %s: %s. Defaults are used and changes will not be saved", path, err.Error())
+		return s, s.loadErr
+	}
+	return s, nil
+}
+
+// sharedFilter return the Filter of every session.
+// Sharing it keeps /ignore on one server from dropping changes made on another when saved.
+// The error of NewFilter is returned only to the first caller.
+func (s *Settings) sharedFilter() (*Filter, error) {
+	if s.filter != nil {
+		return s.filter, nil
+	}
+	var err error
+	s.filter, err = NewFilter(s.Filter)
+	return s.filter, err
+}
+
+// LoadError return the error of LoadSettings which made Save refuse.
+func (s Settings) LoadError() error {
+	return s.loadErr
+}
+
+// Save write settings to path. It fails when LoadSettings could not load the file.
+func (s Settings) Save(path string) error {
+	if s.loadErr != nil {
+		return s.loadErr
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igoclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "settings.json")
+
+	s, err := LoadSettings(path)
+	if err != nil {
+		t.Fatalf("Missing file should not be an error: %v", err)
+	}
+	if s.StatusFormat != DefaultStatusFormat {
+		t.Errorf("Unexpected default format: %v", s.StatusFormat)
+	}
+
+	s.StatusFormat = "{user}"
+	if err := s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSettings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.StatusFormat != "{user}" {
+		t.Errorf("Unexpected loaded format: %v", loaded.StatusFormat)
+	}
+
+	// A broken file is kept for the user to fix.
+	ioutil.WriteFile(path, []byte(`{"status_format": "{user}",`), 0600)
+	broken, err := LoadSettings(path)
+	if err == nil || broken.LoadError() == nil || broken.StatusFormat != DefaultStatusFormat {
+		t.Fatalf("Broken file should give defaults and an error: %v", err)
+	}
+	broken.Filter.Ignore = []string{"spam"}
+	if err := broken.Save(path); err == nil || !strings.Contains(err.Error(), "will not be saved") {
+		t.Errorf("Save should refuse after a load error: %v", err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != `{"status_format": "{user}",` {
+		t.Errorf("Broken file should not be overwritten: %s", b)
+	}
+}
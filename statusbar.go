@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+// DefaultStatusFormat is used when settings has no status format.
+const DefaultStatusFormat = "[{state}] {user}@{host} {latency} | {view} | {room} ({members}) | unread {unread}"
+
+// StatusBar show connection, user and room information on the bottom line.
+//
+// Format can contain following place holders.
+//
+//	{server}  server name or host
+//	{host}    server host
+//	{user}    login name
+//	{state}   connection state
+//	{latency} round trip time of the last PING
+//	{view}    current view mode
+//	{room}    current room name
+//	{members} member count of current room
+//	{unread}  sum of unread chat lines
+//	{mentions} sum of unread mentions
+type StatusBar struct {
+	Format string
+	server string
+	host   string
+	user   string
+	conn   *ConnClient
+	rooms  *RoomBox
+	chats  *ChatBox
+	// message is a short result of the last command.
+	message string
+}
+
+// NewStatusBar create StatusBar instance.
+func NewStatusBar(format, host, user string, conn *ConnClient, rooms *RoomBox, chats *ChatBox) *StatusBar {
+	if format == "" {
+		format = DefaultStatusFormat
+	}
+	return &StatusBar{format, host, host, user, conn, rooms, chats, ""}
+}
+
+// Follow show the session.
+func (sb *StatusBar) Follow(s *Session) {
+	sb.server = s.Server.Label()
+	sb.host = s.Server.Host
+	sb.user = s.Server.User
+	sb.conn = s.Conn
+	sb.rooms = s.Rooms
+	sb.chats = s.Chats
+}
+
+// SetMessage show msg at the end of the status bar until next message.
+func (sb *StatusBar) SetMessage(msg string) {
+	sb.message = msg
+}
+
+// Text return the status line built from Format.
+func (sb *StatusBar) Text() string {
+	latency := "-"
+	if l := sb.conn.Latency(); l > 0 {
+		latency = fmt.Sprintf("%dms", l/time.Millisecond)
+	}
+	room, members := "-", "0"
+	if ri, ok := sb.rooms.Room(sb.chats.CurrentRoomID); ok {
+		room = ri.Name
+		members = strconv.Itoa(ri.MemberCount())
+	}
+	if sb.chats.CurrentPeer != "" {
+		room = "@" + sb.chats.CurrentPeer
+	}
+	r := strings.NewReplacer(
+		"{server}", sb.server,
+		"{host}", sb.host,
+		"{user}", sb.user,
+		"{state}", string(sb.conn.state),
+		"{latency}", latency,
+		"{view}", string(sb.conn.mode),
+		"{room}", room,
+		"{members}", members,
+		"{unread}", strconv.Itoa(sb.chats.UnreadTotal()),
+		"{mentions}", strconv.Itoa(sb.chats.MentionTotal()),
+	)
+	if sb.message != "" {
+		return r.Replace(sb.Format) + " | " + sb.message
+	}
+	return r.Replace(sb.Format)
+}
+
+// Draw status bar on the last line of the screen.
+func (sb *StatusBar) Draw() {
+	w, h := termbox.Size()
+	if h == 0 {
+		return
+	}
+	attr := termbox.ColorDefault | termbox.AttrReverse
+	for x := 0; x < w; x++ {
+		termbox.SetCell(x, h-1, ' ', attr, termbox.ColorDefault)
+	}
+	setCellLine(0, h-1, attr, termbox.ColorDefault, sb.Text())
+}
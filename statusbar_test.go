@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatusBarText(t *testing.T) {
+	roomList := NewRoomBox(20)
+	chatLogs := NewChatBox(20, roomList.rooms)
+	c := &ConnClient{mode: ChatMode, state: StateConnected, latency: 42 * time.Millisecond}
+	sb := NewStatusBar("", "example.com", "me", c, roomList, chatLogs)
+
+	roomList.AppendRoom(NewRoomInfo(1, "lobby", "owner"))
+	roomList.OtherEnterRoom(1, "alice")
+	roomList.OtherEnterRoom(1, "bob")
+	chatLogs.SetCurrentRoom(1)
+	chatLogs.AppendText(2, "hello")
+	chatLogs.AppendText(2, "again")
+
+	expected := "[connected] me@example.com 42ms | Chat | lobby (2) | unread 2"
+	if result := sb.Text(); result != expected {
+		t.Errorf("Unexpected status.\nexpected: %v\nresult: %v", expected, result)
+	}
+
+	chatLogs.SetCurrentRoom(2)
+	sb.Format = "{room} {unread}"
+	if result := sb.Text(); result != "- 0" {
+		t.Errorf("Unexpected status.\nexpected: %v\nresult: %v", "- 0", result)
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+// Style names used by TextArea to color its lines.
+const (
+	StyleDefault   = ""
+	StyleTimestamp = "timestamp"
+	StyleNick      = "nick"
+	StyleOwn       = "own"
+	StyleNotice    = "notice"
+	StyleError     = "error"
+	StyleMention   = "mention"
+	StyleSelected  = "selected"
+	StyleDim       = "dim"
+)
+
+// Style is a pair of termbox attributes to draw a text.
+type Style struct {
+	Fg termbox.Attribute
+	Bg termbox.Attribute
+}
+
+// Span is a part of a line drawn with the same style.
+type Span struct {
+	Text  string
+	Style string
+}
+
+// StyledTextArea is a TextArea which knows how each line should be colored.
+type StyledTextArea interface {
+	TextArea
+	GetStyledText(n int) []Span
+}
+
+// styleSpec is a style written in a theme file.
+type styleSpec struct {
+	Fg        string `json:"fg"`
+	Bg        string `json:"bg"`
+	Bold      bool   `json:"bold"`
+	Underline bool   `json:"underline"`
+	Reverse   bool   `json:"reverse"`
+	Dim       bool   `json:"dim"`
+}
+
+// themeFile is a layout of a theme file.
+//
+//	{
+//	  "output": "256",
+//	  "styles": {"nick": {"bold": true}, "mention": {"fg": "#000000", "bg": "#ffd700"}},
+//	  "nick_colors": ["33", "69", "#ff8700"]
+//	}
+type themeFile struct {
+	Output     string               `json:"output"`
+	Styles     map[string]styleSpec `json:"styles"`
+	NickColors []string             `json:"nick_colors"`
+}
+
+var defaultStyleSpecs = map[string]styleSpec{
+	StyleDefault:   {},
+	StyleTimestamp: {Fg: "cyan"},
+	StyleNick:      {Bold: true},
+	StyleOwn:       {Fg: "yellow", Bold: true},
+	StyleNotice:    {Fg: "blue"},
+	StyleError:     {Fg: "red", Bold: true},
+	StyleMention:   {Fg: "black", Bg: "yellow"},
+	StyleSelected:  {Reverse: true},
+	StyleDim:       {Dim: true},
+}
+
+var defaultNickColors = map[termbox.OutputMode][]string{
+	termbox.OutputNormal: {"red", "green", "yellow", "blue", "magenta", "cyan"},
+	termbox.Output256: {"33", "39", "42", "48", "69", "75", "78", "99", "105",
+		"135", "141", "166", "172", "178", "184", "203", "209", "214"},
+}
+
+// Theme has resolved styles for an output mode.
+type Theme struct {
+	Output     termbox.OutputMode
+	styles     map[string]Style
+	nickColors []termbox.Attribute
+}
+
+// DefaultTheme return the theme used when no theme file is given.
+func DefaultTheme() *Theme {
+	theme, _ := newTheme(themeFile{})
+	return theme
+}
+
+// LoadTheme read a theme file.
+// Styles missing in the file are taken from the default theme.
+func LoadTheme(path string) (*Theme, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return DefaultTheme(), err
+	}
+	var tf themeFile
+	if err := json.Unmarshal(b, &tf); err != nil {
+		return DefaultTheme(), err
+	}
+	return newTheme(tf)
+}
+
+func newTheme(tf themeFile) (*Theme, error) {
+	mode, err := parseOutputMode(tf.Output)
+	if err != nil {
+		return DefaultTheme(), err
+	}
+	theme := &Theme{Output: mode, styles: make(map[string]Style)}
+	for name, spec := range defaultStyleSpecs {
+		if s, ok := tf.Styles[name]; ok {
+			spec = s
+		}
+		style, err := spec.resolve(mode)
+		if err != nil {
+			return DefaultTheme(), fmt.Errorf("style %s: %v", name, err)
+		}
+		theme.styles[name] = style
+	}
+	for _, spec := range tf.NickColors {
+		color, err := parseColor(spec, mode)
+		if err != nil {
+			return DefaultTheme(), fmt.Errorf("nick color: %v", err)
+		}
+		theme.nickColors = append(theme.nickColors, color)
+	}
+	if len(theme.nickColors) == 0 {
+		for _, spec := range defaultNickColors[mode] {
+			color, _ := parseColor(spec, mode)
+			theme.nickColors = append(theme.nickColors, color)
+		}
+	}
+	return theme, nil
+}
+
+// Style return the style which has given name.
+func (t *Theme) Style(name string) Style {
+	return t.styles[name]
+}
+
+// NickStyle return the nick style colored by a hash of the nick.
+// The same nick always get the same color.
+func (t *Theme) NickStyle(nick string) Style {
+	style := t.styles[StyleNick]
+	sum := nickHash(nick)
+	attrs := style.Fg & (termbox.AttrBold | termbox.AttrUnderline | termbox.AttrReverse)
+	if len(t.nickColors) > 0 {
+		style.Fg = attrs | t.nickColors[sum%uint32(len(t.nickColors))]
+	} else if t.Output == termbox.OutputRGB {
+		r, g, b := hueToRGB(float64(sum % 360))
+		style.Fg = attrs | termbox.RGBToAttribute(r, g, b)
+	}
+	return style
+}
+
+// nickHash return a stable hash of the nick used to choose its color.
+func nickHash(nick string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(nick))
+	return h.Sum32()
+}
+
+// SpanStyle return the style to draw the span.
+func (t *Theme) SpanStyle(s Span) Style {
+	if s.Style == StyleNick {
+		return t.NickStyle(s.Text)
+	}
+	return t.Style(s.Style)
+}
+
+func (s styleSpec) resolve(mode termbox.OutputMode) (Style, error) {
+	fg, err := parseColor(s.Fg, mode)
+	if err != nil {
+		return Style{}, err
+	}
+	bg, err := parseColor(s.Bg, mode)
+	if err != nil {
+		return Style{}, err
+	}
+	if s.Bold {
+		fg |= termbox.AttrBold
+	}
+	if s.Underline {
+		fg |= termbox.AttrUnderline
+	}
+	if s.Reverse {
+		fg |= termbox.AttrReverse
+	}
+	if s.Dim {
+		fg |= termbox.AttrDim
+	}
+	return Style{fg, bg}, nil
+}
+
+func parseOutputMode(s string) (termbox.OutputMode, error) {
+	switch strings.ToLower(s) {
+	case "", "normal", "8":
+		return termbox.OutputNormal, nil
+	case "256":
+		return termbox.Output256, nil
+	case "truecolor", "rgb", "24bit":
+		return termbox.OutputRGB, nil
+	}
+	return termbox.OutputNormal, fmt.Errorf("unknown output mode %q", s)
+}
+
+// basicColors is a list of the 8 ANSI colors with its rgb value.
+var basicColors = []struct {
+	name    string
+	r, g, b uint8
+}{
+	{"black", 0, 0, 0},
+	{"red", 205, 0, 0},
+	{"green", 0, 205, 0},
+	{"yellow", 205, 205, 0},
+	{"blue", 0, 0, 238},
+	{"magenta", 205, 0, 205},
+	{"cyan", 0, 205, 205},
+	{"white", 229, 229, 229},
+}
+
+// parseColor convert color spec to termbox attribute for the output mode.
+// Spec is "default", a color name, a 256 color index or "#rrggbb".
+func parseColor(spec string, mode termbox.OutputMode) (termbox.Attribute, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if spec == "" || spec == "default" {
+		return termbox.ColorDefault, nil
+	}
+
+	var r, g, b uint8
+	index := -1
+	switch {
+	case strings.HasPrefix(spec, "#"):
+		v, err := strconv.ParseUint(spec[1:], 16, 32)
+		if err != nil || len(spec) != 7 {
+			return termbox.ColorDefault, fmt.Errorf("invalid color %q", spec)
+		}
+		r, g, b = uint8(v>>16), uint8(v>>8), uint8(v)
+	case spec[0] >= '0' && spec[0] <= '9':
+		n, err := strconv.Atoi(spec)
+		if err != nil || n < 0 || n > 255 {
+			return termbox.ColorDefault, fmt.Errorf("invalid color %q", spec)
+		}
+		index = n
+		r, g, b = xtermRGB(n)
+	default:
+		for i, c := range basicColors {
+			if c.name == spec {
+				index = i
+				r, g, b = c.r, c.g, c.b
+			}
+		}
+		if index < 0 {
+			return termbox.ColorDefault, fmt.Errorf("unknown color %q", spec)
+		}
+	}
+
+	switch mode {
+	case termbox.OutputRGB:
+		return termbox.RGBToAttribute(r, g, b), nil
+	case termbox.Output256:
+		if index < 0 {
+			index = nearestXterm(r, g, b)
+		}
+		// Output256 use 1 to 256 for 0 to 255 index.
+		return termbox.Attribute(index + 1), nil
+	default:
+		if index < 0 || index > 7 {
+			index = nearestBasic(r, g, b)
+		}
+		return termbox.ColorBlack + termbox.Attribute(index), nil
+	}
+}
+
+// xtermRGB return rgb value of xterm 256 color index.
+func xtermRGB(n int) (uint8, uint8, uint8) {
+	switch {
+	case n < 8:
+		c := basicColors[n]
+		return c.r, c.g, c.b
+	case n < 16:
+		c := basicColors[n-8]
+		return brighten(c.r), brighten(c.g), brighten(c.b)
+	case n < 232:
+		n -= 16
+		return cubeLevel(n / 36), cubeLevel(n / 6 % 6), cubeLevel(n % 6)
+	}
+	v := uint8(8 + (n-232)*10)
+	return v, v, v
+}
+
+func brighten(v uint8) uint8 {
+	if v == 0 {
+		return 127
+	}
+	return 255
+}
+
+func cubeLevel(i int) uint8 {
+	if i == 0 {
+		return 0
+	}
+	return uint8(55 + i*40)
+}
+
+func nearestXterm(r, g, b uint8) int {
+	best, bestDist := 0, -1
+	for i := 16; i < 256; i++ {
+		xr, xg, xb := xtermRGB(i)
+		if d := colorDistance(r, g, b, xr, xg, xb); bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+func nearestBasic(r, g, b uint8) int {
+	best, bestDist := 0, -1
+	for i, c := range basicColors {
+		if d := colorDistance(r, g, b, c.r, c.g, c.b); bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+func colorDistance(r1, g1, b1, r2, g2, b2 uint8) int {
+	dr, dg, db := int(r1)-int(r2), int(g1)-int(g2), int(b1)-int(b2)
+	return dr*dr + dg*dg + db*db
+}
+
+// hueToRGB return a bright color which has given hue.
+func hueToRGB(h float64) (uint8, uint8, uint8) {
+	const s, v = 0.6, 0.95
+	c := v * s
+	hh := h / 60
+	x := c * (1 - abs(mod2(hh)-1))
+	var r, g, b float64
+	switch int(hh) {
+	case 0:
+		r, g, b = c, x, 0
+	case 1:
+		r, g, b = x, c, 0
+	case 2:
+		r, g, b = 0, c, x
+	case 3:
+		r, g, b = 0, x, c
+	case 4:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	m := v - c
+	return uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255)
+}
+
+func mod2(f float64) float64 {
+	return f - 2*float64(int(f/2))
+}
+
+func abs(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+// setSpanLine draw spans from x, y with styles of the theme.
+func setSpanLine(x, y int, theme *Theme, spans []Span) int {
+	for _, s := range spans {
+		style := theme.SpanStyle(s)
+		x = setCellLine(x, y, style.Fg, style.Bg, s.Text)
+	}
+	return x
+}
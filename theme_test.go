@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nsf/termbox-go"
+)
+
+func TestParseColor(t *testing.T) {
+	cases := []struct {
+		spec     string
+		mode     termbox.OutputMode
+		expected termbox.Attribute
+	}{
+		{"default", termbox.OutputNormal, termbox.ColorDefault},
+		{"red", termbox.OutputNormal, termbox.ColorRed},
+		{"#0000ff", termbox.OutputNormal, termbox.ColorBlue},
+		{"red", termbox.Output256, termbox.Attribute(2)},
+		{"196", termbox.Output256, termbox.Attribute(197)},
+		{"#ff0000", termbox.Output256, termbox.Attribute(197)},
+		{"#102030", termbox.OutputRGB, termbox.RGBToAttribute(0x10, 0x20, 0x30)},
+	}
+	for _, c := range cases {
+		result, err := parseColor(c.spec, c.mode)
+		if err != nil {
+			t.Errorf("%s: %v", c.spec, err)
+			continue
+		}
+		if result != c.expected {
+			t.Errorf("Unexpected color for %s.\nexpected: %v\nresult: %v", c.spec, c.expected, result)
+		}
+	}
+	for _, spec := range []string{"purple", "#12345", "256"} {
+		if _, err := parseColor(spec, termbox.OutputNormal); err == nil {
+			t.Errorf("%s should be an error", spec)
+		}
+	}
+}
+
+func TestNickStyleIsStable(t *testing.T) {
+	theme := DefaultTheme()
+	if theme.NickStyle("alice") != theme.NickStyle("alice") {
+		t.Errorf("Nick color should not change")
+	}
+	if theme.NickStyle("alice").Fg&termbox.AttrBold == 0 {
+		t.Errorf("Nick style should keep bold attribute")
+	}
+}
+
+func TestLoadTheme(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igoclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "theme.json")
+	ioutil.WriteFile(path, []byte(`{"output": "truecolor",
+		"styles": {"error": {"fg": "#ff0000"}}}`), 0600)
+
+	theme, err := LoadTheme(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if theme.Output != termbox.OutputRGB {
+		t.Errorf("Unexpected output mode: %v", theme.Output)
+	}
+	if theme.Style(StyleError).Fg != termbox.RGBToAttribute(0xff, 0, 0) {
+		t.Errorf("Error style is not loaded")
+	}
+	if theme.Style(StyleTimestamp).Fg != termbox.RGBToAttribute(0, 205, 205) {
+		t.Errorf("Missing style should use default spec")
+	}
+	if theme.NickStyle("a") == theme.NickStyle("b") {
+		t.Errorf("Different nick should get different color")
+	}
+}
+
+func TestChatBoxStyledText(t *testing.T) {
+	roomList := NewRoomBox(20)
+	chatLogs := NewChatBox(20, roomList.rooms)
+	chatLogs.Self = "me"
+	chatLogs.TimeFormat = ""
+	chatLogs.SetCurrentRoom(1)
+	chatLogs.AppendText(1, "alice hello")
+	chatLogs.AppendText(1, "me hi")
+
+	own := chatLogs.GetStyledText(0)
+	if len(own) != 1 || own[0].Style != StyleOwn {
+		t.Errorf("Own message should use own style: %v", own)
+	}
+	other := chatLogs.GetStyledText(1)
+	if len(other) != 2 || other[0] != (Span{"alice", StyleNick}) || other[1].Text != " hello" {
+		t.Errorf("Nick should be colored: %v", other)
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Neetless/iGoClient/igo"
+	"github.com/nsf/termbox-go"
+)
+
+// serverChunk is a chunk received from a session.
+// closed is set instead of chunk when the server closed the connection.
+type serverChunk struct {
+	s      *Session
+	chunk  string
+	closed bool
+}
+
+// runTUI run the termbox frontend on the sessions. The first session is focused first.
+// Plugins and the local API work on the first session.
+func runTUI(sessions []*Session) {
+	s := sessions[0]
+	primary := s
+	// Set termbox
+	if err := termbox.Init(); err != nil {
+		fmt.Println("ERROR: Cannot initialize termbox")
+		os.Exit(1)
+	}
+	defer termbox.Close()
+	defer EnableBracketedPaste()()
+	theme := DefaultTheme()
+	if s.Settings.ThemeFile != "" {
+		var err error
+		if theme, err = LoadTheme(s.Settings.ThemeFile); err != nil {
+			uiLog.Warn("Cannot load theme", "error", err)
+		}
+	}
+	termbox.SetOutputMode(theme.Output)
+
+	// Set screens
+	eb := &EditBox{}
+	ws := &WholeScreen{}
+	connMsg := NewTextBox(20)
+	c := s.Conn
+	chatLogs := s.Chats
+	focused := 0
+	roomList := NewServerRoomList(sessions, &focused)
+	searchResults := NewSearchBox(20)
+	scrollback := NewScrollBox(20)
+	sb := NewStatusBar(s.Settings.StatusFormat, s.Server.Host, s.Server.User, c, s.Rooms, chatLogs)
+	sb.Follow(s)
+
+	ts := &TextScreen{Theme: theme}
+	ts.SetTextArea(connMsg)
+	ws.append(eb)
+	ws.append(ts)
+	ws.append(sb)
+
+	// Each session has its own commands so that they target the focused session.
+	commandSets := make([]CommandSet, len(sessions))
+	// dialog takes input lines while a room is being created on dialogSession.
+	var dialog *RoomDialog
+	var dialogSession *Session
+	// pendingPaste is a large paste waiting for confirmation.
+	var pendingPaste string
+	inspectors := make([]*Inspector, len(sessions))
+	for i, ss := range sessions {
+		inspectors[i] = NewInspector(200)
+		ss.Conn.Trace = inspectors[i].Record
+		// The bell and notifications go to the terminal only in this frontend.
+		ss.Chats.Highlighter.SetOutput(os.Stdout)
+	}
+	inspector := func() *Inspector { return inspectors[focused] }
+	focus := func(i int) {
+		mode := c.mode
+		focused = i
+		s = sessions[i]
+		c, chatLogs = s.Conn, s.Chats
+		c.mode = mode
+		sb.Follow(s)
+		switch mode {
+		case ChatMode, MemberMode:
+			ts.SetTextArea(chatLogs)
+		case MentionMode:
+			ts.SetTextArea(chatLogs.Mentions)
+		case InspectorMode:
+			ts.SetTextArea(inspector())
+		case SearchMode, ScrollMode:
+			c.mode = ChatMode
+			ts.SetTextArea(chatLogs)
+		}
+		chatLogs.ShowRoomMember = c.mode == MemberMode
+	}
+	for i, ss := range sessions {
+		cs := NewCommandSet()
+		ss.RegisterCommands(cs)
+		RegisterSearchCommands(cs, ss.Chats.History, searchResults, func() {
+			c.mode = SearchMode
+			ts.SetTextArea(searchResults)
+		})
+		RegisterServerCommands(cs, sessions, focus)
+		RegisterRoomCommands(cs, ss, func(d *RoomDialog) {
+			dialog, dialogSession = d, ss
+		})
+		RegisterInspectorCommands(cs, inspector, func() {
+			c.mode = InspectorMode
+			ts.SetTextArea(inspector())
+		}, func(line string) {
+			c.mode = InspectorMode
+			ts.SetTextArea(inspector())
+			eb.SetText(line)
+		})
+		commandSets[i] = cs
+	}
+	commands := func() CommandSet { return commandSets[focused] }
+	plugins := NewPluginHost(primary.Settings.pluginDir(), primary, commandSets...)
+	for _, cs := range commandSets {
+		RegisterPluginCommands(cs, plugins, func() {
+			c.mode = PluginMode
+			ts.SetTextArea(plugins)
+		})
+	}
+	for _, err := range plugins.Load() {
+		showCommandResult(connMsg, sb, nil, err)
+	}
+	if err := primary.Settings.LoadError(); err != nil {
+		showCommandResult(connMsg, sb, nil, err)
+	}
+	if err := primary.Filter.Err(); err != nil {
+		showCommandResult(connMsg, sb, nil, err)
+	}
+	pluginCheck := time.Tick(2 * time.Second)
+	api, err := StartAPI(primary.Settings.API, primary)
+	if err != nil {
+		showCommandResult(connMsg, sb, nil, fmt.Errorf("cannot start API: %s", err.Error()))
+	}
+	defer api.Close()
+
+	// Draw initial screen
+	termbox.SetInputMode(termbox.InputEsc)
+	ws.drawAll()
+
+	done := make(chan struct{})
+	defer close(done)
+	response := make(chan serverChunk)
+	sendErrs := make(chan error)
+	connected := 0
+	for _, ss := range sessions {
+		uiLog.Info("Start TCP setting", "server", ss.Server.Label())
+		if err := ss.Connect(); err != nil {
+			uiLog.Error("Cannot connect", "server", ss.Server.Label(), "error", err)
+			ss.Conn.setState(StateDisconnected)
+			showCommandResult(connMsg, sb, nil, fmt.Errorf("%s: %s", ss.Server.Label(), err.Error()))
+			continue
+		}
+		connected++
+
+		ss.Conn.StartQueue(ss.Settings.Send, done)
+		go func(ss *Session) {
+			for {
+				select {
+				case err := <-ss.Conn.Errors():
+					select {
+					case sendErrs <- fmt.Errorf("%s: %s", ss.Server.Label(), err.Error()):
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}(ss)
+
+		uiLog.Debug("Start sending PING message")
+		go ss.Conn.Ping(done)
+
+		uiLog.Debug("Start receiving message")
+		go func(ss *Session, chunks <-chan string) {
+			for chunk := range chunks {
+				response <- serverChunk{ss, chunk, false}
+			}
+			select {
+			case response <- serverChunk{ss, "", true}:
+			case <-done:
+			}
+		}(ss, ss.Conn.Receive(done))
+
+		uiLog.Debug("Start login conversation")
+		ss.Login()
+	}
+	if connected == 0 {
+		return
+	}
+	if !s.Conn.connected() {
+		focus(nextConnected(sessions, focused))
+	}
+
+	uiLog.Debug("Start getting keyboard inputs")
+	keyInput := DecodeInput(Input(done), done)
+
+	uiLog.Debug("Start main loop")
+	for {
+		select {
+		case k := <-keyInput:
+			if k.Paste != "" {
+				if n := pasteLines(k.Paste); n > pasteConfirmLines {
+					pendingPaste = k.Paste
+					sb.SetMessage(fmt.Sprintf("Paste %d lines? y/n", n))
+				} else {
+					eb.InsertText(k.Paste)
+				}
+				continue
+			}
+			if pendingPaste != "" && k.Type == termbox.EventKey {
+				if k.Ch == 'y' || k.Ch == 'Y' {
+					eb.InsertText(pendingPaste)
+					sb.SetMessage("Pasted")
+				} else {
+					sb.SetMessage("Paste dropped")
+				}
+				pendingPaste = ""
+				continue
+			}
+			switch k.Type {
+			case termbox.EventKey:
+				switch k.Key {
+				case termbox.KeyArrowRight, termbox.KeyCtrlF:
+					eb.MoveCursorOneRuneForward()
+				case termbox.KeyArrowLeft, termbox.KeyCtrlB:
+					eb.MoveCursorOneRuneBackward()
+				case termbox.KeyBackspace, termbox.KeyBackspace2:
+					eb.DeleteRuneBackward()
+				case termbox.KeyEnter:
+					if k.Mod&termbox.ModAlt != 0 {
+						// Alt+Enter compose a multi-line message.
+						eb.InsertRune('\n')
+						continue
+					}
+					bmsg := eb.GetAndDeleteText()
+					message := string(bmsg[:])
+
+					if strings.Contains(message, "\n") && (dialog != nil || IsCommand(message)) {
+						eb.SetText(message)
+						showCommandResult(connMsg, sb, nil, errors.New("a command must be one line"))
+						continue
+					}
+
+					if dialog != nil {
+						cmd, done, err := dialog.Input(message)
+						switch {
+						case err != nil:
+							showCommandResult(connMsg, sb, []string{dialog.Prompt()}, err)
+						case done:
+							dialog = nil
+							if err := dialogSession.Conn.Send(cmd); err != nil {
+								showCommandResult(connMsg, sb, nil, err)
+							}
+						default:
+							showCommandResult(connMsg, sb, []string{dialog.Prompt()}, nil)
+						}
+						continue
+					}
+
+					if IsCommand(message) {
+						lines, err := commands().Execute(message)
+						showCommandResult(connMsg, sb, lines, err)
+						continue
+					}
+
+					// Exit process
+					msgTokens := strings.Split(message, " ")
+					switch msgTokens[0] {
+					case "quit":
+						uiLog.Info("Exit by quit signal from keyboard input")
+						for _, ss := range sessions {
+							if ss.Conn.state == StateConnected {
+								ss.Conn.sendInternal("LOGOUT")
+							}
+						}
+						for _, ss := range sessions {
+							ss.Conn.Flush(logoutGrace)
+						}
+						return
+					}
+
+					// Implement input msg process
+					var arrangedMsg string
+					switch c.mode {
+					case DirectMode, InspectorMode:
+						arrangedMsg = message
+					case RoomMode:
+						// Words like "open 1" run the command of the word.
+						commandNames := map[string]string{
+							"open": "open", "close": "close", "add": "addroom", "remove": "removeroom", "info": "roominfo",
+						}
+						name, ok := commandNames[msgTokens[0]]
+						if !ok {
+							showCommandResult(connMsg, sb, nil, errUsage("open|close|info|remove <room> or add"))
+							continue
+						}
+						lines, err := commands().Execute("/" + name + " " + strings.Join(msgTokens[1:], " "))
+						showCommandResult(connMsg, sb, lines, err)
+						continue
+					case ChatMode:
+						if msgTokens[0] == "room" && len(msgTokens) == 2 {
+							if roomID, err := strconv.Atoi(msgTokens[1]); err == nil {
+								chatLogs.SetCurrentRoom(roomID)
+							}
+							// Skip send message
+							continue
+						}
+						// Send chat message. Each line of a multi-line message is a SHOUT.
+						if err := s.ShoutLines(message); err != nil {
+							showCommandResult(connMsg, sb, nil, err)
+						}
+						continue
+					case SearchMode:
+						n, _ := strconv.Atoi(strings.TrimSpace(message))
+						r, ok := searchResults.Result(n)
+						if !ok {
+							continue
+						}
+						entries, err := chatLogs.History.Entries(r.Entry.RoomID)
+						if err != nil {
+							showCommandResult(connMsg, sb, nil, err)
+							continue
+						}
+						scrollback.Open(entries, r.Line)
+						c.mode = ScrollMode
+						ts.SetTextArea(scrollback)
+						continue
+					case MemberMode, MentionMode, ScrollMode, PluginMode:
+						continue
+					}
+
+					// Server require new line character
+					for _, line := range strings.Split(arrangedMsg, "\n") {
+						if err := c.Send(line); err != nil {
+							showCommandResult(connMsg, sb, nil, err)
+							break
+						}
+					}
+				case termbox.KeyEsc:
+					if dialog != nil {
+						dialog = nil
+						sb.SetMessage("Canceled")
+						continue
+					}
+					uiLog.Info("Exit by KeyEsc signal")
+					return
+				case termbox.KeyF7:
+					if c.mode == InspectorMode {
+						if in := inspector(); in.Paused() {
+							in.Resume()
+							sb.SetMessage("Inspector resumed")
+						} else {
+							in.Pause()
+							sb.SetMessage("Inspector paused")
+						}
+					}
+				case termbox.KeyF8:
+					if next := nextConnected(sessions, focused); next != focused {
+						focus(next)
+						sb.SetMessage("Focused " + s.Server.Label())
+					} else {
+						sb.SetMessage("No other server is connected")
+					}
+				case termbox.KeySpace:
+					r, _ := utf8.DecodeLastRune([]byte(" "))
+					eb.InsertRune(r)
+				case termbox.KeyF9:
+					switch c.mode {
+					case DirectMode:
+						c.mode = RoomMode
+						ts.SetTextArea(roomList)
+					case RoomMode:
+						c.mode = ChatMode
+						chatLogs.ShowRoomMember = false
+						ts.SetTextArea(chatLogs)
+					case ChatMode:
+						c.mode = MemberMode
+						chatLogs.ShowRoomMember = true
+					case MemberMode:
+						c.mode = MentionMode
+						ts.SetTextArea(chatLogs.Mentions)
+					case MentionMode:
+						c.mode = PluginMode
+						ts.SetTextArea(plugins)
+					case PluginMode:
+						c.mode = InspectorMode
+						ts.SetTextArea(inspector())
+					case InspectorMode:
+						c.mode = DirectMode
+						ts.SetTextArea(connMsg)
+					case SearchMode, ScrollMode:
+						c.mode = ChatMode
+						chatLogs.ShowRoomMember = false
+						ts.SetTextArea(chatLogs)
+					}
+				case termbox.KeyPgup:
+					if c.mode == ScrollMode {
+						scrollback.Scroll(scrollback.GetMaxLine() / 2)
+					}
+				case termbox.KeyPgdn:
+					if c.mode == ScrollMode {
+						scrollback.Scroll(-scrollback.GetMaxLine() / 2)
+					}
+
+				case termbox.KeyF3:
+					ts.SetTextArea(connMsg)
+				default:
+					eb.InsertRune(k.Ch)
+				}
+			case termbox.EventError:
+				return
+			}
+		case err := <-sendErrs:
+			showCommandResult(connMsg, sb, nil, err)
+		case fn := <-api.Calls():
+			fn()
+		case <-pluginCheck:
+			if plugins.Changed() {
+				uiLog.Info("Reload plugins")
+				for _, err := range plugins.Load() {
+					showCommandResult(connMsg, sb, nil, err)
+				}
+			}
+		case r := <-response:
+			prefix := "Server response: "
+			if len(sessions) > 1 {
+				prefix = "[" + r.s.Server.Label() + "] " + prefix
+			}
+			events := r.s.Events(r.chunk)
+			if r.closed {
+				events = r.s.Disconnected()
+			}
+			for _, e := range events {
+				if e.Type == igo.EventQuit {
+					connMsg.AppendStyledText(prefix+e.String(), StyleNotice)
+				} else {
+					connMsg.AppendStyledText(prefix+e.Raw, StyleNotice)
+				}
+				if r.s == primary {
+					api.Publish(e)
+				}
+				if e.Type == igo.EventQuit {
+					if connected--; connected == 0 {
+						return
+					}
+					sb.SetMessage(r.s.Server.Label() + " disconnected")
+				}
+			}
+		default:
+			termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+			ws.drawAll()
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"crypto/subtle"
+	"embed"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/Neetless/iGoClient/igo"
+	"github.com/gorilla/websocket"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// webHistoryLines is the number of history entries sent when a room is shown.
+const webHistoryLines = 200
+
+// webTokenCookie keep the token after the page is opened with ?token=.
+const webTokenCookie = "igoclient_token"
+
+// webCommands are commands which tabs may run. Commands which write files or
+// save settings like /export and /ignore, and /raw are left out.
+var webCommands = []string{"open", "close", "room", "msg", "say", "addroom", "removeroom", "roominfo", "profile", "whois"}
+
+// webMessage is a message on the WebSocket in both directions.
+//
+// Browser to client: say, open, close, command and history.
+// Client to browser: state, event, history and result.
+type webMessage struct {
+	Type    string      `json:"type"`
+	Room    int         `json:"room,omitempty"`
+	Text    string      `json:"text,omitempty"`
+	Self    string      `json:"self,omitempty"`
+	Rooms   []apiRoom   `json:"rooms,omitempty"`
+	Event   *Event      `json:"event,omitempty"`
+	Entries []ChatEntry `json:"entries,omitempty"`
+	Lines   []string    `json:"lines,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// WebServer serve the browser UI and share one session among browser tabs.
+// Like APIServer, the session is touched only in the frontend loop through Calls.
+type WebServer struct {
+	session  *Session
+	commands CommandSet
+	token    string
+	calls    chan func()
+	// done is closed by Close when the frontend loop stops running calls.
+	done     chan struct{}
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	clients map[*webClient]bool
+}
+
+// webClient is a browser tab.
+type webClient struct {
+	conn *websocket.Conn
+	out  chan webMessage
+}
+
+// NewWebServer create WebServer. Commands in webCommands are run by "command" messages.
+// Every WebSocket needs the token.
+func NewWebServer(s *Session, cs CommandSet, token string) *WebServer {
+	return &WebServer{
+		session:  s,
+		commands: cs.Only(webCommands...),
+		token:    token,
+		calls:    make(chan func()),
+		done:     make(chan struct{}),
+		clients:  make(map[*webClient]bool),
+	}
+}
+
+// Calls return functions which the frontend loop must run.
+func (ws *WebServer) Calls() <-chan func() {
+	return ws.calls
+}
+
+// Handler return the handler of the UI and /ws.
+func (ws *WebServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	static, _ := fs.Sub(webFiles, "web")
+	files := http.FileServer(http.FS(static))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// Move the token of the printed URL to a cookie and out of the address bar.
+		if token := r.URL.Query().Get("token"); token != "" && ws.validToken(token) {
+			http.SetCookie(w, &http.Cookie{Name: webTokenCookie, Value: token, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+			http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/ws", ws.serveWS)
+	return mux
+}
+
+// validToken compare token with the token of the server in constant time.
+func (ws *WebServer) validToken(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(ws.token)) == 1
+}
+
+// authorized check the token in the cookie or ?token=.
+func (ws *WebServer) authorized(r *http.Request) bool {
+	if c, err := r.Cookie(webTokenCookie); err == nil && ws.validToken(c.Value) {
+		return true
+	}
+	return ws.validToken(r.URL.Query().Get("token"))
+}
+
+// Close tell tabs that the frontend loop has stopped. Waiting calls return false.
+func (ws *WebServer) Close() {
+	close(ws.done)
+}
+
+// call run fn in the frontend loop and wait for it.
+// It return false without running fn after Close.
+func (ws *WebServer) call(fn func()) bool {
+	done := make(chan struct{})
+	select {
+	case ws.calls <- func() { fn(); close(done) }:
+	case <-ws.done:
+		return false
+	}
+	<-done
+	return true
+}
+
+// state return rooms and members. It must run in the frontend loop.
+func (ws *WebServer) state() webMessage {
+	m := webMessage{Type: "state", Self: ws.session.Chats.Self, Rooms: []apiRoom{}}
+	for _, ri := range *ws.session.Rooms.rooms {
+		if ri.ID == 0 {
+			continue
+		}
+		room := apiRoom{ID: ri.ID, Name: ri.Name, Owner: ri.Owner, Entered: ri.Entered, Members: []string{}}
+		for _, member := range ri.Members {
+			if member != "" {
+				room.Members = append(room.Members, member)
+			}
+		}
+		m.Rooms = append(m.Rooms, room)
+	}
+	return m
+}
+
+// history return recent entries of the room. It must run in the frontend loop.
+func (ws *WebServer) history(room int) webMessage {
+	entries := ws.session.Chats.RoomEntries(room)
+	if h := ws.session.Chats.History; h != nil {
+		if stored, err := h.Entries(room); err == nil {
+			entries = stored
+		}
+	}
+	if len(entries) > webHistoryLines {
+		entries = entries[len(entries)-webHistoryLines:]
+	}
+	return webMessage{Type: "history", Room: room, Entries: entries}
+}
+
+// Publish send the event to every tab. Room changes also send the new state.
+// It must run in the frontend loop.
+func (ws *WebServer) Publish(e Event) {
+	if e.Hidden {
+		return
+	}
+	ws.broadcast(webMessage{Type: "event", Event: &e})
+	switch e.Type {
+	case igo.EventMessage, igo.EventServerPing, igo.EventUnknown:
+	default:
+		ws.broadcast(ws.state())
+	}
+}
+
+func (ws *WebServer) broadcast(m webMessage) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for c := range ws.clients {
+		c.send(m)
+	}
+}
+
+// send queue the message. A slow tab loses messages rather than blocking the frontend.
+func (c *webClient) send(m webMessage) {
+	select {
+	case c.out <- m:
+	default:
+	}
+}
+
+func (ws *WebServer) serveWS(w http.ResponseWriter, r *http.Request) {
+	if !ws.authorized(r) {
+		webLog.Warn("WebSocket with an invalid token", "remote", r.RemoteAddr)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	conn, err := ws.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		webLog.Warn("WebSocket upgrade failed", "error", err)
+		return
+	}
+	c := &webClient{conn: conn, out: make(chan webMessage, 64)}
+	go func() {
+		for m := range c.out {
+			if err := conn.WriteJSON(m); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+	ws.mu.Lock()
+	ws.clients[c] = true
+	ws.mu.Unlock()
+	defer func() {
+		ws.mu.Lock()
+		delete(ws.clients, c)
+		close(c.out)
+		ws.mu.Unlock()
+		conn.Close()
+	}()
+
+	if !ws.call(func() { c.send(ws.state()) }) {
+		return
+	}
+	for {
+		var m webMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			return
+		}
+		if !ws.call(func() { c.send(ws.handle(m)) }) {
+			return
+		}
+	}
+}
+
+// handle run a message from a tab. It must run in the frontend loop.
+func (ws *WebServer) handle(m webMessage) webMessage {
+	s := ws.session
+	var lines []string
+	var err error
+	switch m.Type {
+	case "say":
+		err = s.ShoutRoom(m.Room, m.Text)
+	case "open":
+		err = s.Conn.Send(igo.OpenRoomCommand(m.Room))
+	case "close":
+		err = s.Conn.Send(igo.CloseRoomCommand(m.Room))
+	case "history":
+		return ws.history(m.Room)
+	case "command":
+		lines, err = ws.commands.Execute(m.Text)
+	default:
+		err = fmt.Errorf("unknown message type %q", m.Type)
+	}
+	result := webMessage{Type: "result", Lines: lines}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// isLoopback return true when addr like "127.0.0.1:8080" listens only on this machine.
+// An empty host like ":8080" listens on every interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// runWeb is web mode which serve the browser UI on addr.
+// A non-loopback addr is refused unless allowRemote is set.
+func runWeb(s *Session, addr string, allowRemote bool) int {
+	if !allowRemote && !isLoopback(addr) {
+		fmt.Fprintf(os.Stderr, "Error: %s is not a loopback address. Use -web-remote to serve other machines\n", addr)
+		return 1
+	}
+	token, err := randomToken()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	if err := s.Connect(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+
+	commands := NewCommandSet()
+	s.RegisterCommands(commands)
+	web := NewWebServer(s, commands, token)
+	srv := &http.Server{Handler: web.Handler()}
+	go srv.Serve(ln)
+	defer srv.Close()
+	defer web.Close()
+	fmt.Fprintf(os.Stdout, "Open http://%s/?token=%s\n", ln.Addr().String(), token)
+
+	done := make(chan struct{})
+	defer close(done)
+	s.Conn.StartQueue(s.Settings.Send, done)
+	go s.Conn.Ping(done)
+	response := s.Conn.Receive(done)
+	s.Login()
+
+	for {
+		select {
+		case chunk, ok := <-response:
+			events := s.Events(chunk)
+			if !ok {
+				events = s.Disconnected()
+			}
+			for _, e := range events {
+				web.Publish(e)
+				if e.Type == igo.EventQuit {
+					return 0
+				}
+			}
+		case err := <-s.Conn.Errors():
+			web.broadcast(webMessage{Type: "result", Error: err.Error()})
+		case fn := <-web.Calls():
+			fn()
+		}
+	}
+}
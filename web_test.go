@@ -0,0 +1,173 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Neetless/iGoClient/igo"
+	"github.com/gorilla/websocket"
+)
+
+func TestWebServer(t *testing.T) {
+	s, written := newTestSession(t)
+	s.Events("ROOM_ADDED 3 carol 10 lobby\r\nOK OPEN_ROOM 3\r\nUSERS 3 bob\r\nMESSAGE 3 bob earlier\r\n")
+	cs := NewCommandSet()
+	s.RegisterCommands(cs)
+	web := NewWebServer(s, cs, "secret")
+	srv := httptest.NewServer(web.Handler())
+	defer srv.Close()
+
+	// Play the frontend loop. Events are published from it like runWeb.
+	events := make(chan Event)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case fn := <-web.Calls():
+				fn()
+			case e := <-events:
+				web.Publish(e)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "<title>iGoClient</title>") {
+		t.Errorf("Unexpected index page")
+	}
+
+	// The token of the printed URL becomes a cookie.
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err = noRedirect.Get(srv.URL + "/?token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	cookies := resp.Cookies()
+	if resp.StatusCode != http.StatusSeeOther || len(cookies) != 1 || cookies[0].Value != "secret" || !cookies[0].HttpOnly {
+		t.Errorf("Unexpected token response: %d %+v", resp.StatusCode, cookies)
+	}
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	for _, u := range []string{url, url + "?token=wrong"} {
+		if _, _, err := websocket.DefaultDialer.Dial(u, nil); err == nil {
+			t.Errorf("WebSocket without the token should be rejected: %s", u)
+		}
+	}
+	if conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Cookie": {webTokenCookie + "=secret"}}); err != nil {
+		t.Errorf("Token cookie should be accepted: %v", err)
+	} else {
+		conn.Close()
+	}
+	url += "?token=secret"
+	header := http.Header{"Origin": {"http://evil.example"}}
+	if _, _, err := websocket.DefaultDialer.Dial(url, header); err == nil {
+		t.Errorf("Cross origin WebSocket should be rejected")
+	}
+
+	var tabs []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var m webMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Type != "state" || m.Self != "me" || len(m.Rooms) != 1 || m.Rooms[0].Members[0] != "bob" {
+			t.Errorf("Unexpected state: %+v", m)
+		}
+		tabs = append(tabs, conn)
+	}
+
+	tabs[0].WriteJSON(webMessage{Type: "history", Room: 3})
+	var m webMessage
+	tabs[0].ReadJSON(&m)
+	if m.Type != "history" || len(m.Entries) != 1 || m.Entries[0].Body != "earlier" {
+		t.Errorf("Unexpected history: %+v", m)
+	}
+
+	tabs[1].WriteJSON(webMessage{Type: "say", Room: 3, Text: "hi bob"})
+	tabs[1].ReadJSON(&m)
+	if m.Type != "result" || m.Error != "" || written.String() != "GET_PROFILE bob\r\nSHOUT 3 hi bob\r\n" {
+		t.Errorf("Unexpected say result: %+v %q", m, written.String())
+	}
+	if entries := s.Chats.RoomEntries(3); len(entries) != 2 || !entries[1].Pending {
+		t.Errorf("Said text should be pending like the TUI: %+v", entries)
+	}
+	tabs[1].WriteJSON(webMessage{Type: "say", Room: 3, Text: "x\nREMOVE_ROOM 3"})
+	tabs[1].ReadJSON(&m)
+	if m.Error != igo.ErrMultiLine.Error() || strings.Contains(written.String(), "REMOVE_ROOM") {
+		t.Errorf("Multi-line say should be rejected: %+v %q", m, written.String())
+	}
+	tabs[1].WriteJSON(webMessage{Type: "command", Text: "/open x"})
+	tabs[1].ReadJSON(&m)
+	if m.Type != "result" || m.Error == "" {
+		t.Errorf("Unexpected command result: %+v", m)
+	}
+	for _, line := range []string{"/export 3 out.json", "/ignore bob", "/filters", "/raw LOGOUT"} {
+		tabs[1].WriteJSON(webMessage{Type: "command", Text: line})
+		tabs[1].ReadJSON(&m)
+		if !strings.Contains(m.Error, "unknown command") {
+			t.Errorf("%s should not be available: %+v", line, m)
+		}
+	}
+
+	events <- Event{Event: igo.ParseLine("MESSAGE 3 bob hello")}
+	for _, tab := range tabs {
+		if err := tab.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Type != "event" || m.Event.Text != "hello" {
+			t.Errorf("Unexpected event: %+v", m)
+		}
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	for addr, expected := range map[string]bool{
+		"127.0.0.1:8080": true,
+		"[::1]:8080":     true,
+		"localhost:8080": true,
+		":8080":          false,
+		"0.0.0.0:8080":   false,
+		"192.0.2.1:8080": false,
+		"8080":           false,
+	} {
+		if isLoopback(addr) != expected {
+			t.Errorf("isLoopback(%q) should be %v", addr, expected)
+		}
+	}
+}
+
+func TestWebServerClose(t *testing.T) {
+	s, _ := newTestSession(t)
+	web := NewWebServer(s, NewCommandSet(), "secret")
+	// Nobody runs calls like after the frontend loop returned.
+	result := make(chan bool)
+	go func() { result <- web.call(func() {}) }()
+	web.Close()
+	select {
+	case ok := <-result:
+		if ok {
+			t.Errorf("call should not run after Close")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("call should return after Close")
+	}
+}